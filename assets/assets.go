@@ -0,0 +1,10 @@
+// Package assets embeds the templates, stylesheets and scripts of the web UI
+// into the server binary.
+package assets
+
+import "embed"
+
+// Web holds everything below web/.
+//
+//go:embed web
+var Web embed.FS
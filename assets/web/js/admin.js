@@ -42,12 +42,44 @@ function fmtExp(ts) {
     return `<span style="font-size:12px;color:var(--text-muted);" title="${d.toLocaleString()}">${days}d ${hours}h</span>`;
 }
 
+function fmtActivation(ts) {
+    if (!isPending(ts)) return '<span style="color:var(--text-faint);font-size:12px;">now</span>';
+    const d = new Date(ts * 1000);
+    const diff = d - Date.now();
+    const days = Math.floor(diff / 86400000);
+    const hours = Math.floor((diff % 86400000) / 3600000);
+    return `<span style="font-size:12px;color:var(--text-muted);" title="${d.toLocaleString()}">in ${days}d ${hours}h</span>`;
+}
+
+function fmtAvailability(s) {
+    if (!s.availability) return '';
+    const text = s.availability + (s.time_zone ? ' · ' + s.time_zone : '');
+    return `<div class="schedule-text" title="Open only during these times">🕘 ${text}</div>`;
+}
+
+function isPending(ts) {
+    return !!ts && ts * 1000 > Date.now();
+}
+
 function fmtUses(u) {
     if (u === -1) return '<span style="font-size:15px;">∞</span>';
     if (u === 0) return pill('expired', '0');
     return `<span style="font-size:13px;">${u}</span>`;
 }
 
+function countsVisitors(s) {
+    return s.max_visitors > 0 || s.max_downloads_per_file > 0;
+}
+
+function fmtVisitors(s) {
+    const seen = Object.keys(s.visitors ?? {}).length;
+    const limit = s.max_visitors > 0 ? s.max_visitors : '∞';
+    const text = `👥 ${seen}/${limit}`;
+    if (s.max_visitors > 0 && seen >= s.max_visitors) return pill('expired', text);
+    const perFile = s.max_downloads_per_file > 0 ? ` · ${s.max_downloads_per_file} per file` : '';
+    return `<span style="font-size:13px;" title="Visitors seen / allowed${perFile}">${text}</span>`;
+}
+
 function pill(type, text) {
     return `<span class="pill pill-${type}">${text}</span>`;
 }
@@ -173,8 +205,9 @@ function updateStats(shares) {
         const s = shares[k];
         return s.expired || (s.expiration !== 0 && s.expiration < now) || s.uses === 0;
     });
+    const scheduled = keys.filter(k => !expired.includes(k) && isPending(shares[k].activation));
     document.getElementById('stat-total').textContent = keys.length;
-    document.getElementById('stat-active').textContent = keys.length - expired.length;
+    document.getElementById('stat-active').textContent = keys.length - expired.length - scheduled.length;
     document.getElementById('stat-expired').textContent = expired.length;
     document.getElementById('stat-upload').textContent = keys.filter(k => shares[k].allow_post).length;
 }
@@ -188,7 +221,7 @@ async function loadShares() {
         updateStats(shares);
 
         if (keys.length === 0) {
-            tbody.innerHTML = `<tr><td colspan="8" class="table-info"><span class="table-info-icon">📭</span>No shares yet. Add one above.</td></tr>`;
+            tbody.innerHTML = `<tr><td colspan="11" class="table-info"><span class="table-info-icon">📭</span>No shares yet. Add one above.</td></tr>`;
             return;
         }
 
@@ -222,9 +255,15 @@ async function loadShares() {
             // Uses (editable)
             const tdUses = document.createElement('td');
             tdUses.className = 'hide-sm editable-cell';
-            tdUses.title = 'Click to edit';
-            tdUses.innerHTML = fmtUses(s.uses);
-            tdUses.addEventListener('click', () => makeEditable(tdUses, s.uses, 'number', val => updateShare(sub, { uses: val })));
+            if (countsVisitors(s)) {
+                tdUses.title = 'Click to manage visitors';
+                tdUses.innerHTML = fmtVisitors(s);
+                tdUses.addEventListener('click', () => editVisitors(sub, s));
+            } else {
+                tdUses.title = 'Click to edit';
+                tdUses.innerHTML = fmtUses(s.uses);
+                tdUses.addEventListener('click', () => makeEditable(tdUses, s.uses, 'number', val => updateShare(sub, { uses: val })));
+            }
 
             // Expiration (editable, datetime-local)
             const tdExp = document.createElement('td');
@@ -233,6 +272,18 @@ async function loadShares() {
             tdExp.innerHTML = fmtExp(s.expiration);
             tdExp.addEventListener('click', () => makeEditableExpiration(tdExp, s.expiration, val => updateShare(sub, { expiration: val })));
 
+            // Activation (editable; same syntax as the CLI's -activate)
+            const pending = isPending(s.activation);
+            const actValue = pending ? String(s.activation) : '';
+            const tdAct = document.createElement('td');
+            tdAct.className = 'hide-sm editable-cell';
+            tdAct.title = 'Click to edit — e.g. 2h, 3d, 1w or a unix timestamp (empty = now)';
+            tdAct.innerHTML = fmtActivation(s.activation) + fmtAvailability(s);
+            tdAct.addEventListener('click', () => makeEditable(tdAct, actValue, 'text', val => {
+                if (val.trim() === actValue) tdAct.innerHTML = fmtActivation(s.activation) + fmtAvailability(s);
+                else updateShare(sub, { activation: val.trim() || 'now' });
+            }));
+
             // Upload toggle
             const tdUpload = document.createElement('td');
             tdUpload.className = 'editable-cell';
@@ -245,12 +296,29 @@ async function loadShares() {
                 updateShare(sub, { allow_post: next });
             });
 
+            // Archive formats (editable, comma-separated; empty = all)
+            const formats = s.archive_formats ?? [];
+            const tdArchive = document.createElement('td');
+            tdArchive.className = 'hide-sm editable-cell';
+            tdArchive.title = 'Click to edit — e.g. zip, tar.gz (empty = all)';
+            tdArchive.textContent = formats.length ? formats.join(', ') : 'all';
+            tdArchive.addEventListener('click', () => makeEditable(tdArchive, formats.join(', '), 'text', val =>
+                updateShare(sub, { archive_formats: val.split(/[\s,]+/).filter(Boolean) })));
+
+            // Cache-Control policy (editable; empty = default)
+            const tdCache = document.createElement('td');
+            tdCache.className = 'hide-sm editable-cell';
+            tdCache.title = 'Click to edit — e.g. public, max-age=3600 (empty = private, no-cache)';
+            tdCache.textContent = s.cache_control || 'default';
+            tdCache.addEventListener('click', () => makeEditable(tdCache, s.cache_control ?? '', 'text', val =>
+                updateShare(sub, { cache_control: val.trim() })));
+
             // Status toggle
             const tdStatus = document.createElement('td');
             tdStatus.className = 'editable-cell';
             tdStatus.title = 'Click to toggle';
             tdStatus.style.cursor = 'pointer';
-            tdStatus.innerHTML = pill(isExp ? 'expired' : 'active', isExp ? 'expired' : 'active');
+            tdStatus.innerHTML = isExp ? pill('expired', 'expired') : pending ? pill('scheduled', 'scheduled') : pill('active', 'active');
             tdStatus.addEventListener('click', () => {
                 const next = !isExp;
                 tdStatus.innerHTML = pill(next ? 'expired' : 'active', next ? 'expired' : 'active');
@@ -259,14 +327,19 @@ async function loadShares() {
 
             // Delete
             const tdDel = document.createElement('td');
-            tdDel.innerHTML = `<button class="btn btn-danger-ghost" onclick="deleteShare('${sub}')">Delete</button>`;
-
-            tr.append(tdSub, tdLock, tdPath, tdUses, tdExp, tdUpload, tdStatus, tdDel);
+            tdDel.innerHTML = `<button class="btn btn-ghost" data-action="link" title="Signed direct link to a file">Link</button> <button class="btn btn-ghost" data-action="schedule" title="Recurring opening times">Schedule</button> <button class="btn btn-ghost" data-action="visitors" title="Per-visitor limits and counters">Visitors</button> <button class="btn btn-ghost" data-action="perms" title="What visitors may do">Permissions</button> <button class="btn btn-ghost" data-action="brand" title="Title, message, accent color and logo">Branding</button> <button class="btn btn-danger-ghost" onclick="deleteShare('${sub}')">Delete</button>`;
+            tdDel.querySelector('[data-action="link"]').addEventListener('click', () => openLinkModal(sub));
+            tdDel.querySelector('[data-action="schedule"]').addEventListener('click', () => editSchedule(sub, s));
+            tdDel.querySelector('[data-action="visitors"]').addEventListener('click', () => editVisitors(sub, s));
+            tdDel.querySelector('[data-action="perms"]').addEventListener('click', () => editPermissions(sub, s));
+            tdDel.querySelector('[data-action="brand"]').addEventListener('click', () => editBranding(sub, s));
+
+            tr.append(tdSub, tdLock, tdPath, tdUses, tdExp, tdAct, tdUpload, tdArchive, tdCache, tdStatus, tdDel);
             tbody.appendChild(tr);
         });
 
     } catch (err) {
-        tbody.innerHTML = `<tr><td colspan="8" class="table-info" style="color:var(--danger);"><span class="table-info-icon">⚠</span>Failed to load: ${err.message}</td></tr>`;
+        tbody.innerHTML = `<tr><td colspan="11" class="table-info" style="color:var(--danger);"><span class="table-info-icon">⚠</span>Failed to load: ${err.message}</td></tr>`;
     }
 }
 
@@ -277,6 +350,7 @@ async function addShare() {
     const expiration = parseInt(document.getElementById('f-expiration').value);
     const allowPost = document.getElementById('f-allowpost').checked;
     const password = document.getElementById('f-password').value;
+    const activation = document.getElementById('f-activation').value.trim();
 
     if (!path) { showStatus('status-shares', 'Path is required', 'err'); return; }
     if (!subpath) {
@@ -286,6 +360,7 @@ async function addShare() {
 
     const body = { subpath, path, uses, expiration, allow_post: allowPost };
     if (password) body.password = password;
+    if (activation) body.activation = activation;
 
     try {
         await apiFetch(API, {
@@ -309,7 +384,7 @@ async function deleteShare(sub) {
 }
 
 function resetForm() {
-    ['f-subpath', 'f-path', 'f-password'].forEach(id => document.getElementById(id).value = '');
+    ['f-subpath', 'f-path', 'f-password', 'f-activation'].forEach(id => document.getElementById(id).value = '');
     document.getElementById('f-uses').value = '-1';
     document.getElementById('f-expiration').value = '0';
     document.getElementById('f-expiration-dt').value = '';
@@ -362,6 +437,17 @@ function changePassword() {
     });
 }
 
+async function saveDefaultLanguage() {
+    try {
+        await apiFetch('/admin/api/settings/default_language', {
+            method: 'PATCH',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ defaultLanguage: document.getElementById('s-default-lang').value })
+        });
+        showStatus('status-lang', 'Default language saved', 'ok');
+    } catch (err) { showStatus('status-lang', err.message, 'err'); }
+}
+
 async function pruneExpired() {
     if (!confirm('Delete all expired shares from data.json?')) return;
     try {
@@ -3,6 +3,21 @@ const _dc = {};
 const $ = id => _dc[id] || (_dc[id] = document.getElementById(id));
 const $q = s => document.querySelector(s);
 
+// ── Translations ──────────────────────────────────────
+// I18N holds the page language's messages, set inline by share.html.
+// %s and %d are replaced by the arguments in order.
+const _messages = typeof I18N === "object" ? I18N : {};
+const t = (key, ...args) => {
+    let i = 0;
+    return (_messages[key] ?? key).replace(/%[sd]/g, () => args[i++]);
+};
+const tn = (key, n, ...args) => t(`${key}.${n === 1 ? "one" : "other"}`, n, ...args);
+
+function setLanguage(lang) {
+    document.cookie = `lang=${lang}; path=/; max-age=31536000; samesite=lax`;
+    location.reload();
+}
+
 const CHUNK_SIZE = 5 * 1024 * 1024;
 const MAX_PARALLEL = 4;
 
@@ -24,7 +39,7 @@ function openLightbox(mediaEl) {
     img.style.display = vid.style.display = "none";
     currentMediaIndex = mediaElements.indexOf(mediaEl);
     if (mediaEl.tagName === "IMG") {
-        img.src = mediaEl.src;
+        img.src = mediaEl.dataset.full || mediaEl.src; // grid images are thumbnails
         img.style.display = "block";
     } else {
         vid.querySelector("source").src = mediaEl.querySelector("source").src;
@@ -62,20 +77,24 @@ function setupMediaContainer(container) {
     }
 }
 
-// ── ZIP ───────────────────────────────────────────────
-function downloadAsZip(e) {
-    e.preventDefault();
-    const a = Object.assign(document.createElement("a"), {
-        href: location.origin + location.pathname + "?download=zip",
-        download: "archive.zip",
-    });
-    document.body.append(a);
-    a.click();
-    a.remove();
+// ── Archives ──────────────────────────────────────────
+// Checked boxes belong to #selection-form, which POSTs their paths with ?download=<format>.
+function updateSelection() {
+    const btn = $("selection-download");
+    if (!btn) return;
+    const n = document.querySelectorAll(".select-box:checked").length;
+    $("selection-count").textContent = n;
+    btn.hidden = n === 0;
+}
+
+// value is the ?download= query, e.g. "tar.gz", "zip&mode=store" or
+// "zip&manifest=sha256".
+function setArchiveFormat(value) {
+    $("archive-download").href = $("selection-form").action = "?download=" + value;
 }
 
 // ── Timestamps ────────────────────────────────────────
-const fmtTs = ts => new Date(+ts * 1000).toLocaleString(navigator.language || "en-US", {
+const fmtTs = ts => new Date(+ts * 1000).toLocaleString(document.documentElement.lang, {
     weekday: "long", year: "2-digit", month: "2-digit", day: "2-digit", hour: "2-digit", minute: "2-digit",
 });
 
@@ -85,13 +104,21 @@ if (_tsEl?.dataset.timestamp) _tsEl.innerText = fmtTs(_tsEl.dataset.timestamp);
 const _timeLeftEl = $("time-left");
 if (_timeLeftEl) {
     const diff = new Date(+_timeLeftEl.dataset.timestamp * 1000) - Date.now();
-    _timeLeftEl.innerText = diff <= 0 ? "expired"
-        : `${Math.floor(diff / 86400000)}d ${Math.floor(diff % 86400000 / 3600000)}h ${Math.floor(diff % 3600000 / 60000)}m`;
+    _timeLeftEl.innerText = diff <= 0 ? t("expired")
+        : t("time_left", Math.floor(diff / 86400000), Math.floor(diff % 86400000 / 3600000), Math.floor(diff % 3600000 / 60000));
 }
 
 const _usesEl = $("uses");
 if (_usesEl?.dataset.uses != null) _usesEl.innerText = _usesEl.dataset.uses;
 
+const fmtDate = ts => new Date(+ts * 1000).toLocaleString(document.documentElement.lang, {
+    year: "2-digit", month: "2-digit", day: "2-digit", hour: "2-digit", minute: "2-digit",
+});
+document.querySelectorAll("time[data-mtime]").forEach(el => {
+    el.textContent = fmtDate(el.dataset.mtime);
+    el.dateTime = new Date(+el.dataset.mtime * 1000).toISOString();
+});
+
 // ── Upload toast toggle ───────────────────────────────
 let uploadToastOpen = false;
 
@@ -125,8 +152,8 @@ const formatBytes = b => _fmt(b);
 const formatSpeed = b => _fmt(b, "/s");
 
 const formatEta = s => !isFinite(s) || s <= 0 ? null
-    : s < 60 ? `~${Math.ceil(s)}s remaining`
-        : `~${Math.floor(s / 60)}m ${Math.ceil(s % 60)}s remaining`;
+    : s < 60 ? t("eta_seconds", Math.ceil(s))
+        : t("eta_minutes", Math.floor(s / 60), Math.ceil(s % 60));
 
 const EXT_MAP = {
     jpg: "JPG", jpeg: "JPG", png: "PNG", gif: "GIF", webp: "WEBP", svg: "SVG",
@@ -196,7 +223,7 @@ function updatePauseButton() {
     if (!btn) return;
     const paused = uploadControlState === "paused";
     btn.textContent = paused ? "▶" : "⏸";
-    btn.title = paused ? "Resume all" : "Pause all";
+    btn.title = paused ? t("resume_all") : t("pause_all");
     btn.classList.toggle("pause-active", paused);
     btn.style.display = uploadControlState === "idle" ? "none" : "";
 }
@@ -211,8 +238,8 @@ window.togglePauseUpload = function () {
         uploadControlState = "paused";
         if (dot) dot.style.animationPlayState = "paused";
         $("upload-toast-speed").textContent = "";
-        $("upload-toast-eta").textContent = "Paused";
-        $q(".upload-toast-title").textContent = "Paused";
+        $("upload-toast-eta").textContent = t("paused");
+        $q(".upload-toast-title").textContent = t("paused");
     } else if (uploadControlState === "paused") {
         uploadControlState = "uploading";
         pauseResolvers.splice(0).forEach(r => r());
@@ -224,25 +251,25 @@ window.togglePauseUpload = function () {
 window.abortAllUploads = function () {
     if (uploadControlState === "idle") return;
     uploadControlState = "cancelled";
-    cancelReject?.(new Error("cancelled")); // erst rejecten, bevor Worker resolve() erreichen können
+    cancelReject?.(new Error("cancelled")); // reject first, before workers can reach resolve()
     fileStates.forEach((_, i) => abortFile(i));
     pauseResolvers.splice(0).forEach(r => r());
 };
 
 // ── File list rendering ───────────────────────────────
 const FILE_STATUS = {
-    done: ["--done", "done"],
-    error: ["--error", "failed"],
+    done: ["--done", "status_done"],
+    error: ["--error", "status_failed"],
     uploading: ["--uploading", null],
-    skipped: ["--skipped", "skipped"],
-    cancelled: ["--cancelled", "cancelled"],
+    skipped: ["--skipped", "status_skipped"],
+    cancelled: ["--cancelled", "status_cancelled"],
 };
 
 function renderFileList() {
     const list = $("upload-toast-file-list");
     if (!list) return;
     list.innerHTML = fileStates.map((f, i) => {
-        const [cls, txt] = FILE_STATUS[f.status] ?? ["--pending", "waiting"];
+        const [cls, key] = FILE_STATUS[f.status] ?? ["--pending", "status_waiting"];
         const active = f.status === "uploading" || f.status === "pending";
         const paused = !!filePausedFlags[i];
         return `<div class="upload-file-row" data-index="${i}">
@@ -256,13 +283,13 @@ function renderFileList() {
                 ? `<div class="upload-file-minibar"><div class="upload-file-minibar-fill" style="width:${f.progress}%"></div></div>` : ""}
                 ${f.error ? `<span class="upload-file-error">${escapeHtml(f.error)}</span>` : ""}
             </div>
-            <span class="upload-file-status upload-file-status${cls}">${txt ?? `${f.progress}%`}</span>
+            <span class="upload-file-status upload-file-status${cls}">${key ? t(key) : `${f.progress}%`}</span>
             ${active ? `
             <div class="upload-file-actions">
                 <button class="upload-file-btn upload-file-btn--pause${paused ? " is-paused" : ""}"
-                    onclick="togglePauseFile(${i})" title="${paused ? "Resume" : "Pause"}">${paused ? "▶" : "⏸"}</button>
+                    onclick="togglePauseFile(${i})" title="${paused ? t("resume") : t("pause")}">${paused ? "▶" : "⏸"}</button>
                 <button class="upload-file-btn upload-file-btn--abort"
-                    onclick="abortFile(${i})" title="Abort">✕</button>
+                    onclick="abortFile(${i})" title="${t("abort")}">✕</button>
             </div>` : ""}
         </div>`;
     }).join("");
@@ -333,11 +360,11 @@ function finishUploadProgress(succeeded, failed, totalBytes, durationMs) {
 
     const bt = $("upload-badge-text"), tt = $q(".upload-toast-title");
     const te = $("upload-toast-eta"), sub = $("upload-toast-sub");
-    if (bt) bt.textContent = failed > 0 ? `${succeeded}/${succeeded + failed}` : "Done";
-    if (tt) tt.textContent = failed > 0 ? `${succeeded} uploaded, ${failed} failed` : "Upload complete";
-    if (te && durationMs) te.textContent = `Completed in ${(durationMs / 1000).toFixed(1)}s`;
+    if (bt) bt.textContent = failed > 0 ? `${succeeded}/${succeeded + failed}` : t("done");
+    if (tt) tt.textContent = failed > 0 ? t("upload_partial", succeeded, failed) : t("upload_complete");
+    if (te && durationMs) te.textContent = t("completed_in", (durationMs / 1000).toFixed(1));
     if (sub && totalBytes && durationMs)
-        sub.textContent = `${formatBytes(totalBytes)} · avg ${formatSpeed(totalBytes / (durationMs / 1000))}`;
+        sub.textContent = t("average", formatBytes(totalBytes), formatSpeed(totalBytes / (durationMs / 1000)));
 
     stripe(100, ok);
     fileStates.forEach(f => { if (f.status !== "error" && f.status !== "skipped") { f.status = "done"; f.progress = 100; } });
@@ -353,8 +380,8 @@ function handleUploadError(reason) {
     _finalize("var(--color-error)");
     const bt = $("upload-badge-text"), tt = $q(".upload-toast-title"),
         te = $("upload-toast-eta");
-    if (bt) bt.textContent = "Error";
-    if (tt) tt.textContent = `Upload failed: ${reason}`;
+    if (bt) bt.textContent = t("error");
+    if (tt) tt.textContent = t("upload_failed", reason);
     if (te) te.textContent = "";
 
     stripe(100, false);
@@ -363,7 +390,7 @@ function handleUploadError(reason) {
 
     uploadToastOpen = true;
     $("upload-toast")?.classList.add("visible");
-    $("uploadButtonText").textContent = "Upload";
+    $("uploadButtonText").textContent = t("upload");
 }
 
 // ── Chunked upload core ───────────────────────────────
@@ -382,26 +409,26 @@ function sendChunk(base, uploadId, index, blob, onProgress, fileIndex) {
         };
         xhr.onload = () => { cleanup(); (xhr.status === 202 || xhr.status === 204) ? resolve() : reject(new Error(`chunk ${index}: HTTP ${xhr.status}`)); };
         xhr.onerror = () => { cleanup(); reject(new Error("network error")); };
-        xhr.onabort = () => { cleanup(); resolve(); }; // Worker prüft danach selbst fileSkipFlags
+        xhr.onabort = () => { cleanup(); resolve(); }; // the worker checks fileSkipFlags itself afterwards
         xhr.open("POST", `${base}/chunk`);
         xhr.send(fd);
     });
 }
 
-async function computeUploadHash(file) {
-    const buf = await crypto.subtle.digest("SHA-256",
-        new TextEncoder().encode(`${file.name}:${file.size}:${file.lastModified}`)
-    );
+async function computeUploadHash(file, dir) {
+    // The folder is part of the key so the same file can go to two folders.
+    const key = `${file.name}:${file.size}:${file.lastModified}` + (dir === "/" ? "" : `:${dir}`);
+    const buf = await crypto.subtle.digest("SHA-256", new TextEncoder().encode(key));
     return Array.from(new Uint8Array(buf), b => b.toString(16).padStart(2, "0")).join("").slice(0, 32);
 }
 
-async function uploadFileChunked(fileIndex, file, base, onChunkDone) {
+async function uploadFileChunked(fileIndex, file, base, dir, onChunkDone) {
     const totalChunks = Math.ceil(file.size / CHUNK_SIZE) || 1;
-    const uploadId = await computeUploadHash(file);
+    const uploadId = await computeUploadHash(file, dir);
 
     const initResp = await fetch(`${base}/chunk-init`, {
         method: "POST",
-        body: new URLSearchParams({ uploadId, filename: file.name, totalChunks }),
+        body: new URLSearchParams({ uploadId, filename: file.name, totalChunks, fileSize: file.size, chunkSize: CHUNK_SIZE, dir }),
     });
     if (!initResp.ok) throw new Error(`init failed: HTTP ${initResp.status}`);
     const { missingChunks } = await initResp.json();
@@ -446,6 +473,17 @@ document.addEventListener("DOMContentLoaded", () => {
     });
     document.querySelectorAll(".media-container").forEach(setupMediaContainer);
 
+    document.querySelectorAll(".select-box").forEach(cb => {
+        cb.addEventListener("click", e => e.stopPropagation()); // don't open the lightbox
+        cb.addEventListener("change", updateSelection);
+    });
+    document.querySelectorAll(".entry-action").forEach(btn => {
+        btn.addEventListener("click", e => e.stopPropagation()); // same as the checkboxes
+    });
+    // Browsers restore form state on back navigation.
+    updateSelection();
+    if ($("archive-format")) setArchiveFormat($("archive-format").value);
+
     $("lightbox-close")?.addEventListener("click", closeLightbox);
     $("lightbox")?.addEventListener("click", e => { if (e.target === e.currentTarget) closeLightbox(); });
     document.addEventListener("keydown", e => {
@@ -471,12 +509,12 @@ document.addEventListener("DOMContentLoaded", () => {
             te = $("upload-toast-eta");
 
         if (tp) { tp.textContent = "100%"; tp.classList.add("done"); }
-        if (tt) tt.textContent = failed > 0 ? `${succeeded} uploaded, ${failed} failed` : "Upload complete";
-        if (bt) bt.textContent = failed > 0 ? `${succeeded}/${succeeded + failed}` : "Done";
+        if (tt) tt.textContent = failed > 0 ? t("upload_partial", succeeded, failed) : t("upload_complete");
+        if (bt) bt.textContent = failed > 0 ? `${succeeded}/${succeeded + failed}` : t("done");
         if (dot) { dot.style.animation = "none"; dot.style.background = color; }
         if (sub && totalBytes && durationMs)
-            sub.textContent = `${formatBytes(totalBytes)} · avg ${formatSpeed(totalBytes / (durationMs / 1000))}`;
-        if (te && durationMs) te.textContent = `Completed in ${(durationMs / 1000).toFixed(1)}s`;
+            sub.textContent = t("average", formatBytes(totalBytes), formatSpeed(totalBytes / (durationMs / 1000)));
+        if (te && durationMs) te.textContent = t("completed_in", (durationMs / 1000).toFixed(1));
 
         $("upload-badge")?.classList.add("visible");
         uploadToastOpen = toastWasOpen ?? false;
@@ -489,6 +527,36 @@ document.addEventListener("DOMContentLoaded", () => {
     // ── Upload handler ────────────────────────────────
     const subpath = location.pathname.split("/").filter(Boolean)[0] ?? "";
     const chunkBase = `${location.origin}/${subpath}`;
+    // The folder being listed, relative to the share; uploads and new folders go here.
+    const dirPath = decodeURIComponent(location.pathname.slice(subpath.length + 1)) || "/";
+
+    // ── File operations ───────────────────────────────
+    async function fileOp(op, body) {
+        const resp = await fetch(`${chunkBase}/${op}`, {
+            method: "POST",
+            headers: { "Content-Type": "application/json" },
+            body: JSON.stringify(body),
+        });
+        if (resp.ok) return true;
+        alert(t("op_failed", (await resp.text()).trim() || `HTTP ${resp.status}`));
+        return false;
+    }
+
+    window.createFolder = async function () {
+        const name = prompt(t("new_folder_prompt"))?.trim();
+        if (name && await fileOp("mkdir", { dir: dirPath, name })) location.reload();
+    };
+
+    window.renameEntry = async function (btn) {
+        const { path, name: oldName } = btn.dataset;
+        const name = prompt(t("rename_prompt", oldName), oldName)?.trim();
+        if (name && name !== oldName && await fileOp("rename", { path, name })) location.reload();
+    };
+
+    window.deleteEntry = async function (btn) {
+        const { path, name } = btn.dataset;
+        if (confirm(t("delete_confirm", name)) && await fileOp("delete", { path })) location.reload();
+    };
 
     window.submitUpload = async function () {
         if (uploadControlState !== "idle" || !$("uploadForm")) return;
@@ -506,9 +574,9 @@ document.addEventListener("DOMContentLoaded", () => {
         pauseResolvers = [];
         updatePauseButton();
 
-        $q(".upload-toast-title").textContent = `Uploading ${files.length} file${files.length !== 1 ? "s" : ""}…`;
+        $q(".upload-toast-title").textContent = tn("uploading_files", files.length);
         setUploadProgress(0, 0, totalBytes);
-        $("uploadButtonText").textContent = "Uploading…";
+        $("uploadButtonText").textContent = t("uploading");
         if (!uploadToastOpen) { uploadToastOpen = true; $("upload-toast")?.classList.add("visible"); }
 
         await fetch("/api/log", {
@@ -536,8 +604,8 @@ document.addEventListener("DOMContentLoaded", () => {
                     renderFileList();
 
                     try {
-                        await uploadFileChunked(i, files[i], chunkBase, chunkBytes => {
-                            if (uploadControlState !== "uploading") return; // kein UI-Update nach Cancel
+                        await uploadFileChunked(i, files[i], chunkBase, dirPath, chunkBytes => {
+                            if (uploadControlState !== "uploading") return; // no UI updates after cancelling
                             fileBytesUploaded += chunkBytes;
                             uploadStats.bytesUploaded += chunkBytes;
                             fileStates[i].progress = Math.round(fileBytesUploaded / files[i].size * 100);
@@ -561,21 +629,21 @@ document.addEventListener("DOMContentLoaded", () => {
         } catch {
             _finalize("var(--text-faint)");
             const bt = $("upload-badge-text"); if (bt) bt.textContent = "—";
-            $q(".upload-toast-title").textContent = "Upload cancelled — chunks saved for resume";
+            $q(".upload-toast-title").textContent = t("upload_cancelled");
             fileStates.forEach(f => {
                 if (f.status === "uploading" || f.status === "pending")
                     f.status = "cancelled";
             });
             renderFileList();
             stripe(100, false);
-            $("uploadButtonText").textContent = "Upload";
+            $("uploadButtonText").textContent = t("upload");
             return;
         }
 
         cancelReject = null;
         const durationMs = Date.now() - uploadStats.startTime;
         if (succeeded === 0 && failed > 0)
-            handleUploadError(`All ${failed} file${failed !== 1 ? "s" : ""} failed`);
+            handleUploadError(tn("all_failed", failed));
         else
             finishUploadProgress(succeeded, failed, totalBytes, durationMs);
     };
@@ -5,7 +5,9 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"net"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
 	"strconv"
@@ -68,6 +70,51 @@ func fmtExpiration(ts int64) string {
 	return fmt.Sprintf("%dd %dh", int(diff.Hours())/24, int(diff.Hours())%24)
 }
 
+func fmtActivation(ts int64) string {
+	if ts <= time.Now().Unix() {
+		return colorGray + "now" + colorReset
+	}
+	diff := time.Until(time.Unix(ts, 0))
+	return fmt.Sprintf("in %dd %dh", int(diff.Hours())/24, int(diff.Hours())%24)
+}
+
+func fmtAvailability(spec, zone string) string {
+	if spec == "" {
+		return colorGray + "always" + colorReset
+	}
+	if zone != "" {
+		return spec + " (" + zone + ")"
+	}
+	return spec
+}
+
+// parseAvailability validates availability windows and a time zone and
+// returns the windows in canonical form; "always" clears them. It exits on
+// errors.
+func parseAvailability(spec, zone string) string {
+	if spec == "" || spec == "always" {
+		if _, err := time.LoadLocation(zone); err != nil {
+			GoLog.Errorf("Unknown time zone %q", zone)
+			os.Exit(1)
+		}
+		return ""
+	}
+	a, err := shared.ParseAvailability(spec, zone)
+	if err != nil {
+		GoLog.Errorf("Invalid availability: %v", err)
+		os.Exit(1)
+	}
+	return a.String()
+}
+
+// checkSchedule exits if a share would expire before it goes live.
+func checkSchedule(s shared.FileData) {
+	if s.Activation != 0 && s.Expiration != 0 && s.Activation >= s.Expiration {
+		GoLog.Error("The share would expire before it goes live")
+		os.Exit(1)
+	}
+}
+
 func fmtUses(u int) string {
 	switch u {
 	case shared.UnlimitedUses:
@@ -79,6 +126,45 @@ func fmtUses(u int) string {
 	}
 }
 
+// fmtVisitors shows the visitors a share has seen out of the ones it
+// allows, for shares that count uses per visitor.
+func fmtVisitors(s shared.FileData) string {
+	limit := "inf"
+	if s.MaxVisitors > 0 {
+		limit = strconv.Itoa(s.MaxVisitors)
+	}
+	text := fmt.Sprintf("%d/%s", len(s.Visitors), limit)
+	if s.MaxVisitors > 0 && len(s.Visitors) >= s.MaxVisitors {
+		return colorRed + text + colorReset
+	}
+	return text
+}
+
+func fmtVisitorLimits(maxVisitors, perFile int) string {
+	if maxVisitors == 0 && perFile == 0 {
+		return colorGray + "not counted" + colorReset
+	}
+	text := "any number of visitors"
+	if maxVisitors > 0 {
+		text = fmt.Sprintf("%d visitors", maxVisitors)
+	}
+	if perFile > 0 {
+		text += fmt.Sprintf(", %d downloads of each file", perFile)
+	}
+	return text
+}
+
+// parseVisitorLimit parses the value of a per-visitor limit flag; 0 means
+// no limit. It exits on errors.
+func parseVisitorLimit(name, value string) int {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		GoLog.Errorf("Invalid %s value %q — must be a whole number (0 = unlimited)", name, value)
+		os.Exit(1)
+	}
+	return n
+}
+
 func fmtUpload(on bool) string {
 	if on {
 		return colorGreen + "on" + colorReset
@@ -185,11 +271,13 @@ func cmdList(asJSON bool) {
 	keys := sortedKeys(d.Files)
 	total := len(keys)
 
-	var active, deactivated, withUpload, withPassword int
+	var active, scheduled, deactivated, withUpload, withPassword int
 	for _, k := range keys {
 		s := d.Files[k]
 		if shared.IsExpired(s) {
 			deactivated++
+		} else if shared.IsPending(s) {
+			scheduled++
 		} else {
 			active++
 		}
@@ -202,10 +290,11 @@ func cmdList(asJSON bool) {
 	}
 
 	fmt.Printf(
-		"\n%sSHARES%s  total: %s%d%s  active: %s%d%s  deactivated: %s%d%s  upload: %s%d%s  password-protected: %s%d%s\n",
+		"\n%sSHARES%s  total: %s%d%s  active: %s%d%s  scheduled: %s%d%s  deactivated: %s%d%s  upload: %s%d%s  password-protected: %s%d%s\n",
 		colorBold+colorCyan, colorReset,
 		colorBold, total, colorReset,
 		colorGreen, active, colorReset,
+		colorCyan, scheduled, colorReset,
 		colorRed, deactivated, colorReset,
 		colorBlue, withUpload, colorReset,
 		colorYellow, withPassword, colorReset,
@@ -244,15 +333,26 @@ func cmdList(asJSON bool) {
 		case shared.IsExpired(s):
 			subColor = colorRed
 			status = colorRed + "expired" + colorReset
+		case shared.IsPending(s):
+			subColor = colorCyan
+			status = colorCyan + "scheduled" + colorReset + " (" + fmtActivation(s.Activation) + ")"
+		case !shared.IsAvailable(s, time.Now()):
+			subColor = colorCyan
+			status = colorCyan + "closed" + colorReset + " (" + s.Availability + ")"
 		case pathMissing:
 			subColor = colorYellow
 			status = colorYellow + "path missing" + colorReset
 		}
 
+		uses := fmtUses(s.Uses)
+		if shared.CountsVisitors(s) {
+			uses = fmtVisitors(s)
+		}
+
 		fmt.Printf("%s%-22s%s %-26s %6s  %-14s %-8s %-5s %s\n",
 			subColor, "/"+sub, colorReset,
 			truncatePath(s.Path, 25),
-			fmtUses(s.Uses),
+			uses,
 			fmtExpiration(s.Expiration),
 			fmtUpload(s.AllowPost),
 			fmtPassword(s.Password),
@@ -262,11 +362,15 @@ func cmdList(asJSON bool) {
 	fmt.Println()
 }
 
-func cmdAdd(subpath, filePath string, uses int, expiration int64, allowPost bool, password string) {
+func cmdAdd(subpath, filePath string, uses, maxVisitors, perFile int, expiration, activation int64, availability, timeZone string, allowPost bool, password string) {
 	if filePath == "" {
 		helpAdd()
 		os.Exit(1)
 	}
+	if maxVisitors < 0 || perFile < 0 {
+		GoLog.Errorf("-visitors and -per-file must not be negative")
+		os.Exit(1)
+	}
 
 	absPath, err := filepath.Abs(filePath)
 	if err != nil {
@@ -301,16 +405,27 @@ func cmdAdd(subpath, filePath string, uses int, expiration int64, allowPost bool
 		UploadTime: time.Now().Unix(),
 		Uses:       uses,
 		Expiration: expiration,
+		Activation: activation,
 		AllowPost:  allowPost,
 		Password:   hashedPw,
+
+		Availability: parseAvailability(availability, timeZone),
+		TimeZone:     timeZone,
+
+		MaxVisitors:         maxVisitors,
+		MaxDownloadsPerFile: perFile,
 	}
+	checkSchedule(d.Files[subpath])
 	mustSave(d)
 
 	fmt.Printf("%s+%s Share added:\n", colorGreen, colorReset)
 	fmt.Printf("  Subpath  : /%s\n", subpath)
 	fmt.Printf("  Path     : %s\n", absPath)
 	fmt.Printf("  Uses     : %s\n", fmtUses(uses))
+	fmt.Printf("  Visitors : %s\n", fmtVisitorLimits(maxVisitors, perFile))
 	fmt.Printf("  Expires  : %s\n", fmtExpiration(expiration))
+	fmt.Printf("  Live     : %s\n", fmtActivation(activation))
+	fmt.Printf("  Open     : %s\n", fmtAvailability(d.Files[subpath].Availability, timeZone))
 	fmt.Printf("  Upload   : %s\n", fmtUpload(allowPost))
 	fmt.Printf("  Password : %s\n", fmtPassword(hashedPw))
 	GoLog.Infof("Share added: /%s -> %s", subpath, absPath)
@@ -345,7 +460,7 @@ func cmdDelete(subpath string, yes bool) {
 	GoLog.Infof("Share deleted: /%s", subpath)
 }
 
-func cmdEdit(subpath, newSubpath, newFile, newUsesStr, newExpiresStr, newUploadStr, newActiveStr, newPassword string, clearPassword bool) {
+func cmdEdit(subpath, newSubpath, newFile, newUsesStr, newVisitorsStr, newPerFileStr string, resetVisitors bool, newExpiresStr, newActivateStr, newAvailability, newTimeZone, newUploadStr, newActiveStr, newPassword string, clearPassword bool) {
 	if subpath == "" {
 		helpEdit()
 		os.Exit(1)
@@ -399,6 +514,28 @@ func cmdEdit(subpath, newSubpath, newFile, newUsesStr, newExpiresStr, newUploadS
 		}
 	}
 
+	if newVisitorsStr != "" || newPerFileStr != "" {
+		maxVisitors, perFile := s.MaxVisitors, s.MaxDownloadsPerFile
+		if newVisitorsStr != "" {
+			maxVisitors = parseVisitorLimit("-visitors", newVisitorsStr)
+		}
+		if newPerFileStr != "" {
+			perFile = parseVisitorLimit("-per-file", newPerFileStr)
+		}
+		if maxVisitors != s.MaxVisitors || perFile != s.MaxDownloadsPerFile {
+			fmt.Printf("  Visitors : %s -> %s\n",
+				fmtVisitorLimits(s.MaxVisitors, s.MaxDownloadsPerFile), fmtVisitorLimits(maxVisitors, perFile))
+			s.MaxVisitors, s.MaxDownloadsPerFile = maxVisitors, perFile
+			changed = true
+		}
+	}
+
+	if resetVisitors && len(s.Visitors) > 0 {
+		fmt.Printf("  Visitors : %d forgotten, counters reset\n", len(s.Visitors))
+		s.Visitors = nil
+		changed = true
+	}
+
 	if newExpiresStr != "" {
 		ts, err := shared.ParseExpiration(newExpiresStr)
 		if err != nil {
@@ -416,6 +553,37 @@ func cmdEdit(subpath, newSubpath, newFile, newUsesStr, newExpiresStr, newUploadS
 		}
 	}
 
+	if newActivateStr != "" {
+		ts, err := shared.ParseActivation(newActivateStr)
+		if err != nil {
+			GoLog.Errorf("Invalid activation: %v", err)
+			os.Exit(1)
+		}
+		if ts != s.Activation {
+			fmt.Printf("  Live     : %s -> %s\n", fmtActivation(s.Activation), fmtActivation(ts))
+			s.Activation = ts
+			changed = true
+		}
+	}
+
+	if newAvailability != "" || newTimeZone != "" {
+		spec, zone := s.Availability, s.TimeZone
+		if newAvailability != "" {
+			spec = newAvailability
+		}
+		if newTimeZone == "local" {
+			zone = ""
+		} else if newTimeZone != "" {
+			zone = newTimeZone
+		}
+		spec = parseAvailability(spec, zone)
+		if spec != s.Availability || zone != s.TimeZone {
+			fmt.Printf("  Open     : %s -> %s\n", fmtAvailability(s.Availability, s.TimeZone), fmtAvailability(spec, zone))
+			s.Availability, s.TimeZone = spec, zone
+			changed = true
+		}
+	}
+
 	if newUploadStr != "" {
 		newUpload, err := parseBoolValue(newUploadStr)
 		if err != nil {
@@ -424,7 +592,7 @@ func cmdEdit(subpath, newSubpath, newFile, newUsesStr, newExpiresStr, newUploadS
 		}
 		if newUpload != s.AllowPost {
 			fmt.Printf("  Upload   : %s -> %s\n", fmtUpload(s.AllowPost), fmtUpload(newUpload))
-			s.AllowPost = newUpload
+			shared.SetPermission(&s, shared.PermUpload, newUpload)
 			changed = true
 		}
 	}
@@ -479,6 +647,7 @@ func cmdEdit(subpath, newSubpath, newFile, newUsesStr, newExpiresStr, newUploadS
 		return
 	}
 
+	checkSchedule(s)
 	if targetSubpath != subpath {
 		delete(d.Files, subpath)
 	}
@@ -568,6 +737,65 @@ func cmdPrune(yes bool) {
 	GoLog.Infof("Pruned %d expired share(s)", len(toDelete))
 }
 
+func cmdLink(subpath, filePath string, expiration int64, uses int, ip, host string) {
+	if subpath == "" {
+		helpLink()
+		os.Exit(1)
+	}
+	if expiration <= time.Now().Unix() {
+		GoLog.Errorf("Links need an expiration in the future")
+		os.Exit(1)
+	}
+	if uses < 0 {
+		GoLog.Errorf("-uses must not be negative")
+		os.Exit(1)
+	}
+	if ip != "" && net.ParseIP(ip) == nil {
+		GoLog.Errorf("Invalid IP address %q", ip)
+		os.Exit(1)
+	}
+
+	d := mustLoad()
+	s, exists := d.Files[subpath]
+	if !exists {
+		GoLog.Errorf("Subpath /%s not found", subpath)
+		os.Exit(1)
+	}
+	rel := path.Clean("/" + filePath)
+	info, err := os.Stat(filepath.Join(s.Path, filepath.FromSlash(rel)))
+	if err != nil {
+		GoLog.Errorf("%s not found in /%s: %v", rel, subpath, err)
+		os.Exit(1)
+	}
+	if info.IsDir() {
+		GoLog.Errorf("%s is a folder — links can only point to files", rel)
+		os.Exit(1)
+	}
+
+	created, err := shared.EnsureLinkSecret(d)
+	if err != nil {
+		GoLog.Errorf("Failed to generate link secret: %v", err)
+		os.Exit(1)
+	}
+	if created {
+		mustSave(d)
+	}
+
+	link := shared.SignedLink{Subpath: subpath, Path: rel, Expires: expiration, MaxUses: uses, IP: ip}
+	maxUses := uses
+	if maxUses == 0 {
+		maxUses = shared.UnlimitedUses
+	}
+	fmt.Printf("%s+%s Link to /%s%s:\n", colorGreen, colorReset, subpath, rel)
+	fmt.Printf("  URL      : %s%s\n", strings.TrimSuffix(host, "/"), link.URL(d.LinkSecret))
+	fmt.Printf("  Expires  : %s\n", fmtExpiration(expiration))
+	fmt.Printf("  Uses     : %s\n", fmtUses(maxUses))
+	if ip != "" {
+		fmt.Printf("  IP       : %s\n", ip)
+	}
+	GoLog.Infof("Signed link created: /%s%s", subpath, rel)
+}
+
 func cmdSetPassword(currentPassword, newPassword string) {
 	d := mustLoad()
 
@@ -635,14 +863,22 @@ OPTIONS
   -subpath, -s       URL subpath (omit for random)
   -file,    -f       File or folder path on the server  [required]
   -uses,    -u       Max downloads; -1 = unlimited  (default: -1)
+  -visitors          Max distinct visitors; counts uses per visitor instead of -uses
+  -per-file          Max downloads of each file per visitor; counts uses per visitor
   -expires, -e       Expiration: 24h, 7d, 2w, 3m, 1y, unix timestamp, or 0/never
+  -activate, -a      Go live later: 24h, 7d, 2w, 3m, 1y, unix timestamp, or 0/now
+  -availability, -av Only open during these windows, e.g. "mon-fri 09:00-17:00; sat 10:00-14:00"
+  -timezone, -tz     Time zone of the windows, e.g. Europe/Berlin  (default: the server's)
   -upload            Allow uploads to this share
   -password, -pw     Protect the share with a password
 
 EXAMPLES
   fileshare add -s docs -f /home/user/docs
   fileshare add -f /tmp/report.pdf -e 7d -u 10
+  fileshare add -s class -f /srv/slides -visitors 30 -per-file 2
   fileshare add -f /srv/uploads -upload
+  fileshare add -s release -f /srv/release -a 2d -e 30d
+  fileshare add -s office -f /srv/office -av "mon-fri 08:00-18:00" -tz Europe/Berlin
   fileshare add -f /tmp/secret.zip -pw hunter2
 
 `)
@@ -670,7 +906,13 @@ OPTIONS
   -new-subpath,   -n    Rename to a different subpath
   -file,          -f    Change the server file/folder path
   -uses,          -u    Change max uses (-1 = unlimited)
+  -visitors             Change max distinct visitors (0 = unlimited)
+  -per-file             Change max downloads of each file per visitor (0 = unlimited)
+  -reset-visitors       Forget all visitors and their download counts
   -expires,       -e    Change expiration (duration, unix timestamp, or 0/never)
+  -activate,      -a    Change when the share goes live (duration, unix timestamp, or 0/now)
+  -availability,  -av   Change the opening windows, e.g. "mon-fri 09:00-17:00"; "always" removes them
+  -timezone,      -tz   Change the windows' time zone; "local" for the server's
   -upload               Change upload permission (true/false/yes/no/on/off)
   -active               Enable or disable the share (true/false)
   -password,      -pw   Set or change the share password
@@ -679,10 +921,13 @@ OPTIONS
 EXAMPLES
   fileshare edit -s music -n music2024
   fileshare edit -s docs  -e 30d -u 50
+  fileshare edit -s class -visitors 40 -reset-visitors
   fileshare edit -s temp  -upload=false
   fileshare edit -s priv  -pw newpassword
   fileshare edit -s priv  -clear-password
   fileshare edit -s temp  -active=false
+  fileshare edit -s promo -a 2d
+  fileshare edit -s office -av "mon-fri 09:00-17:00; sat 10:00-12:00"
 
 `)
 }
@@ -702,6 +947,30 @@ OPTIONS
 `)
 }
 
+func helpLink() {
+	fmt.Print(`
+USAGE
+  fileshare link -s <subpath> -f <file> [options]
+
+Prints a signed direct link to one file of a share. The link works without
+the share's password until it expires, but not once the share has expired.
+
+OPTIONS
+  -subpath, -s   Share containing the file  [required]
+  -file,    -f   File path relative to the share (omit for a single-file share)
+  -expires, -e   Link expiration: 24h, 7d, 2w, 3m, 1y or unix timestamp  (default: 24h)
+  -uses,    -u   Max downloads; 0 = unlimited  (default: 0)
+  -ip            Only accept the link from this IP address
+  -host          Server address to put in front, e.g. https://files.example.com
+
+EXAMPLES
+  fileshare link -s docs -f /reports/q1.pdf
+  fileshare link -s docs -f /reports/q1.pdf -e 7d -u 3 -host https://files.example.com
+  fileshare link -s docs -f /big.iso -ip 203.0.113.7
+
+`)
+}
+
 func helpSetUsername() {
 	fmt.Print(`
 USAGE
@@ -762,6 +1031,7 @@ COMMANDS
   enable        Re-enable a disabled share
   disable       Disable a share without deleting it
   prune         Delete all expired shares
+  link          Print a signed direct link to a file in a share
   setpassword   Set the admin password
   setusername   Set the admin username
   help          Show this help or help for a specific command
@@ -780,6 +1050,7 @@ EXAMPLES
   fileshare disable -s temp
   fileshare enable  -s temp
   fileshare prune -y
+  fileshare link -s docs -f /report.pdf -e 7d -u 3
   fileshare setpassword
   fileshare setusername -u myname
   fileshare help add
@@ -836,12 +1107,20 @@ func main() {
 		fs.StringVar(filePath, "f", "", "")
 		uses := fs.Int("uses", -1, "")
 		fs.IntVar(uses, "u", -1, "")
+		maxVisitors := fs.Int("visitors", 0, "")
+		perFile := fs.Int("per-file", 0, "")
 		expires := fs.String("expires", "", "")
 		fs.StringVar(expires, "e", "", "")
 		fs.StringVar(expires, "t", "", "") // legacy alias
 		allowPost := fs.Bool("upload", false, "")
 		fs.BoolVar(allowPost, "allow-post", false, "") // legacy alias
 		fs.BoolVar(allowPost, "p", false, "")          // legacy alias
+		activate := fs.String("activate", "", "")
+		fs.StringVar(activate, "a", "", "")
+		availability := fs.String("availability", "", "")
+		fs.StringVar(availability, "av", "", "")
+		timeZone := fs.String("timezone", "", "")
+		fs.StringVar(timeZone, "tz", "", "")
 		password := fs.String("password", "", "")
 		fs.StringVar(password, "pw", "", "")
 		_ = fs.Parse(args)
@@ -854,7 +1133,12 @@ func main() {
 			GoLog.Errorf("Invalid expiration: %v", err)
 			os.Exit(1)
 		}
-		cmdAdd(*subpath, *filePath, *uses, exp, *allowPost, *password)
+		act, err := shared.ParseActivation(*activate)
+		if err != nil {
+			GoLog.Errorf("Invalid activation: %v", err)
+			os.Exit(1)
+		}
+		cmdAdd(*subpath, *filePath, *uses, *maxVisitors, *perFile, exp, act, *availability, *timeZone, *allowPost, *password)
 
 	// ── delete ───────────────────────────────────────────────────────────────
 	case "delete", "del", "remove", "rm":
@@ -879,8 +1163,17 @@ func main() {
 		fs.StringVar(newFile, "f", "", "")
 		newUses := fs.String("uses", "", "")
 		fs.StringVar(newUses, "u", "", "")
+		newVisitors := fs.String("visitors", "", "")
+		newPerFile := fs.String("per-file", "", "")
+		resetVisitors := fs.Bool("reset-visitors", false, "")
 		newExpires := fs.String("expires", "", "")
 		fs.StringVar(newExpires, "e", "", "")
+		newActivate := fs.String("activate", "", "")
+		fs.StringVar(newActivate, "a", "", "")
+		newAvailability := fs.String("availability", "", "")
+		fs.StringVar(newAvailability, "av", "", "")
+		newTimeZone := fs.String("timezone", "", "")
+		fs.StringVar(newTimeZone, "tz", "", "")
 		newUpload := fs.String("upload", "", "")
 		fs.StringVar(newUpload, "allow-post", "", "") // legacy alias
 		newActive := fs.String("active", "", "")
@@ -892,7 +1185,7 @@ func main() {
 		if *subpath == "" && *oldSubpath != "" {
 			*subpath = *oldSubpath
 		}
-		cmdEdit(*subpath, *newSubpath, *newFile, *newUses, *newExpires, *newUpload, *newActive, *newPassword, *clearPassword)
+		cmdEdit(*subpath, *newSubpath, *newFile, *newUses, *newVisitors, *newPerFile, *resetVisitors, *newExpires, *newActivate, *newAvailability, *newTimeZone, *newUpload, *newActive, *newPassword, *clearPassword)
 
 	// ── enable / disable ─────────────────────────────────────────────────────
 	case "enable":
@@ -917,6 +1210,28 @@ func main() {
 		_ = fs.Parse(args)
 		cmdPrune(*yes)
 
+	// ── link ─────────────────────────────────────────────────────────────────
+	case "link":
+		fs := flag.NewFlagSet("link", flag.ExitOnError)
+		subpath := fs.String("subpath", "", "")
+		fs.StringVar(subpath, "s", "", "")
+		filePath := fs.String("file", "", "")
+		fs.StringVar(filePath, "f", "", "")
+		expires := fs.String("expires", "24h", "")
+		fs.StringVar(expires, "e", "24h", "")
+		uses := fs.Int("uses", 0, "")
+		fs.IntVar(uses, "u", 0, "")
+		ip := fs.String("ip", "", "")
+		host := fs.String("host", "", "")
+		_ = fs.Parse(args)
+
+		exp, err := shared.ParseExpiration(*expires)
+		if err != nil {
+			GoLog.Errorf("Invalid expiration: %v", err)
+			os.Exit(1)
+		}
+		cmdLink(*subpath, *filePath, exp, *uses, *ip, *host)
+
 	// ── setpassword ──────────────────────────────────────────────────────────
 	case "setpassword", "setpass", "password":
 		fs := flag.NewFlagSet("setpassword", flag.ExitOnError)
@@ -955,6 +1270,8 @@ func main() {
 				helpSetPassword()
 			case "prune", "cleanup":
 				helpPrune()
+			case "link":
+				helpLink()
 			default:
 				printHelp()
 			}
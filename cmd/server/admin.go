@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/Wirezat/GoLog"
@@ -44,7 +45,7 @@ func handleAdminLogin(w http.ResponseWriter, r *http.Request) {
 
 	switch r.Method {
 	case http.MethodGet:
-		serveGatePage(w, gateData{
+		serveGatePage(w, r, gateData{
 			FormAction:   "/admin/login",
 			ShowUsername: true,
 		})
@@ -58,7 +59,7 @@ func handleAdminLogin(w http.ResponseWriter, r *http.Request) {
 
 		if !usernameOK || !passwordOK {
 			GoLog.Warnf("handleAdminLogin: failed login attempt from %s", clientIP(r))
-			serveGatePage(w, gateData{
+			serveGatePage(w, r, gateData{
 				FormAction:       "/admin/login",
 				ShowUsername:     true,
 				WrongCredentials: true,
@@ -139,3 +140,80 @@ func handleAdminSettingsChunkInactivityTimeout(w http.ResponseWriter, r *http.Re
 	storage.SetInactivityTimeout(time.Duration(body.Seconds) * time.Second)
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// handleAdminSettingsListingPageSize updates how many entries a directory
+// listing shows per page.
+// PATCH /admin/api/settings/listing_page_size
+// Body: {"listingPageSize": 500}
+func handleAdminSettingsListingPageSize(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		PageSize int `json:"listingPageSize"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.PageSize < 1 {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	config, err := shared.LoadConfig()
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	config.ListingPageSize = body.PageSize
+	if err := shared.SaveConfig(config); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAdminSettingsDefaultLanguage sets the language of visitor pages for
+// visitors whose browser prefers none of the supported languages.
+// PATCH /admin/api/settings/default_language
+// Body: {"defaultLanguage": "de"}
+func handleAdminSettingsDefaultLanguage(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Language string `json:"defaultLanguage"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || !isSupportedLanguage(body.Language) {
+		http.Error(w, "Bad Request: supported languages are "+strings.Join(supportedLanguages, ", "), http.StatusBadRequest)
+		return
+	}
+	config, err := shared.LoadConfig()
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	config.DefaultLanguage = body.Language
+	if err := shared.SaveConfig(config); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAdminSettingsChunkAssemblyMode switches how chunked uploads are assembled.
+// PATCH /admin/api/settings/chunk_assembly_mode
+// Body: {"chunkAssemblyMode": "sparse"}  ("concat" or "sparse")
+func handleAdminSettingsChunkAssemblyMode(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Mode string `json:"chunkAssemblyMode"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil ||
+		(body.Mode != assemblyConcat && body.Mode != assemblySparse) {
+		http.Error(w, "Bad Request: mode must be concat or sparse", http.StatusBadRequest)
+		return
+	}
+	config, err := shared.LoadConfig()
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	config.ChunkAssemblyMode = body.Mode
+	if err := shared.SaveConfig(config); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	// Applies to new upload sessions only — running ones keep their mode.
+	storage.SetAssemblyMode(body.Mode)
+	w.WriteHeader(http.StatusNoContent)
+}
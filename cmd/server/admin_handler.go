@@ -1,11 +1,15 @@
 package main
 
 import (
+	"cmp"
 	"encoding/json"
 	"fmt"
+	"html/template"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/Wirezat/GoLog"
@@ -48,6 +52,110 @@ func subpathOrErr(w http.ResponseWriter, r *http.Request) (string, bool) {
 	return sp, true
 }
 
+func archiveFormatsOrErr(w http.ResponseWriter, formats []string) bool {
+	for _, f := range formats {
+		if !slices.Contains(shared.ArchiveFormats, f) {
+			http.Error(w, "unknown archive format: "+f, http.StatusBadRequest)
+			return false
+		}
+	}
+	return true
+}
+
+// permissionsOrErr validates a share's visitor permissions.
+func permissionsOrErr(w http.ResponseWriter, perms []string) bool {
+	if err := shared.ValidatePermissions(perms); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// activationOrErr parses when a share goes live: a unix timestamp, or a
+// string in the syntax of shared.ParseActivation such as "2d".
+func activationOrErr(w http.ResponseWriter, raw json.RawMessage) (int64, bool) {
+	var ts int64
+	if err := json.Unmarshal(raw, &ts); err == nil {
+		return ts, true
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		http.Error(w, "activation must be a unix timestamp or a duration such as 2d", http.StatusBadRequest)
+		return 0, false
+	}
+	ts, err := shared.ParseActivation(s)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return 0, false
+	}
+	return ts, true
+}
+
+// availabilityOrErr validates a share's availability windows and time zone
+// and returns the windows in the form shared.Availability writes them.
+func availabilityOrErr(w http.ResponseWriter, spec, zone string) (string, bool) {
+	if strings.TrimSpace(spec) == "" {
+		if _, err := time.LoadLocation(zone); err != nil {
+			http.Error(w, fmt.Sprintf("unknown time zone %q", zone), http.StatusBadRequest)
+			return "", false
+		}
+		return "", true
+	}
+	a, err := shared.ParseAvailability(spec, zone)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", false
+	}
+	return a.String(), true
+}
+
+// visitorLimitsOrErr validates a share's per-visitor limits.
+func visitorLimitsOrErr(w http.ResponseWriter, maxVisitors, maxDownloads int) bool {
+	if maxVisitors < 0 || maxDownloads < 0 {
+		http.Error(w, "visitor limits must not be negative", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// scheduleOrErr rejects shares that would expire before they go live.
+func scheduleOrErr(w http.ResponseWriter, fd shared.FileData) bool {
+	if fd.Activation != 0 && fd.Expiration != 0 && fd.Activation >= fd.Expiration {
+		http.Error(w, "activation must be before expiration", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// cacheControlOrErr validates a share's Cache-Control policy; empty is the default.
+func cacheControlOrErr(w http.ResponseWriter, cc string) bool {
+	if cc == "" {
+		return true
+	}
+	if err := shared.ValidateCacheControl(cc); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// brandingOrErr validates a share's accent color and logo; empty values are allowed.
+func brandingOrErr(w http.ResponseWriter, accent, logo string) bool {
+	if accent != "" {
+		if err := shared.ValidateAccentColor(accent); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return false
+		}
+	}
+	if logo != "" {
+		if err := shared.ValidateLogo(logo); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return false
+		}
+	}
+	return true
+}
+
 func methodOnly(w http.ResponseWriter, r *http.Request, method string) bool {
 	if r.Method != method {
 		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
@@ -61,9 +169,38 @@ func jsonResponse(w http.ResponseWriter, v any) {
 	json.NewEncoder(w).Encode(v)
 }
 
-func handleAdminUI(w http.ResponseWriter, r *http.Request)  { http.ServeFile(w, r, adminHtmlPath) }
-func handleAdminCSS(w http.ResponseWriter, r *http.Request) { http.ServeFile(w, r, adminCssPath) }
-func handleAdminJS(w http.ResponseWriter, r *http.Request)  { http.ServeFile(w, r, adminJsPath) }
+var (
+	adminTemplate     *template.Template
+	adminTemplateErr  error
+	adminTemplateOnce sync.Once
+)
+
+func loadAdminTemplate() (*template.Template, error) {
+	adminTemplateOnce.Do(func() {
+		adminTemplate, adminTemplateErr = parseTemplate("admin.html", adminHtmlPath, nil)
+	})
+	return adminTemplate, adminTemplateErr
+}
+
+// handleAdminUI renders the admin page, which links its assets by content hash.
+func handleAdminUI(w http.ResponseWriter, r *http.Request) {
+	config, ok := configOrErr(w)
+	if !ok {
+		return
+	}
+	tmpl, err := loadAdminTemplate()
+	if err != nil {
+		GoLog.Errorf("failed to load admin template: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "private, no-cache")
+	data := struct{ DefaultLanguage string }{config.DefaultLanguage}
+	if err := tmpl.Execute(w, data); err != nil {
+		GoLog.Errorf("failed to render admin template: %v", err)
+	}
+}
 
 func handleAdminUptime(w http.ResponseWriter, r *http.Request) {
 	if !methodOnly(w, r, http.MethodGet) {
@@ -88,14 +225,42 @@ func handleAdminShares(w http.ResponseWriter, r *http.Request) {
 		var req struct {
 			Subpath string `json:"subpath"`
 			shared.FileData
+			Activation json.RawMessage `json:"activation"`
 		}
 		if !decodeOrErr(w, r, &req) {
 			return
 		}
+		if req.Activation != nil {
+			ts, ok := activationOrErr(w, req.Activation)
+			if !ok {
+				return
+			}
+			req.FileData.Activation = ts
+		}
+		if !scheduleOrErr(w, req.FileData) {
+			return
+		}
+		spec, ok := availabilityOrErr(w, req.Availability, req.TimeZone)
+		if !ok {
+			return
+		}
+		req.FileData.Availability = spec
+		if !visitorLimitsOrErr(w, req.MaxVisitors, req.MaxDownloadsPerFile) {
+			return
+		}
+		// Counters start empty; they are the server's to keep.
+		req.FileData.Visitors = nil
 		if req.Subpath == "" || req.Path == "" {
 			http.Error(w, "subpath and path are required", http.StatusBadRequest)
 			return
 		}
+		if !archiveFormatsOrErr(w, req.ArchiveFormats) || !cacheControlOrErr(w, req.CacheControl) ||
+			!brandingOrErr(w, req.AccentColor, req.Logo) || !permissionsOrErr(w, req.Permissions) {
+			return
+		}
+		if len(req.Permissions) > 0 {
+			shared.SetPermissions(&req.FileData, req.Permissions)
+		}
 		// Hash the share password before storing, if one was provided.
 		if req.FileData.Password != "" {
 			hashed, err := shared.HashPassword(req.FileData.Password)
@@ -120,6 +285,7 @@ func handleAdminShares(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		GoLog.Infof("share created: %s → %s", req.Subpath, req.Path)
+		wakeScheduler()
 		w.WriteHeader(http.StatusCreated)
 
 	case http.MethodPatch:
@@ -135,6 +301,27 @@ func handleAdminShares(w http.ResponseWriter, r *http.Request) {
 			AllowPost  *bool   `json:"allow_post"`
 			Expired    *bool   `json:"expired"`
 			Password   *string `json:"password"`
+
+			// A unix timestamp or a duration; 0 or "now" activates the share.
+			Activation json.RawMessage `json:"activation"`
+			// Empty availability opens the share at all times again.
+			Availability *string `json:"availability"`
+			TimeZone     *string `json:"time_zone"`
+
+			MaxVisitors         *int  `json:"max_visitors"`
+			MaxDownloadsPerFile *int  `json:"max_downloads_per_file"`
+			ResetVisitors       *bool `json:"reset_visitors"`
+
+			Permissions  *[]string `json:"permissions"`
+			OwnFilesOnly *bool     `json:"own_files_only"`
+
+			ArchiveFormats *[]string `json:"archive_formats"`
+			CacheControl   *string   `json:"cache_control"`
+
+			Title       *string `json:"title"`
+			Message     *string `json:"message"`
+			AccentColor *string `json:"accent_color"`
+			Logo        *string `json:"logo"`
 		}
 
 		if !decodeOrErr(w, r, &patch) {
@@ -176,9 +363,68 @@ func handleAdminShares(w http.ResponseWriter, r *http.Request) {
 			entry.Expiration = *patch.Expiration
 		}
 
+		if patch.Activation != nil {
+			ts, ok := activationOrErr(w, patch.Activation)
+			if !ok {
+				return
+			}
+			if ts == 0 {
+				track("activation", "<now>")
+			} else {
+				track("activation", strconv.FormatInt(ts, 10))
+			}
+			entry.Activation = ts
+		}
+
+		if patch.Availability != nil || patch.TimeZone != nil {
+			spec, zone := entry.Availability, entry.TimeZone
+			if patch.Availability != nil {
+				spec = *patch.Availability
+			}
+			if patch.TimeZone != nil {
+				zone = strings.TrimSpace(*patch.TimeZone)
+			}
+			spec, ok := availabilityOrErr(w, spec, zone)
+			if !ok {
+				return
+			}
+			if patch.Availability != nil {
+				track("availability", cmp.Or(spec, "<always>"))
+			}
+			if patch.TimeZone != nil {
+				track("time_zone", cmp.Or(zone, "<server>"))
+			}
+			entry.Availability, entry.TimeZone = spec, zone
+		}
+
+		if patch.MaxVisitors != nil || patch.MaxDownloadsPerFile != nil {
+			maxVisitors, maxDownloads := entry.MaxVisitors, entry.MaxDownloadsPerFile
+			if patch.MaxVisitors != nil {
+				maxVisitors = *patch.MaxVisitors
+			}
+			if patch.MaxDownloadsPerFile != nil {
+				maxDownloads = *patch.MaxDownloadsPerFile
+			}
+			if !visitorLimitsOrErr(w, maxVisitors, maxDownloads) {
+				return
+			}
+			if patch.MaxVisitors != nil {
+				track("max_visitors", strconv.Itoa(maxVisitors))
+			}
+			if patch.MaxDownloadsPerFile != nil {
+				track("max_downloads_per_file", strconv.Itoa(maxDownloads))
+			}
+			entry.MaxVisitors, entry.MaxDownloadsPerFile = maxVisitors, maxDownloads
+		}
+
+		if patch.ResetVisitors != nil && *patch.ResetVisitors {
+			track("visitors", "<reset>")
+			entry.Visitors = nil
+		}
+
 		if patch.AllowPost != nil {
 			track("allow_post", strconv.FormatBool(*patch.AllowPost))
-			entry.AllowPost = *patch.AllowPost
+			shared.SetPermission(&entry, shared.PermUpload, *patch.AllowPost)
 		}
 
 		if patch.Expired != nil {
@@ -203,11 +449,84 @@ func handleAdminShares(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
+		if patch.Permissions != nil {
+			if !permissionsOrErr(w, *patch.Permissions) {
+				return
+			}
+			if len(*patch.Permissions) == 0 {
+				// An empty list restores the default: read, and upload if allow_post.
+				track("permissions", "<default>")
+				entry.Permissions = nil
+			} else {
+				shared.SetPermissions(&entry, *patch.Permissions)
+				track("permissions", strings.Join(entry.Permissions, ","))
+			}
+		}
+
+		if patch.OwnFilesOnly != nil {
+			track("own_files_only", strconv.FormatBool(*patch.OwnFilesOnly))
+			entry.OwnFilesOnly = *patch.OwnFilesOnly
+		}
+
+		if patch.ArchiveFormats != nil {
+			// An empty list offers every format again.
+			if !archiveFormatsOrErr(w, *patch.ArchiveFormats) {
+				return
+			}
+			track("archive_formats", strings.Join(*patch.ArchiveFormats, ","))
+			entry.ArchiveFormats = *patch.ArchiveFormats
+		}
+
+		if patch.CacheControl != nil {
+			// An empty policy restores DefaultCacheControl.
+			cc := strings.TrimSpace(*patch.CacheControl)
+			if !cacheControlOrErr(w, cc) {
+				return
+			}
+			track("cache_control", cc)
+			entry.CacheControl = cc
+		}
+
+		// Empty branding values restore the built-in look.
+		if patch.Title != nil {
+			track("title", *patch.Title)
+			entry.Title = strings.TrimSpace(*patch.Title)
+		}
+
+		if patch.Message != nil {
+			track("message", fmt.Sprintf("%d bytes", len(*patch.Message)))
+			entry.Message = *patch.Message
+		}
+
+		if patch.AccentColor != nil || patch.Logo != nil {
+			accent, logo := entry.AccentColor, entry.Logo
+			if patch.AccentColor != nil {
+				accent = strings.TrimSpace(*patch.AccentColor)
+			}
+			if patch.Logo != nil {
+				logo = strings.TrimSpace(*patch.Logo)
+			}
+			if !brandingOrErr(w, accent, logo) {
+				return
+			}
+			if patch.AccentColor != nil {
+				track("accent_color", accent)
+			}
+			if patch.Logo != nil {
+				track("logo", logo)
+			}
+			entry.AccentColor, entry.Logo = accent, logo
+		}
+
 		if len(changes) == 0 {
 			http.Error(w, "no fields to update", http.StatusBadRequest)
 			return
 		}
 
+		if (patch.Activation != nil || patch.Expiration != nil) && !scheduleOrErr(w, entry) {
+			return
+		}
+
 		config.Files[subpath] = entry
 
 		if !saveOrErr(w, config) {
@@ -215,6 +534,9 @@ func handleAdminShares(w http.ResponseWriter, r *http.Request) {
 		}
 
 		GoLog.Infof("%s updated: %s", subpath, strings.Join(changes, ", "))
+		if patch.Activation != nil || patch.Expiration != nil {
+			wakeScheduler()
+		}
 
 	case http.MethodDelete:
 		subpath, ok := subpathOrErr(w, r)
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Wirezat/GoLog"
+	"github.com/Wirezat/fileshare/pkg/shared"
+	"golang.org/x/sync/semaphore"
+)
+
+// archiveWriter streams an archive of paths to w. Entry names are relative
+// to baseDir, which must contain every path. Writers stop early once ctx is done.
+type archiveWriter func(ctx context.Context, w http.ResponseWriter, archiveName, baseDir string, paths []string)
+
+// archiveWriters maps each ?download= format to its writer.
+var archiveWriters = map[string]archiveWriter{
+	"zip":     zipAndServe,
+	"tar":     tarWriter(tarPlain),
+	"tar.gz":  tarWriter(tarGzip),
+	"tar.zst": tarWriter(tarZstd),
+}
+
+// walkRegularFiles calls fn for every regular file below paths, with its
+// slash-separated name relative to baseDir. Symlinks to files are followed and
+// reported with the target's FileInfo; anything else that isn't a regular
+// file is skipped, and so are hidden entries, which the share doesn't serve.
+// Walk errors are logged, errors from fn stop the walk.
+func walkRegularFiles(ctx context.Context, baseDir string, paths []string, fn func(path, name string, info os.FileInfo) error) error {
+	for _, root := range paths {
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			if err != nil {
+				GoLog.Warnf("walk %s: %v", path, err)
+				return nil
+			}
+			if skip, err := skipHidden(path, root, info); skip {
+				return err
+			}
+			if info.Mode()&os.ModeSymlink != 0 {
+				if info, err = os.Stat(path); err != nil {
+					return nil
+				}
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			rel, err := filepath.Rel(baseDir, path)
+			if err != nil {
+				return nil
+			}
+			return fn(path, filepath.ToSlash(rel), info)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// skipHidden reports whether a walk below root should leave out path, as
+// hidden entries are neither listed nor served. err is what the walk
+// function should return for it: filepath.SkipDir for a hidden folder.
+func skipHidden(path, root string, info os.FileInfo) (bool, error) {
+	if path == root || !strings.HasPrefix(info.Name(), ".") {
+		return false, nil
+	}
+	if info.IsDir() {
+		return true, filepath.SkipDir
+	}
+	return true, nil
+}
+
+// archiveSlots limits how many archives and checksum manifests are built at
+// once; further requests wait in line until a slot frees up or the client
+// gives up. Sized from Config.MaxConcurrentArchives in main.
+var archiveSlots *semaphore.Weighted
+
+// acquireArchiveSlot takes a build slot, queueing if none is free. It returns
+// false if the client disconnected while waiting. On true, the caller must
+// release the slot with archiveSlots.Release(1).
+func acquireArchiveSlot(r *http.Request, ctx *requestContext) bool {
+	if archiveSlots.TryAcquire(1) {
+		return true
+	}
+	GoLog.Infof("build for /%s queued: all %d slots busy", ctx.subpath, ctx.config.MaxConcurrentArchives)
+	if err := archiveSlots.Acquire(r.Context(), 1); err != nil {
+		GoLog.Infof("build for /%s: client left the queue", ctx.subpath)
+		return false
+	}
+	return true
+}
+
+// serveArchive handles ?download=<format> for a directory. GET archives the
+// whole directory; POST archives the selection in the body (see selectionRoots).
+// ?download=zip&mode=store serves an uncompressed, resumable ZIP instead
+// (see serveStoreZip), and ?download=zip&manifest=sha256 embeds a SHA256SUMS
+// file in the archive. GET archives carry an ETag of their contents (see
+// archiveETag), so a client holding an unchanged copy gets a 304.
+func serveArchive(w http.ResponseWriter, r *http.Request, ctx *requestContext) {
+	format := r.URL.Query().Get("download")
+	write, ok := archiveWriters[format]
+	if !ok {
+		http.Error(w, "Unknown archive format", http.StatusBadRequest)
+		return
+	}
+	if !slices.Contains(shared.OfferedArchiveFormats(ctx.fileData), format) {
+		http.Error(w, "Archive format not offered for this share", http.StatusForbidden)
+		return
+	}
+
+	q := r.URL.Query()
+	storeMode := format == "zip" && q.Get("mode") == "store"
+	manifest := q.Get("manifest")
+	if manifest != "" && (manifest != "sha256" || format != "zip" || storeMode) {
+		http.Error(w, "Manifests can only be embedded in compressed ZIP downloads (manifest=sha256)", http.StatusBadRequest)
+		return
+	}
+
+	name := filepath.Base(ctx.diskPath)
+	roots := []string{ctx.diskPath}
+	if r.Method == http.MethodPost {
+		var ok bool
+		if roots, ok = selectionRoots(w, r, ctx); !ok {
+			return
+		}
+		name += "-selection"
+		if len(roots) == 1 {
+			name = filepath.Base(roots[0])
+		}
+		GoLog.Infof("archive selection /%s: %d path(s) below %s", ctx.subpath, len(roots), ctx.diskPath)
+	}
+	if !acquireArchiveSlot(r, ctx) {
+		return
+	}
+	if storeMode {
+		// Only laying out a store-mode ZIP walks the tree; sending it reads
+		// the files like any download, so the slot is freed in between. The
+		// ZIP sets its own strong ETag for resuming (see serveStoreZip).
+		z, err := newStoreZip(r.Context(), ctx.diskPath, roots)
+		archiveSlots.Release(1)
+		if err != nil {
+			GoLog.Infof("store zip cancelled: %s: %v", name, err)
+			return
+		}
+		serveStoreZip(w, r, name, z)
+		return
+	}
+	defer archiveSlots.Release(1)
+
+	// The validator walks the whole tree, so it takes a slot like the build.
+	if r.Method == http.MethodGet {
+		etag, err := archiveETag(r.Context(), r, ctx.diskPath, roots)
+		if err != nil {
+			return
+		}
+		if checkNotModified(w, r, etag, time.Time{}) {
+			return
+		}
+	}
+
+	switch {
+	case manifest != "":
+		zipPathsAndServe(r.Context(), w, name, ctx.diskPath, roots, true)
+	default:
+		write(r.Context(), w, name, ctx.diskPath, roots)
+	}
+}
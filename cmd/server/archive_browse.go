@@ -0,0 +1,516 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Wirezat/GoLog"
+	"github.com/Wirezat/fileshare/pkg/shared"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Kinds of archive that can be browsed with ?archive=.
+const (
+	browseZip    = "zip"
+	browseTar    = "tar"
+	browseTarGz  = "tar.gz"
+	browseTarZst = "tar.zst"
+)
+
+// browseSuffixes maps archive file name suffixes to their kind.
+var browseSuffixes = []struct{ suffix, kind string }{
+	{".zip", browseZip},
+	{".tar", browseTar},
+	{".tar.gz", browseTarGz},
+	{".tgz", browseTarGz},
+	{".tar.zst", browseTarZst},
+	{".tzst", browseTarZst},
+}
+
+var errArchiveTooLarge = errors.New("archive exceeds the browsing limits")
+
+// archiveEntry is a file or directory inside an archive.
+type archiveEntry struct {
+	Name    string
+	Path    string // slash-separated, without leading slash; "" is the root
+	IsDir   bool
+	Size    int64
+	ModTime int64 // unix timestamp; 0 for directories implied by their contents
+}
+
+// archiveIndex holds the entries of one archive version, grouped by directory.
+type archiveIndex struct {
+	dirs    map[string][]archiveEntry // directory path → children, directories first
+	files   map[string]archiveEntry
+	entries int
+}
+
+// archiveIndexes caches recently browsed archives by path, size and mtime.
+var archiveIndexes = sumCache[*archiveIndex]{max: 64}
+
+// archiveListingResponse is the JSON form of a directory inside an archive.
+// GET /{subpath}/{path}?archive=<dir>&format=json
+type archiveListingResponse struct {
+	Archive string                `json:"archive"` // the archive's path relative to the share root
+	Path    string                `json:"path"`    // directory inside the archive; "" is the root
+	Parent  string                `json:"parent,omitempty"`
+	Entries []archiveListingEntry `json:"entries"`
+}
+
+// archiveListingEntry is one entry of an archiveListingResponse. URL opens a
+// directory or downloads a file.
+type archiveListingEntry struct {
+	Name    string `json:"name"`
+	Path    string `json:"path"`
+	Type    string `json:"type"` // "dir" or "file"
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime"`
+	URL     string `json:"url"`
+}
+
+// archivePageData contains the fields rendered by the archive template.
+type archivePageData struct {
+	Subpath     string
+	ArchivePath string // relative to the share root
+	ArchiveName string
+	Dir         string // directory inside the archive; "" is the root
+	ParentURL   string // empty at the root of a single-file share
+	Entries     []archiveEntry
+	Brand       shareBranding
+}
+
+var (
+	archiveTemplateOnce sync.Once
+	archiveTemplate     *template.Template
+	archiveTemplateErr  error
+)
+
+// browseKind returns the archive kind of a file name, or "" if it can't be browsed.
+func browseKind(name string) string {
+	lower := strings.ToLower(name)
+	for _, s := range browseSuffixes {
+		if strings.HasSuffix(lower, s.suffix) {
+			return s.kind
+		}
+	}
+	return ""
+}
+
+// isBrowsable reports whether the listing should link name to its contents.
+func isBrowsable(name string) bool {
+	return browseKind(name) != ""
+}
+
+// isArchiveBrowseRequest reports whether r asks for a directory or file inside an archive.
+func isArchiveBrowseRequest(r *http.Request) bool {
+	return r.URL.Query().Has("archive")
+}
+
+// cleanEntryPath normalizes an entry name from an archive or a request.
+// Leading slashes and ".." elements that would escape the root are dropped.
+func cleanEntryPath(name string) string {
+	return path.Clean("/" + name)[1:]
+}
+
+// serveArchiveBrowse handles ?archive=<path> for archive files: a directory
+// path (or an empty one, for the root) lists that directory, a file path
+// downloads that entry. Archives with more than Config.ArchiveBrowseMaxEntries
+// entries, tar streams longer than Config.ArchiveBrowseMaxBytes and entries
+// larger than that are refused, so that zip bombs can't exhaust the server.
+func serveArchiveBrowse(w http.ResponseWriter, r *http.Request, ctx *requestContext) {
+	kind := browseKind(ctx.fileInfo.Name())
+	if kind == "" {
+		http.Error(w, "This file can't be browsed", http.StatusUnsupportedMediaType)
+		return
+	}
+	if !acquireArchiveSlot(r, ctx) {
+		return
+	}
+	defer archiveSlots.Release(1)
+
+	key := fileKeyOf(ctx.diskPath, ctx.fileInfo)
+	idx, ok := archiveIndexes.get(key)
+	if !ok {
+		var err error
+		idx, err = buildArchiveIndex(r.Context(), ctx.diskPath, kind, ctx.config)
+		if err != nil {
+			browseError(w, r, ctx, err)
+			return
+		}
+		archiveIndexes.put(key, idx)
+	}
+
+	p := cleanEntryPath(r.URL.Query().Get("archive"))
+	if _, ok := idx.dirs[p]; ok {
+		serveArchiveListing(w, r, ctx, idx, p)
+		return
+	}
+	entry, ok := idx.files[p]
+	if !ok {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	// An entry counts as a download of its own, named after the archive
+	// and the path within it.
+	if shared.CountsVisitors(ctx.fileData) && r.Method != http.MethodHead &&
+		!visitorOrErr(w, r, ctx.config, ctx.subpath, shareRelPath(ctx)+"/"+p, true) {
+		return
+	}
+	if err := serveArchiveEntry(w, r, ctx, kind, entry); err != nil {
+		browseError(w, r, ctx, err)
+	}
+}
+
+// browseError reports a failure to read an archive. Nothing is written if
+// the client has gone away.
+func browseError(w http.ResponseWriter, r *http.Request, ctx *requestContext, err error) {
+	switch {
+	case r.Context().Err() != nil:
+	case errors.Is(err, errArchiveTooLarge):
+		GoLog.Warnf("browse %s: %v", ctx.diskPath, err)
+		http.Error(w, "Archive is too large to browse; download it instead", http.StatusUnprocessableEntity)
+	default:
+		GoLog.Warnf("browse %s: %v", ctx.diskPath, err)
+		http.Error(w, "Archive can't be read", http.StatusUnprocessableEntity)
+	}
+}
+
+// buildArchiveIndex reads the entries of an archive: the central directory
+// of a ZIP, or every header of a tar stream.
+func buildArchiveIndex(ctx context.Context, diskPath, kind string, cfg *shared.Config) (*archiveIndex, error) {
+	idx := &archiveIndex{
+		dirs:  map[string][]archiveEntry{"": nil},
+		files: map[string]archiveEntry{},
+	}
+	maxEntries := cfg.ArchiveBrowseMaxEntries
+
+	f, err := os.Open(diskPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if kind == browseZip {
+		info, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		zr, err := zip.NewReader(f, info.Size())
+		if err != nil {
+			return nil, err
+		}
+		if len(zr.File) > maxEntries {
+			return nil, fmt.Errorf("%w: %d entries", errArchiveTooLarge, len(zr.File))
+		}
+		for _, zf := range zr.File {
+			fi := zf.FileInfo()
+			if !fi.IsDir() && !fi.Mode().IsRegular() {
+				continue
+			}
+			idx.add(zf.Name, fi.IsDir(), int64(zf.UncompressedSize64), zf.Modified)
+		}
+		idx.sort()
+		return idx, nil
+	}
+
+	stream, closeStream, err := openTarStream(f, kind, int64(cfg.ArchiveBrowseMaxBytes))
+	if err != nil {
+		return nil, err
+	}
+	defer closeStream()
+	tr := tar.NewReader(stream)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeDir && hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if idx.entries >= maxEntries {
+			return nil, fmt.Errorf("%w: more than %d entries", errArchiveTooLarge, maxEntries)
+		}
+		idx.add(hdr.Name, hdr.Typeflag == tar.TypeDir, hdr.Size, hdr.ModTime)
+	}
+	idx.sort()
+	return idx, nil
+}
+
+// openTarStream returns the tar stream inside an archive file. Compressed
+// streams fail with errArchiveTooLarge after maxBytes of decompressed data;
+// plain tars are read by seeking past file contents and need no bound.
+func openTarStream(f *os.File, kind string, maxBytes int64) (io.Reader, func(), error) {
+	switch kind {
+	case browseTarGz:
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &boundedReader{r: zr, n: maxBytes}, func() { zr.Close() }, nil
+	case browseTarZst:
+		zr, err := zstd.NewReader(f, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, nil, err
+		}
+		return &boundedReader{r: zr, n: maxBytes}, zr.Close, nil
+	}
+	return f, func() {}, nil
+}
+
+// boundedReader fails with errArchiveTooLarge once n bytes have been read.
+type boundedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (b *boundedReader) Read(p []byte) (int, error) {
+	if b.n <= 0 {
+		return 0, errArchiveTooLarge
+	}
+	if int64(len(p)) > b.n {
+		p = p[:b.n]
+	}
+	n, err := b.r.Read(p)
+	b.n -= int64(n)
+	return n, err
+}
+
+// add records an entry and the directories leading to it. Later entries
+// with a name that is already taken are ignored.
+func (idx *archiveIndex) add(name string, isDir bool, size int64, modTime time.Time) {
+	p := cleanEntryPath(name)
+	if p == "" {
+		return
+	}
+	var mtime int64
+	if !modTime.IsZero() {
+		mtime = modTime.Unix()
+	}
+	if isDir {
+		if _, ok := idx.dirs[p]; !ok {
+			idx.addDir(p, mtime)
+		}
+		return
+	}
+	if _, ok := idx.files[p]; ok {
+		return
+	}
+	if _, ok := idx.dirs[p]; ok {
+		return
+	}
+	parent := parentEntryPath(p)
+	idx.addDir(parent, 0)
+	e := archiveEntry{Name: path.Base(p), Path: p, Size: size, ModTime: mtime}
+	idx.files[p] = e
+	idx.dirs[parent] = append(idx.dirs[parent], e)
+	idx.entries++
+}
+
+// addDir records a directory and any missing parents of it.
+func (idx *archiveIndex) addDir(p string, modTime int64) {
+	for p != "" {
+		if _, ok := idx.dirs[p]; ok {
+			return
+		}
+		idx.dirs[p] = nil
+		parent := parentEntryPath(p)
+		idx.dirs[parent] = append(idx.dirs[parent], archiveEntry{Name: path.Base(p), Path: p, IsDir: true, ModTime: modTime})
+		idx.entries++
+		p, modTime = parent, 0
+	}
+}
+
+// sort orders every directory's children: directories first, then by name.
+func (idx *archiveIndex) sort() {
+	for _, children := range idx.dirs {
+		slices.SortFunc(children, func(a, b archiveEntry) int {
+			if a.IsDir != b.IsDir {
+				if a.IsDir {
+					return -1
+				}
+				return 1
+			}
+			return strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+		})
+	}
+}
+
+func parentEntryPath(p string) string {
+	if dir := path.Dir(p); dir != "." {
+		return dir
+	}
+	return ""
+}
+
+// serveArchiveListing renders one directory of an archive as HTML or JSON.
+func serveArchiveListing(w http.ResponseWriter, r *http.Request, ctx *requestContext, idx *archiveIndex, dir string) {
+	fd := ctx.fileData
+	archivePath := filepath.Join("/", strings.TrimPrefix(ctx.diskPath, fd.Path))
+	entries := idx.dirs[dir]
+
+	if wantsJSON(r) {
+		base := shareURL(ctx.subpath, archivePath)
+		resp := archiveListingResponse{
+			Archive: archivePath,
+			Path:    dir,
+			Entries: make([]archiveListingEntry, 0, len(entries)),
+		}
+		if dir != "" {
+			resp.Parent = parentEntryPath(dir)
+		}
+		for _, e := range entries {
+			le := archiveListingEntry{
+				Name:    e.Name,
+				Path:    e.Path,
+				Type:    "file",
+				Size:    e.Size,
+				ModTime: e.ModTime,
+				URL:     base + "?archive=" + url.QueryEscape(e.Path),
+			}
+			if e.IsDir {
+				le.Type = "dir"
+			}
+			resp.Entries = append(resp.Entries, le)
+		}
+		jsonResponse(w, resp)
+		return
+	}
+
+	pd := archivePageData{
+		Subpath:     ctx.subpath,
+		ArchivePath: archivePath,
+		ArchiveName: ctx.fileInfo.Name(),
+		Dir:         dir,
+		Entries:     entries,
+		Brand:       brandingFor(ctx.subpath, ctx.fileData, false),
+	}
+	switch {
+	case dir != "":
+		pd.ParentURL = "?archive=" + url.QueryEscape(parentEntryPath(dir))
+	case ctx.diskPath != fd.Path:
+		parentDir := strings.TrimPrefix(filepath.Dir(ctx.diskPath), fd.Path)
+		pd.ParentURL = path.Join("/", ctx.subpath, parentDir) + "/"
+	}
+
+	tmpl, err := loadArchiveTemplate(pageLanguage(w, r))
+	if err != nil {
+		GoLog.Errorf("failed to load archive template: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := tmpl.ExecuteTemplate(w, "archive", pd); err != nil {
+		GoLog.Errorf("failed to render archive template: %v", err)
+	}
+}
+
+// serveArchiveEntry streams one file out of an archive as an attachment.
+// Entries larger than Config.ArchiveBrowseMaxBytes are refused; the ZIP
+// reader additionally fails if an entry inflates past its recorded size.
+func serveArchiveEntry(w http.ResponseWriter, r *http.Request, ctx *requestContext, kind string, entry archiveEntry) error {
+	maxBytes := int64(ctx.config.ArchiveBrowseMaxBytes)
+	if entry.Size > maxBytes {
+		return fmt.Errorf("%w: %s is %d bytes", errArchiveTooLarge, entry.Path, entry.Size)
+	}
+
+	f, err := os.Open(ctx.diskPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var src io.Reader
+	if kind == browseZip {
+		zr, err := zip.NewReader(f, ctx.fileInfo.Size())
+		if err != nil {
+			return err
+		}
+		i := slices.IndexFunc(zr.File, func(zf *zip.File) bool { return cleanEntryPath(zf.Name) == entry.Path })
+		if i < 0 {
+			return fmt.Errorf("entry %s vanished", entry.Path)
+		}
+		rc, err := zr.File[i].Open()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		src = rc
+	} else {
+		stream, closeStream, err := openTarStream(f, kind, maxBytes)
+		if err != nil {
+			return err
+		}
+		defer closeStream()
+		tr := tar.NewReader(stream)
+		for {
+			if err := r.Context().Err(); err != nil {
+				return err
+			}
+			hdr, err := tr.Next()
+			if err != nil {
+				if err == io.EOF {
+					err = fmt.Errorf("entry %s vanished", entry.Path)
+				}
+				return err
+			}
+			if hdr.Typeflag == tar.TypeReg && cleanEntryPath(hdr.Name) == entry.Path {
+				src = tr
+				break
+			}
+		}
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(entry.Name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": entry.Name}))
+	w.Header().Set("Content-Length", strconv.FormatInt(entry.Size, 10))
+	if entry.ModTime > 0 {
+		w.Header().Set("Last-Modified", time.Unix(entry.ModTime, 0).UTC().Format(http.TimeFormat))
+	}
+
+	if _, err := io.CopyN(w, src, entry.Size); err != nil {
+		// Headers are out; all that's left is to cut the response short.
+		GoLog.Warnf("browse %s: extracting %s: %v", ctx.diskPath, entry.Path, err)
+		return nil
+	}
+	GoLog.Infof("browse /%s: extracted %s from %s", ctx.subpath, entry.Path, ctx.diskPath)
+	return nil
+}
+
+func loadArchiveTemplate(lang string) (*template.Template, error) {
+	archiveTemplateOnce.Do(func() {
+		archiveTemplate, archiveTemplateErr = parseTemplate("archive", archiveHtmlPath,
+			template.FuncMap{"formatSize": formatSize})
+	})
+	if archiveTemplateErr != nil {
+		return nil, archiveTemplateErr
+	}
+	return localize(archiveTemplate, lang)
+}
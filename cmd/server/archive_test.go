@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// writeTree creates files, given by slash-separated path and content, below
+// a new temporary directory and returns it.
+func writeTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestWalkRegularFilesSkipsHidden(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"a.txt":           "a",
+		".env":            "secret",
+		"sub/b.txt":       "b",
+		"sub/.b.txt.part": "partial",
+		".git/config":     "x",
+		"z.txt":           "z",
+	})
+	tests := []struct {
+		name  string
+		paths []string
+		want  []string
+	}{
+		{"share", []string{root}, []string{"a.txt", "sub/b.txt", "z.txt"}},
+		{"folder", []string{filepath.Join(root, "sub")}, []string{"sub/b.txt"}},
+		{"files", []string{filepath.Join(root, "a.txt"), filepath.Join(root, "z.txt")}, []string{"a.txt", "z.txt"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		err := walkRegularFiles(context.Background(), root, tt.paths, func(_, name string, _ os.FileInfo) error {
+			got = append(got, name)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: walked %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWalkRegularFilesStopsWhenCancelled(t *testing.T) {
+	root := writeTree(t, map[string]string{"a.txt": "a", "b.txt": "b"})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls := 0
+	err := walkRegularFiles(ctx, root, []string{root}, func(string, string, os.FileInfo) error {
+		calls++
+		return nil
+	})
+	if err == nil || calls != 0 {
+		t.Errorf("walk with a cancelled context = %v after %d files, want an error and none", err, calls)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Wirezat/GoLog"
+	"github.com/Wirezat/fileshare/pkg/shared"
+)
+
+// shareBranding is the per-share look rendered by the share page templates.
+// Zero fields fall back to the built-in look.
+type shareBranding struct {
+	Title     string        // shown instead of the subpath
+	Message   template.HTML // rendered Markdown; set for listings only
+	AccentCSS template.CSS  // custom properties for the accent color
+	LogoURL   string
+}
+
+// brandingFor builds the branding of a share. The message is only rendered
+// where withMessage is set, so the gate shows the look but not the content.
+func brandingFor(subpath string, fd shared.FileData, withMessage bool) shareBranding {
+	b := shareBranding{Title: fd.Title}
+	if withMessage && fd.Message != "" {
+		// Raw HTML and unsafe link targets are dropped by goldmark's default renderer.
+		var buf bytes.Buffer
+		if err := markdown.Convert([]byte(fd.Message), &buf); err == nil {
+			b.Message = template.HTML(buf.String())
+		}
+	}
+	if shared.ValidateAccentColor(fd.AccentColor) == nil {
+		b.AccentCSS = accentCSS(fd.AccentColor)
+	}
+	switch {
+	case fd.Logo == "":
+	case strings.HasPrefix(fd.Logo, "https://"), strings.HasPrefix(fd.Logo, "http://"):
+		b.LogoURL = fd.Logo
+	default:
+		if info, err := os.Stat(fd.Logo); err == nil {
+			b.LogoURL = logoURL(subpath) + "?v=" + strconv.FormatInt(info.ModTime().Unix(), 36)
+		}
+	}
+	return b
+}
+
+func logoURL(subpath string) string {
+	return "/static/logo/" + url.PathEscape(subpath)
+}
+
+// accentCSS overrides the accent custom properties of share.css with color,
+// a validated #rgb or #rrggbb. The text on accent backgrounds is dark or light
+// depending on the color's luminance; the darker and muted variants are mixed.
+func accentCSS(color string) template.CSS {
+	if len(color) == 4 {
+		color = string([]byte{'#', color[1], color[1], color[2], color[2], color[3], color[3]})
+	}
+	rgb, _ := strconv.ParseUint(color[1:], 16, 32)
+	r, g, b := float64(rgb>>16&0xff), float64(rgb>>8&0xff), float64(rgb&0xff)
+	text := "#ffffff"
+	if (0.299*r+0.587*g+0.114*b)/255 > 0.6 {
+		text = "#1a1a1a"
+	}
+	return template.CSS(fmt.Sprintf(`:root, html.dark {
+    --accent: %[1]s;
+    --accent-dark: color-mix(in srgb, %[1]s 82%%, #000);
+    --accent-text: %[2]s;
+}
+:root { --accent-muted: color-mix(in srgb, %[1]s 15%%, #fff); }
+html.dark { --accent-muted: color-mix(in srgb, %[1]s 22%%, #000); }`, color, text))
+}
+
+// handleShareLogo serves GET /static/logo/{subpath}, the logo file of a share.
+// It is public, as the password gate shows it too. SVG logos may contain
+// scripts, so the response is locked down with a restrictive CSP.
+func handleShareLogo(w http.ResponseWriter, r *http.Request) {
+	config, err := shared.LoadConfig()
+	if err != nil {
+		GoLog.Errorf("failed to load config: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	fd, exists := config.Files[r.PathValue("subpath")]
+	if !exists || fd.Logo == "" || shared.ValidateLogo(fd.Logo) != nil || strings.Contains(fd.Logo, "://") {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; sandbox")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	http.ServeFile(w, r, fd.Logo)
+}
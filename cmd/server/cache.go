@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Wirezat/fileshare/pkg/shared"
+)
+
+// shareCacheControl returns the Cache-Control header for a share's responses.
+// Shares with a password or any kind of limit are never stored by shared
+// caches, whatever the configured policy says: a proxy would hand them out
+// without the password, without counting uses or after hours.
+func shareCacheControl(fd shared.FileData) string {
+	cc := fd.CacheControl
+	if cc == "" {
+		cc = shared.DefaultCacheControl
+	}
+	if !isRestrictedShare(fd) {
+		return cc
+	}
+	directives := []string{"private"}
+	for d := range strings.SplitSeq(cc, ",") {
+		d = strings.TrimSpace(d)
+		switch name, _, _ := strings.Cut(strings.ToLower(d), "="); name {
+		case "public", "s-maxage", "proxy-revalidate":
+			continue
+		}
+		directives = append(directives, d)
+	}
+	return strings.Join(dedupe(directives), ", ")
+}
+
+// isRestrictedShare reports whether a share checks something on every
+// request that a shared cache wouldn't: a password, a use limit, an
+// expiration, opening hours or per-visitor limits.
+func isRestrictedShare(fd shared.FileData) bool {
+	return fd.Password != "" || fd.Uses != shared.UnlimitedUses || fd.Expiration != 0 ||
+		fd.Availability != "" || shared.CountsVisitors(fd)
+}
+
+// dedupe removes repeated directives, keeping the first.
+func dedupe(directives []string) []string {
+	seen := make(map[string]bool, len(directives))
+	out := directives[:0]
+	for _, d := range directives {
+		if key := strings.ToLower(d); !seen[key] {
+			seen[key] = true
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// checkNotModified sets the ETag and, unless modTime is zero, Last-Modified
+// validators on w. If the request's conditional headers show that the client's
+// copy is still current, it answers 304 Not Modified and returns true.
+// If-None-Match takes precedence over If-Modified-Since, as in RFC 9110.
+func checkNotModified(w http.ResponseWriter, r *http.Request, etag string, modTime time.Time) bool {
+	h := w.Header()
+	h.Set("ETag", etag)
+	if !modTime.IsZero() {
+		h.Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if !etagListMatches(inm, etag) {
+			return false
+		}
+	} else {
+		since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+		if err != nil || modTime.IsZero() || modTime.Truncate(time.Second).After(since) {
+			return false
+		}
+	}
+	h.Del("Content-Type")
+	h.Del("Content-Length")
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// etagListMatches reports whether an If-None-Match value lists etag, using
+// the weak comparison If-None-Match calls for.
+func etagListMatches(list, etag string) bool {
+	want := strings.TrimPrefix(etag, "W/")
+	for candidate := range strings.SplitSeq(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == want {
+			return true
+		}
+	}
+	return false
+}
+
+// weakETag formats the first bytes of a digest as a weak ETag. Generated
+// responses are only promised to be equivalent, not byte-identical.
+func weakETag(prefix string, h hash.Hash) string {
+	return `W/"` + prefix + hex.EncodeToString(h.Sum(nil)[:12]) + `"`
+}
+
+// listingValidators derives the ETag and Last-Modified of a directory listing
+// from its visible entries' names, sizes and modification times and the
+// folder's total size, without rendering it. A subdirectory's modification
+// time changes with its entries, which covers the child counts shown. The
+// query string (sort order, page,
+// filter), the response format and language, the share settings, branding
+// and visitor permissions shown on the page and the asset URLs are hashed as
+// well; templates and catalogs are only read once, so the server's start
+// time stands in for them.
+func listingValidators(r *http.Request, ctx *requestContext) (string, time.Time, error) {
+	entries, err := os.ReadDir(ctx.diskPath)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	fd := ctx.fileData
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%t\x00%d\x00%d\x00%t\x00%q\x00%s\x00%s\x00%d\n",
+		r.URL.RawQuery, wantsJSON(r), fd.Uses, fd.Expiration, fd.AllowPost,
+		shared.OfferedArchiveFormats(fd), assetURL("/static/share.css"), assetURL("/static/share.js"),
+		startTime.UnixNano())
+	fmt.Fprintf(h, "%q\x00%+v\x00%s\n", fd.Message, brandingFor(ctx.subpath, fd, false), requestLanguage(r))
+	fmt.Fprintf(h, "%q\x00%+v\n", shared.EffectivePermissions(fd), listingPermsFor(r, ctx.subpath, fd).Owned)
+	// Changes further down only show in the folder's size.
+	size, capped := folderSize(r.Context(), ctx.diskPath, time.Duration(ctx.config.SearchTimeout)*time.Second)
+	fmt.Fprintf(h, "%d\x00%t\n", size, capped)
+
+	modTime := ctx.fileInfo.ModTime()
+	for _, e := range entries {
+		name := e.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			if target, err := os.Stat(filepath.Join(ctx.diskPath, name)); err == nil {
+				info = target
+			}
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00%d\x00%t\n", name, info.Size(), info.ModTime().UnixNano(), info.IsDir())
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+	if startTime.After(modTime) {
+		modTime = startTime
+	}
+	return weakETag("l-", h), modTime, nil
+}
+
+// archiveETag derives the ETag of a GET archive download from the files it
+// would contain and the options that shape it. Archives carry no
+// Last-Modified: the newest modification time would miss deleted files.
+func archiveETag(ctx context.Context, r *http.Request, baseDir string, roots []string) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n", r.URL.RawQuery)
+	err := walkRegularFiles(ctx, baseDir, roots, func(_, name string, info os.FileInfo) error {
+		fmt.Fprintf(h, "%s\x00%d\x00%d\x00%o\n", name, info.Size(), info.ModTime().UnixNano(), info.Mode().Perm())
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return weakETag("a-", h), nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"os"
+	"sync"
+)
+
+// maxSumCacheEntries is the default size of a sumCache.
+const maxSumCacheEntries = 1 << 20
+
+var errFileChanged = errors.New("file changed")
+
+// fileKey identifies one version of a file. A checksum cached under it
+// stays valid as long as the file keeps its size and mtime.
+type fileKey struct {
+	path    string
+	size    int64
+	modTime int64 // unix nanoseconds
+}
+
+func fileKeyOf(path string, info os.FileInfo) fileKey {
+	return fileKey{path, info.Size(), info.ModTime().UnixNano()}
+}
+
+// sumCache is an in-memory cache of values derived from a file's contents,
+// keyed by fileKey. It holds up to max entries (maxSumCacheEntries if zero)
+// and is cleared when full.
+type sumCache[V any] struct {
+	max int
+
+	mu sync.Mutex
+	m  map[fileKey]V
+}
+
+func (c *sumCache[V]) get(k fileKey) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.m[k]
+	return v, ok
+}
+
+func (c *sumCache[V]) put(k fileKey, v V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	limit := c.max
+	if limit == 0 {
+		limit = maxSumCacheEntries
+	}
+	if c.m == nil || len(c.m) >= limit {
+		c.m = make(map[fileKey]V)
+	}
+	c.m[k] = v
+}
+
+var (
+	crcCache    sumCache[uint32]
+	sha256Cache sumCache[string]
+)
+
+// hashFile feeds the file identified by k into h. It returns errFileChanged
+// if the file no longer has the size and mtime recorded in k.
+func hashFile(k fileKey, h hash.Hash) error {
+	f, err := os.Open(k.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if fileKeyOf(k.path, info) != k {
+		return errFileChanged
+	}
+	buf := bufPool.Get().(*[]byte)
+	_, err = io.CopyBuffer(h, f, *buf)
+	bufPool.Put(buf)
+	return err
+}
+
+// cachedSHA256 returns the hex SHA-256 of a file, reading it only on a cache miss.
+func cachedSHA256(k fileKey) (string, error) {
+	if sum, ok := sha256Cache.get(k); ok {
+		return sum, nil
+	}
+	h := sha256.New()
+	if err := hashFile(k, h); err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	sha256Cache.put(k, sum)
+	return sum, nil
+}
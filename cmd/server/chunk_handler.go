@@ -11,22 +11,38 @@ import (
 
 // handleChunkInit registers or resumes a chunked upload session.
 // POST /{subpath}/chunk-init
-// Form: uploadId (client-generated hash), filename, totalChunks
+// Form: uploadId (client-generated hex hash), filename, totalChunks,
+// optional fileSize and chunkSize (required for sparse assembly),
+// optional dir (folder relative to the share; the share root by default)
 // Response: 200 + {"uploadId":"...", "missingChunks":[0,1,...]}
 func handleChunkInit(w http.ResponseWriter, r *http.Request) {
-	fd, ok := resolveUploadTarget(w, r)
+	subpath, fd, ok := resolveVisitorShare(w, r, shared.PermUpload)
 	if !ok {
 		return
 	}
 
+	config, err := shared.LoadConfig()
+	if err != nil {
+		GoLog.Errorf("handleChunkInit: load config: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
 	if err := r.ParseForm(); err != nil {
 		http.Error(w, "Bad Request", http.StatusBadRequest)
 		return
 	}
+	destDir, ok := resolveShareDir(w, fd, r.FormValue("dir"))
+	if !ok {
+		return
+	}
+	// The finished file is credited to this visitor for OwnFilesOnly.
+	if _, err := ensureVisitorID(w, r, subpath); err != nil {
+		GoLog.Warnf("handleChunkInit: visitor id: %v", err)
+	}
 
 	uploadID := r.FormValue("uploadId")
-	if uploadID == "" {
-		http.Error(w, "Bad Request: missing uploadId", http.StatusBadRequest)
+	if !validUploadID(uploadID) {
+		http.Error(w, "Bad Request: invalid uploadId", http.StatusBadRequest)
 		return
 	}
 	filename := r.FormValue("filename")
@@ -35,12 +51,35 @@ func handleChunkInit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	totalChunks, err := strconv.Atoi(r.FormValue("totalChunks"))
-	if err != nil || totalChunks < 1 {
+	if err != nil || totalChunks < 1 || totalChunks > maxUploadChunks {
 		http.Error(w, "Bad Request: invalid totalChunks", http.StatusBadRequest)
 		return
 	}
 
-	missing, err := storage.InitChunk(uploadID, filename, totalChunks, fd.Path)
+	// fileSize and chunkSize are optional so older clients keep working;
+	// without them the session falls back to concat assembly. A chunk can't
+	// be larger than a POST may be, which with totalChunks bounds fileSize.
+	var fileSize, chunkSize int64
+	if v := r.FormValue("fileSize"); v != "" {
+		fileSize, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || fileSize < 0 {
+			http.Error(w, "Bad Request: invalid fileSize", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := r.FormValue("chunkSize"); v != "" {
+		chunkSize, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || chunkSize < 1 || chunkSize > int64(config.MaxPostSize) {
+			http.Error(w, "Bad Request: invalid chunkSize", http.StatusBadRequest)
+			return
+		}
+	}
+	if fileSize > 0 && chunkSize > 0 && int64(totalChunks) != chunkCount(fileSize, chunkSize) {
+		http.Error(w, "Bad Request: totalChunks does not match fileSize", http.StatusBadRequest)
+		return
+	}
+
+	missing, err := storage.InitChunk(uploadID, filename, totalChunks, fileSize, chunkSize, destDir)
 	if err != nil {
 		GoLog.Errorf("handleChunkInit: %v", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -70,6 +109,10 @@ func handleChunkReceive(w http.ResponseWriter, r *http.Request) {
 	}
 
 	uploadID := r.FormValue("uploadId")
+	if !validUploadID(uploadID) {
+		http.Error(w, "Bad Request: invalid uploadId", http.StatusBadRequest)
+		return
+	}
 	chunkIndex, err := strconv.Atoi(r.FormValue("chunkIndex"))
 	if err != nil || chunkIndex < 0 {
 		http.Error(w, "Bad Request: invalid chunkIndex", http.StatusBadRequest)
@@ -83,14 +126,15 @@ func handleChunkReceive(w http.ResponseWriter, r *http.Request) {
 	}
 	defer f.Close()
 
-	done, err := storage.ReceiveChunk(uploadID, chunkIndex, f)
+	dest, err := storage.ReceiveChunk(uploadID, chunkIndex, f)
 	if err != nil {
 		GoLog.Errorf("handleChunkReceive: %v", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
-	if done {
+	if dest != "" {
+		recordOwned(dest, visitorID(r, r.PathValue("subpath")))
 		w.WriteHeader(http.StatusNoContent) // 204 — upload complete
 	} else {
 		w.WriteHeader(http.StatusAccepted) // 202 — more chunks expected
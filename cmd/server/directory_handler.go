@@ -1,13 +1,19 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"html/template"
-	"io/fs"
+	"io"
+	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/Wirezat/GoLog"
 	"github.com/Wirezat/fileshare/pkg/shared"
@@ -19,16 +25,17 @@ var (
 	dirTemplateOnce sync.Once
 )
 
-// serveDirectory renders the directory listing, or streams a ZIP if ?download=zip.
+// serveDirectory renders the directory listing, or streams an archive if
+// ?download=<format> is set. Clients asking for JSON (see wantsJSON) get a
+// listingResponse instead of HTML. Listings carry an ETag and Last-Modified
+// (see listingValidators), so unchanged directories are answered with 304.
 func serveDirectory(w http.ResponseWriter, r *http.Request, ctx *requestContext) {
-	if r.URL.Query().Get("download") == "zip" {
-		zipAndServe(w, ctx.diskPath)
+	if r.URL.Query().Get("download") != "" {
+		serveArchive(w, r, ctx)
 		return
 	}
-
-	tmpl, err := loadTemplate()
-	if err != nil {
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	if r.URL.Query().Get("playlist") != "" {
+		servePlaylist(w, r, ctx)
 		return
 	}
 
@@ -40,66 +47,195 @@ func serveDirectory(w http.ResponseWriter, r *http.Request, ctx *requestContext)
 		parentDir = filepath.Join("/", strings.TrimPrefix(filepath.Dir(ctx.diskPath), fd.Path))
 	}
 
-	files, _ := getFileInfos(ctx.diskPath, fd.Path)
+	opts := parseListingOptions(r, ctx.config.ListingPageSize)
+	search := strings.TrimSpace(r.URL.Query().Get("search"))
+
+	// Search results span subdirectories, so only plain listings get validators.
+	w.Header().Add("Vary", "Accept")
+	lang := pageLanguage(w, r)
+	if search == "" {
+		etag, modTime, err := listingValidators(r, ctx)
+		if err == nil && checkNotModified(w, r, etag, modTime) {
+			return
+		}
+	}
+
+	perms := listingPermsFor(r, ctx.subpath, fd)
+	var page listingPage
+	var truncated bool
+	if search != "" {
+		res := runSearch(r, ctx, search)
+		page = listingPage{Files: res.Files, Total: len(res.Files)}
+		truncated = res.Truncated
+		for _, f := range res.Files {
+			page.TotalSize += f.Size
+		}
+	} else if perms.Read {
+		var err error
+		page, err = listDirectory(ctx.diskPath, fd.Path, opts)
+		if err != nil {
+			GoLog.Errorf("failed to list %s: %v", ctx.diskPath, err)
+		}
+		page.TotalSize, page.SizeCapped = folderSize(r.Context(), ctx.diskPath, time.Duration(ctx.config.SearchTimeout)*time.Second)
+	}
+
+	if wantsJSON(r) {
+		resp := buildListing(ctx.subpath, fd, relPath, parentDir, ctx.diskPath != fd.Path, page)
+		resp.Search = search
+		resp.Truncated = truncated
+		jsonResponse(w, resp)
+		return
+	}
+
+	tmpl, err := loadTemplate(lang)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	toggledOrder := "desc"
+	if opts.Desc {
+		toggledOrder = ""
+	}
 
 	if err := tmpl.Execute(w, PageData{
 		Subpath:      ctx.subpath,
 		UploadTime:   fd.UploadTime,
 		DirPath:      relPath,
-		Files:        files,
+		Files:        page.Files,
+		TotalSize:    page.TotalSize,
+		SizeCapped:   page.SizeCapped,
+		Total:        page.Total,
+		Sort:         opts.Sort,
+		Desc:         opts.Desc,
+		Filter:       opts.Filter,
+		NextURL:      nextURL(r, page),
+		FirstURL:     firstURL(r, opts),
+		ToggleURL:    listingURL(r, map[string]string{"order": toggledOrder, "cursor": ""}),
+		Search:       search,
+		Truncated:    truncated,
 		ParentDir:    parentDir,
 		HasParentDir: ctx.diskPath != fd.Path,
 		Uses:         fd.Uses,
 		Expiration:   fd.Expiration,
-		AllowPost:    fd.AllowPost,
+		AllowPost:    perms.Upload,
+
+		ArchiveFormats: shared.OfferedArchiveFormats(fd),
+		HasMedia: slices.ContainsFunc(page.Files, func(f shared.FileInfo) bool {
+			return !f.IsDir && isMediaFile(f.Name)
+		}),
+		Brand: brandingFor(ctx.subpath, fd, ctx.diskPath == fd.Path),
+		Perms: perms,
 	}); err != nil {
 		GoLog.Errorf("failed to render directory template: %v", err)
 	}
 }
 
-// getFileInfos returns FileInfo entries for a directory, skipping hidden files.
-func getFileInfos(dirPath, basePath string) ([]shared.FileInfo, error) {
-	entries, err := os.ReadDir(dirPath)
-	if err != nil {
-		return nil, err
+// runSearch performs a recursive ?search= below the requested directory,
+// bounded by the configured result limit and timeout.
+func runSearch(r *http.Request, ctx *requestContext, query string) searchResult {
+	cfg := ctx.config
+	limit := cfg.SearchMaxResults
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 && n < limit {
+		limit = n
+	}
+	searchCtx, cancel := context.WithTimeout(r.Context(), time.Duration(cfg.SearchTimeout)*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	res := searchShare(searchCtx, ctx.diskPath, ctx.fileData.Path, query, limit, cfg.SearchIndex)
+	GoLog.Infof("search /%s %q: %d result(s) in %s (truncated: %t)",
+		ctx.subpath, query, len(res.Files), time.Since(start).Round(time.Millisecond), res.Truncated)
+	return res
+}
+
+// nextURL returns the link to the page after the current one, if any.
+func nextURL(r *http.Request, page listingPage) string {
+	if page.NextCursor == "" {
+		return ""
 	}
+	return listingURL(r, map[string]string{"cursor": page.NextCursor})
+}
+
+// firstURL returns the link back to the first page when paging, or "".
+func firstURL(r *http.Request, opts listingOptions) string {
+	if opts.Cursor == "" {
+		return ""
+	}
+	return listingURL(r, map[string]string{"cursor": ""})
+}
 
-	infos := make([]shared.FileInfo, 0, len(entries))
-	for _, entry := range entries {
-		if strings.HasPrefix(entry.Name(), ".") {
-			continue
+// countChildren returns the number of visible entries in a directory.
+// Readdirnames avoids a stat per entry, which matters for large folders.
+func countChildren(dirPath string) int {
+	f, err := os.Open(dirPath)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	names, _ := f.Readdirnames(-1)
+	n := 0
+	for _, name := range names {
+		if !strings.HasPrefix(name, ".") {
+			n++
 		}
-		fullPath := filepath.Join(dirPath, entry.Name())
+	}
+	return n
+}
 
-		isDir := entry.IsDir()
-		if entry.Type()&fs.ModeSymlink != 0 {
-			if info, err := os.Stat(fullPath); err == nil {
-				isDir = info.IsDir()
+// detectMimeType returns the MIME type of a file, without parameters.
+// The extension is tried first; unknown extensions fall back to sniffing
+// the first 512 bytes.
+func detectMimeType(path string) string {
+	t := mime.TypeByExtension(filepath.Ext(path))
+	if t == "" {
+		t = "application/octet-stream"
+		if f, err := os.Open(path); err == nil {
+			buf := make([]byte, 512)
+			n, _ := io.ReadFull(f, buf)
+			f.Close()
+			if n > 0 {
+				t = http.DetectContentType(buf[:n])
 			}
 		}
+	}
+	t, _, _ = strings.Cut(t, ";")
+	return strings.TrimSpace(t)
+}
 
-		infos = append(infos, shared.FileInfo{
-			Name:  entry.Name(),
-			Path:  filepath.Join("/", strings.TrimPrefix(dirPath, basePath), entry.Name()),
-			IsDir: isDir,
-		})
+// formatSize renders a byte count in human-readable binary units.
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
 	}
-	return infos, nil
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
 }
 
-// loadTemplate parses the directory template once and reuses it for all listings.
-func loadTemplate() (*template.Template, error) {
+// loadTemplate parses the directory template once and reuses it for all
+// listings, localized to lang.
+func loadTemplate(lang string) (*template.Template, error) {
 	dirTemplateOnce.Do(func() {
-		dirTemplate, dirTemplateErr = template.New("directory").
-			Funcs(template.FuncMap{
-				"getFileExtension": func(name string) string {
-					return strings.ToLower(filepath.Ext(name))
-				},
-			}).
-			ParseFiles(shareHtmlPath)
+		dirTemplate, dirTemplateErr = parseTemplate("directory", shareHtmlPath, template.FuncMap{
+			"getFileExtension": func(name string) string {
+				return strings.ToLower(filepath.Ext(name))
+			},
+			"formatSize":  formatSize,
+			"upper":       strings.ToUpper,
+			"previewable": isPreviewable,
+			"browsable":   isBrowsable,
+		})
 		if dirTemplateErr != nil {
 			GoLog.Errorf("failed to parse directory template: %v", dirTemplateErr)
 		}
 	})
-	return dirTemplate, dirTemplateErr
+	if dirTemplateErr != nil {
+		return nil, dirTemplateErr
+	}
+	return localize(dirTemplate, lang)
 }
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/Wirezat/fileshare/pkg/shared"
+)
+
+// listingResponse is the JSON representation of a directory listing.
+// GET /{subpath}/{path}?format=json  (or Accept: application/json)
+type listingResponse struct {
+	Share     shareMeta      `json:"share"`
+	Path      string         `json:"path"`
+	Parent    string         `json:"parent,omitempty"`
+	TotalSize int64          `json:"total_size"` // see folderSize
+	Total     int            `json:"total"`
+	Entries   []listingEntry `json:"entries"`
+
+	// SizeCapped is set when counting TotalSize took too long, which makes
+	// it a lower bound.
+	SizeCapped bool `json:"total_size_capped,omitempty"`
+
+	// NextCursor is set when more entries follow; pass it back as ?cursor=.
+	NextCursor string `json:"next_cursor,omitempty"`
+
+	// Set for ?search= results. Entry names are then relative to Path.
+	Search    string `json:"search,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"`
+}
+
+// shareMeta describes the share a listing belongs to.
+type shareMeta struct {
+	Subpath    string `json:"subpath"`
+	UploadTime int64  `json:"upload_time"`
+	Expiration int64  `json:"expiration"` // unix timestamp; 0 = never
+	Uses       int    `json:"uses"`       // remaining uses; -1 = unlimited
+	AllowPost  bool   `json:"allow_post"`
+
+	Permissions  []string `json:"permissions"` // see shared.Permissions
+	OwnFilesOnly bool     `json:"own_files_only,omitempty"`
+}
+
+// listingEntry is a single file or directory in a JSON listing.
+// Path is relative to the share root; URLs are root-relative and escaped.
+type listingEntry struct {
+	Name        string `json:"name"`
+	Path        string `json:"path"`
+	Type        string `json:"type"` // "dir" or "file"
+	MimeType    string `json:"mime_type,omitempty"`
+	Size        int64  `json:"size"`
+	ModTime     int64  `json:"mtime"`
+	ChildCount  int    `json:"child_count,omitempty"`
+	URL         string `json:"url"`
+	DownloadURL string `json:"download_url"`
+}
+
+// wantsJSON reports whether the client asked for a JSON response, either
+// explicitly via ?format=json or through an Accept header that prefers JSON.
+// Browsers always list text/html, so they never match the header check.
+func wantsJSON(r *http.Request) bool {
+	if r.URL.Query().Get("format") == "json" {
+		return true
+	}
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "application/json") && !strings.Contains(accept, "text/html")
+}
+
+// shareURL builds the escaped, root-relative URL of a path inside a share.
+func shareURL(subpath, relPath string) string {
+	return (&url.URL{Path: path.Join("/", subpath, relPath)}).EscapedPath()
+}
+
+// buildListing converts one page of a directory into the JSON listing.
+func buildListing(subpath string, fd shared.FileData, dirPath, parentDir string, hasParent bool, page listingPage) listingResponse {
+	resp := listingResponse{
+		Share: shareMeta{
+			Subpath:    subpath,
+			UploadTime: fd.UploadTime,
+			Expiration: fd.Expiration,
+			Uses:       fd.Uses,
+			AllowPost:  shared.HasPermission(fd, shared.PermUpload),
+
+			Permissions:  shared.EffectivePermissions(fd),
+			OwnFilesOnly: fd.OwnFilesOnly,
+		},
+		Path:       dirPath,
+		TotalSize:  page.TotalSize,
+		SizeCapped: page.SizeCapped,
+		Total:      page.Total,
+		Entries:    make([]listingEntry, 0, len(page.Files)),
+		NextCursor: page.NextCursor,
+	}
+	if hasParent {
+		resp.Parent = parentDir
+	}
+	// Directories link to the share's first offered archive format.
+	var archiveQuery string
+	if formats := shared.OfferedArchiveFormats(fd); len(formats) > 0 {
+		archiveQuery = "?download=" + formats[0]
+	}
+
+	for _, f := range page.Files {
+		u := shareURL(subpath, f.Path)
+		e := listingEntry{
+			Name:        f.Name,
+			Path:        f.Path,
+			Type:        "file",
+			MimeType:    f.MimeType,
+			Size:        f.Size,
+			ModTime:     f.ModTime,
+			URL:         u,
+			DownloadURL: u,
+		}
+		if f.IsDir {
+			e.Type = "dir"
+			e.ChildCount = f.ChildCount
+			e.DownloadURL = u + archiveQuery
+		}
+		resp.Entries = append(resp.Entries, e)
+	}
+	return resp
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"image"
+	"io"
+)
+
+// exifOrientationTag is the TIFF tag holding the EXIF orientation (1–8).
+const exifOrientationTag = 0x0112
+
+// jpegOrientation returns the EXIF orientation of a JPEG stream, or 1 if it
+// has none. Only the segments before the image data are read.
+func jpegOrientation(r io.Reader) int {
+	br := bufio.NewReader(r)
+	var soi [2]byte
+	if _, err := io.ReadFull(br, soi[:]); err != nil || soi != [2]byte{0xFF, 0xD8} {
+		return 1
+	}
+	for {
+		var hdr [4]byte
+		if _, err := io.ReadFull(br, hdr[:]); err != nil || hdr[0] != 0xFF {
+			return 1
+		}
+		marker, size := hdr[1], int(binary.BigEndian.Uint16(hdr[2:]))-2
+		if marker == 0xDA || size < 0 { // start of scan: no more metadata
+			return 1
+		}
+		if marker != 0xE1 {
+			if _, err := br.Discard(size); err != nil {
+				return 1
+			}
+			continue
+		}
+		seg := make([]byte, size)
+		if _, err := io.ReadFull(br, seg); err != nil {
+			return 1
+		}
+		if len(seg) > 6 && string(seg[:6]) == "Exif\x00\x00" {
+			return tiffOrientation(seg[6:])
+		}
+	}
+}
+
+// tiffOrientation reads the orientation tag from IFD0 of a TIFF header.
+func tiffOrientation(b []byte) int {
+	if len(b) < 8 {
+		return 1
+	}
+	var bo binary.ByteOrder
+	switch string(b[:2]) {
+	case "II":
+		bo = binary.LittleEndian
+	case "MM":
+		bo = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(bo.Uint32(b[4:]))
+	if ifd < 8 || ifd+2 > len(b) {
+		return 1
+	}
+	n := int(bo.Uint16(b[ifd:]))
+	for i := range n {
+		e := ifd + 2 + i*12
+		if e+12 > len(b) {
+			break
+		}
+		if bo.Uint16(b[e:]) == exifOrientationTag {
+			if o := int(bo.Uint16(b[e+8:])); o >= 1 && o <= 8 {
+				return o
+			}
+			break
+		}
+	}
+	return 1
+}
+
+// applyOrientation returns src transformed so that it displays upright for
+// the given EXIF orientation. Orientations 5–8 swap width and height.
+func applyOrientation(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := range h {
+		for x := range w {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored
+				dx, dy = w-1-x, y
+			case 3: // rotated 180°
+				dx, dy = w-1-x, h-1-y
+			case 4: // mirrored vertically
+				dx, dy = x, h-1-y
+			case 5: // transposed
+				dx, dy = y, x
+			case 6: // rotated 90° clockwise
+				dx, dy = h-1-y, x
+			case 7: // transversed
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotated 90° counter-clockwise
+				dx, dy = y, w-1-x
+			}
+			si := src.PixOffset(x+src.Rect.Min.X, y+src.Rect.Min.Y)
+			di := dst.PixOffset(dx, dy)
+			copy(dst.Pix[di:di+4], src.Pix[si:si+4])
+		}
+	}
+	return dst
+}
@@ -9,6 +9,7 @@ import (
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/Wirezat/GoLog"
 	"github.com/Wirezat/fileshare/pkg/shared"
@@ -34,6 +35,12 @@ func handleRequest(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet, http.MethodHead:
 		handleGet(w, r, ctx)
+	case http.MethodPost:
+		if !ctx.fileInfo.IsDir() {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		handleGet(w, r, ctx)
 	default:
 		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
 	}
@@ -42,7 +49,7 @@ func handleRequest(w http.ResponseWriter, r *http.Request) {
 // prepareRequest validates the request and resolves all data needed to serve it.
 // Writes an appropriate HTTP error and returns false if anything is invalid.
 func prepareRequest(w http.ResponseWriter, r *http.Request) (*requestContext, bool) {
-	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && !isSelectionRequest(r) {
 		w.Header().Set("Allow", "GET, HEAD")
 		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
 		return nil, false
@@ -66,22 +73,45 @@ func prepareRequest(w http.ResponseWriter, r *http.Request) (*requestContext, bo
 
 	diskPath := filepath.Join(fileData.Path, relativePath)
 
-	if diskPath != fileData.Path && !strings.HasPrefix(diskPath, fileData.Path+"/") {
+	if !withinShare(fileData.Path, diskPath) {
 		GoLog.Warnf("path traversal attempt: %s", diskPath)
 		http.Error(w, "Forbidden", http.StatusForbidden)
 		return nil, false
 	}
 
+	// Hidden entries aren't listed, so they aren't served by direct URL
+	// either.
+	if isHiddenPath(relativePath) {
+		http.NotFound(w, r)
+		return nil, false
+	}
+
 	if fileData.Expired {
 		http.Error(w, "File share expired. Please ask your host to re-share it", http.StatusGone)
 		return nil, false
 	}
 
+	if !liveOrErr(w, r, subpath, fileData) {
+		return nil, false
+	}
+
 	// Password gate — checked after expiry so expired shares still 410 first.
-	if fileData.Password != "" && !hasPasswordCookie(r, subpath) {
-		serveGatePage(w, gateData{
+	// Signed links are checked on any share, so their limits always hold.
+	if isSignedLinkRequest(r) {
+		if !signedLinkOrErr(w, r, config, subpath, relativePath) {
+			return nil, false
+		}
+	} else if fileData.Password != "" && !isShareUnlocked(r, subpath, fileData) {
+		// Only browsers can use the HTML gate; curl, wget and scripts are
+		// asked for the password via Basic auth instead.
+		if !wantsGatePage(r) {
+			requestBasicAuth(w, subpath)
+			return nil, false
+		}
+		serveGatePage(w, r, gateData{
 			Subpath:    subpath,
 			FormAction: "/" + subpath + "/unlock",
+			Brand:      brandingFor(subpath, fileData, false),
 		})
 		return nil, false
 	}
@@ -108,6 +138,7 @@ func prepareRequest(w http.ResponseWriter, r *http.Request) (*requestContext, bo
 }
 
 // handleGet serves a file or directory, enforcing expiration and use limits.
+// Selection downloads (POST ?download=zip) take the same path as directory views.
 func handleGet(w http.ResponseWriter, r *http.Request, ctx *requestContext) {
 	fd := ctx.fileData
 
@@ -121,11 +152,29 @@ func handleGet(w http.ResponseWriter, r *http.Request, ctx *requestContext) {
 		return
 	}
 
-	isFileDownload := !ctx.fileInfo.IsDir()
+	if !shared.HasPermission(fd, shared.PermRead) && !isDropBoxPage(r, ctx) {
+		http.Error(w, "Forbidden: this share does not allow read", http.StatusForbidden)
+		return
+	}
+
+	// Manifests, thumbnails and browsing an archive count like opening a
+	// directory, not like downloading the file.
+	isFileDownload := !ctx.fileInfo.IsDir() && !isManifestRequest(r) && !isThumbnailRequest(r) &&
+		!isArchiveBrowseRequest(r)
 	isShareRoot := ctx.diskPath == ctx.fileData.Path
 	shouldCount := isShareRoot && (isFileDownload || !hasSessionCookie(r, ctx.subpath))
+	// Media players fetch a file in many range requests; only the first counts.
+	usesScope := "uses\x00" + ctx.subpath
+	if isFileDownload && isRangeContinuation(r, usesScope) {
+		shouldCount = false
+	}
 
-	if shouldCount && fd.Uses > 0 {
+	if shared.CountsVisitors(fd) {
+		rel, download := visitorDownloadPath(r, ctx)
+		if !visitorOrErr(w, r, ctx.config, ctx.subpath, rel, download) {
+			return
+		}
+	} else if shouldCount && fd.Uses > 0 {
 		fd.Uses--
 		if fd.Uses == 0 {
 			fd.Expired = true
@@ -135,43 +184,44 @@ func handleGet(w http.ResponseWriter, r *http.Request, ctx *requestContext) {
 			GoLog.Errorf("failed to save config: %v", err)
 			return
 		}
-		if !isFileDownload {
+		if isFileDownload {
+			grantRangeContinuations(r, usesScope)
+		} else {
 			setSessionCookie(w, ctx.subpath)
 		}
 	}
 
-	if ctx.fileInfo.IsDir() {
+	w.Header().Set("Cache-Control", shareCacheControl(fd))
+	if isSignedLinkRequest(r) {
+		// Signed links expire and count uses on their own; no cache may
+		// answer for them.
+		w.Header().Set("Cache-Control", "private, no-store")
+	}
+
+	switch {
+	case isManifestRequest(r):
+		serveManifest(w, r, ctx)
+	case isThumbnailRequest(r):
+		serveThumbnail(w, r, ctx)
+	case ctx.fileInfo.IsDir():
 		serveDirectory(w, r, ctx)
-	} else {
+	case isViewRequest(r):
+		serveView(w, r, ctx)
+	case isArchiveBrowseRequest(r):
+		serveArchiveBrowse(w, r, ctx)
+	default:
 		http.ServeFile(w, r, ctx.diskPath)
 	}
 }
 
-// resolveUploadTarget loads the FileData for the subpath in the request URL
-// and verifies that uploads are permitted.
-func resolveUploadTarget(w http.ResponseWriter, r *http.Request) (shared.FileData, bool) {
-	config, err := shared.LoadConfig()
-	if err != nil {
-		GoLog.Errorf("resolveUploadTarget: load config: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return shared.FileData{}, false
-	}
-
-	subpath := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)[0]
-	fd, exists := config.Files[subpath]
-	if !exists {
-		http.NotFound(w, r)
-		return shared.FileData{}, false
-	}
-	if !fd.AllowPost {
-		http.Error(w, "Forbidden", http.StatusForbidden)
-		return shared.FileData{}, false
-	}
-	if shared.IsExpired(fd) {
-		http.Error(w, "File share expired", http.StatusGone)
-		return shared.FileData{}, false
-	}
-	return fd, true
+// isDropBoxPage reports whether r asks for a directory page a share without
+// the read permission still serves: such a share is a drop box, whose pages
+// offer uploading but list nothing.
+func isDropBoxPage(r *http.Request, ctx *requestContext) bool {
+	q := r.URL.Query()
+	return ctx.fileInfo.IsDir() && r.Method != http.MethodPost && !wantsJSON(r) &&
+		q.Get("download") == "" && q.Get("playlist") == "" && q.Get("search") == "" &&
+		!isManifestRequest(r) && !isThumbnailRequest(r)
 }
 
 // hasSessionCookie returns true if the browser already has a session cookie for this share.
@@ -203,30 +253,44 @@ type gateData struct {
 	FormAction       string
 	ShowUsername     bool
 	WrongCredentials bool
+	Brand            shareBranding // empty for the admin login
+
+	// Opens turns the gate into a countdown to when a share opens: its
+	// activation, or with Closed, its next availability window.
+	Opens   time.Time
+	OpensIn int64 // seconds
+	Closed  bool
 }
 
-func loadGateTemplate() (*template.Template, error) {
+func loadGateTemplate(lang string) (*template.Template, error) {
 	gateTemplateOnce.Do(func() {
-		gateTemplate, gateTemplateErr = template.New("gate").ParseFiles(gateHtmlPath)
+		gateTemplate, gateTemplateErr = parseTemplate("gate", gateHtmlPath, nil)
 	})
-	return gateTemplate, gateTemplateErr
+	if gateTemplateErr != nil {
+		return nil, gateTemplateErr
+	}
+	return localize(gateTemplate, lang)
 }
 
-func serveGatePage(w http.ResponseWriter, data gateData) {
-	tmpl, err := loadGateTemplate()
+func serveGatePage(w http.ResponseWriter, r *http.Request, data gateData) {
+	tmpl, err := loadGateTemplate(pageLanguage(w, r))
 	if err != nil {
 		GoLog.Errorf("failed to load gate template: %v", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if !data.Opens.IsZero() {
+		w.WriteHeader(http.StatusForbidden)
+	}
 	if err := tmpl.ExecuteTemplate(w, "gate", data); err != nil {
 		GoLog.Errorf("failed to render gate template: %v", err)
 	}
 }
 
 // handleUnlock handles POST /{subpath}/unlock — verifies the share password,
-// issues a token cookie on success, and redirects to the share.
+// issues a token cookie on success, and redirects to the share. JSON clients
+// get a one-time token instead.
 // Not wrapped in loggingMiddleware intentionally — form body contains the password.
 func handleUnlock(w http.ResponseWriter, r *http.Request) {
 	subpath := r.PathValue("subpath")
@@ -257,10 +321,15 @@ func handleUnlock(w http.ResponseWriter, r *http.Request) {
 
 	if !shared.CheckPassword(r.FormValue("password"), fd.Password) {
 		GoLog.Warnf("failed unlock attempt for share /%s", subpath)
-		serveGatePage(w, gateData{
+		if wantsJSON(r) {
+			http.Error(w, "Forbidden: wrong password", http.StatusForbidden)
+			return
+		}
+		serveGatePage(w, r, gateData{
 			Subpath:          subpath,
 			FormAction:       "/" + subpath + "/unlock",
 			WrongCredentials: true,
+			Brand:            brandingFor(subpath, fd, false),
 		})
 		return
 	}
@@ -271,6 +340,17 @@ func handleUnlock(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
+	// JSON clients get a one-time token instead of a cookie, to hand a
+	// single download to a client that can't be given the password.
+	if wantsJSON(r) {
+		expiresAt := storeOneTimeToken(token, subpath)
+		jsonResponse(w, map[string]any{
+			"token":      token,
+			"param":      onceParam,
+			"expires_at": expiresAt.Unix(),
+		})
+		return
+	}
 	storeShareToken(token, subpath)
 	setPasswordCookie(w, subpath, token)
 	http.Redirect(w, r, "/"+subpath, http.StatusSeeOther)
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Wirezat/GoLog"
+	"github.com/Wirezat/fileshare/pkg/shared"
+)
+
+// supportedLanguages are the languages of the visitor pages. The first one is
+// the fallback for strings a catalog lacks.
+var supportedLanguages = []string{"en", "de"}
+
+// langCookie holds the language picked with the switcher on the pages.
+const langCookie = "lang"
+
+// jsKeyPrefix marks the catalog strings handed to share.js.
+const jsKeyPrefix = "js."
+
+var (
+	catalogs     map[string]map[string]string
+	catalogsOnce sync.Once
+)
+
+// loadCatalogs reads the translation catalogs from webFS once, so catalogs in
+// Config.AssetsDir override the built-in ones. Each is a flat JSON object of
+// message keys to Printf formats; plural forms are keyed <key>.one and
+// <key>.other. A catalog that is missing or broken leaves its language to the
+// fallback.
+func loadCatalogs() map[string]map[string]string {
+	catalogsOnce.Do(func() {
+		catalogs = make(map[string]map[string]string, len(supportedLanguages))
+		for _, lang := range supportedLanguages {
+			data, err := fs.ReadFile(webFS, path.Join(i18nDir, lang+".json"))
+			if err != nil {
+				GoLog.Errorf("translation catalog %s: %v", lang, err)
+				continue
+			}
+			var messages map[string]string
+			if err := json.Unmarshal(data, &messages); err != nil {
+				GoLog.Errorf("translation catalog %s: %v", lang, err)
+				continue
+			}
+			catalogs[lang] = messages
+		}
+	})
+	return catalogs
+}
+
+// translate returns the message for key in lang, formatted with args. Missing
+// messages fall back to the first supported language, then to the key itself.
+func translate(lang, key string, args ...any) string {
+	c := loadCatalogs()
+	msg, ok := c[lang][key]
+	if !ok {
+		msg, ok = c[supportedLanguages[0]][key]
+	}
+	if !ok {
+		return key
+	}
+	if len(args) == 0 {
+		return msg
+	}
+	return fmt.Sprintf(msg, args...)
+}
+
+// translatePlural picks the plural form of key for n and formats it with n
+// followed by args. English and German both use "one" for 1 only.
+func translatePlural(lang, key string, n int, args ...any) string {
+	form := ".other"
+	if n == 1 {
+		form = ".one"
+	}
+	return translate(lang, key+form, append([]any{n}, args...)...)
+}
+
+func isSupportedLanguage(lang string) bool {
+	return slices.Contains(supportedLanguages, lang)
+}
+
+// requestLanguage picks the language of a page: the switcher's cookie first,
+// then the best supported match in Accept-Language, then
+// Config.DefaultLanguage.
+func requestLanguage(r *http.Request) string {
+	if c, err := r.Cookie(langCookie); err == nil && isSupportedLanguage(c.Value) {
+		return c.Value
+	}
+	if lang := negotiateLanguage(r.Header.Get("Accept-Language")); lang != "" {
+		return lang
+	}
+	if config, err := shared.LoadConfig(); err == nil && isSupportedLanguage(config.DefaultLanguage) {
+		return config.DefaultLanguage
+	}
+	return supportedLanguages[0]
+}
+
+// negotiateLanguage returns the supported language with the highest q-value
+// in an Accept-Language header, matching on the primary subtag ("de-AT" is
+// "de"). It returns "" if none is acceptable; "*" is left to the default.
+func negotiateLanguage(header string) string {
+	best, bestQ := "", 0.0
+	for item := range strings.SplitSeq(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(item), ";")
+		primary, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if !isSupportedLanguage(primary) {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q > bestQ {
+			best, bestQ = primary, q
+		}
+	}
+	return best
+}
+
+// pageLanguage returns the language to render a page in and marks the
+// response as varying with the headers that decide it.
+func pageLanguage(w http.ResponseWriter, r *http.Request) string {
+	w.Header().Add("Vary", "Accept-Language, Cookie")
+	return requestLanguage(r)
+}
+
+// languageOption is an entry of the language switcher.
+type languageOption struct {
+	Code string
+	Name string // in the language itself
+}
+
+// i18nFuncs returns the template funcs that render strings in lang:
+//
+//	{{t "key" args...}}       a message
+//	{{tn "key" n args...}}    a plural message, formatted with n first
+//	{{lang}}                  the language code, for <html lang>
+//	{{languages}}             the switcher's options
+//	{{jsMessages}}            the js.* messages for share.js, as a JS object
+func i18nFuncs(lang string) template.FuncMap {
+	return template.FuncMap{
+		"t": func(key string, args ...any) string {
+			return translate(lang, key, args...)
+		},
+		"tn": func(key string, n int, args ...any) string {
+			return translatePlural(lang, key, n, args...)
+		},
+		"lang": func() string { return lang },
+		"languages": func() []languageOption {
+			opts := make([]languageOption, len(supportedLanguages))
+			for i, code := range supportedLanguages {
+				opts[i] = languageOption{Code: code, Name: translate(code, "language.name")}
+			}
+			return opts
+		},
+		"jsMessages": func() map[string]string {
+			messages := make(map[string]string)
+			for _, code := range []string{supportedLanguages[0], lang} {
+				for key, msg := range loadCatalogs()[code] {
+					if name, ok := strings.CutPrefix(key, jsKeyPrefix); ok {
+						messages[name] = msg
+					}
+				}
+			}
+			return messages
+		},
+	}
+}
+
+// localizedKey identifies a parsed template rendered in one language.
+type localizedKey struct {
+	base *template.Template
+	lang string
+}
+
+// localizedTemplates caches the per-language clones made by localize.
+var localizedTemplates sync.Map // localizedKey → *template.Template
+
+// localize returns a copy of a template from parseTemplate whose i18n funcs
+// render in lang. The copies are made once per language; the parsed template
+// itself is never executed, as html/template can't clone it after that.
+func localize(base *template.Template, lang string) (*template.Template, error) {
+	key := localizedKey{base, lang}
+	if t, ok := localizedTemplates.Load(key); ok {
+		return t.(*template.Template), nil
+	}
+	t, err := base.Clone()
+	if err != nil {
+		return nil, err
+	}
+	t.Funcs(i18nFuncs(lang))
+	actual, _ := localizedTemplates.LoadOrStore(key, t)
+	return actual.(*template.Template), nil
+}
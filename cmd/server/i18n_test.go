@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestNegotiateLanguage(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", ""},
+		{"de", "de"},
+		{"de-DE,de;q=0.9,en;q=0.8", "de"},
+		{"EN-us", "en"},
+		{"fr-FR,fr;q=0.9,de;q=0.5,en;q=0.7", "en"},
+		{"en;q=0.5, de;q=0.8", "de"},
+		{"en, de", "en"},
+		{"fr, es;q=0.5", ""},
+		{"*", ""},
+		{"de;q=0", ""},
+		{"de;q=abc, en;q=0.1", "en"},
+	}
+	for _, tt := range tests {
+		if got := negotiateLanguage(tt.header); got != tt.want {
+			t.Errorf("negotiateLanguage(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Wirezat/fileshare/pkg/shared"
+)
+
+// Sort keys accepted by ?sort=.
+const (
+	sortName = "name"
+	sortSize = "size"
+	sortDate = "date"
+)
+
+// listingOptions controls which slice of a directory is returned.
+// Parsed from ?sort=, ?order=, ?q=, ?cursor= and ?limit=.
+type listingOptions struct {
+	Sort   string
+	Desc   bool
+	Filter string
+	Cursor string
+	Limit  int
+}
+
+// listingPage is one page of a sorted, filtered directory listing.
+type listingPage struct {
+	Files      []shared.FileInfo
+	Total      int   // entries matching the filter, across all pages
+	TotalSize  int64 // bytes of every file below the directory; see folderSize
+	SizeCapped bool  // TotalSize is a lower bound, as counting took too long
+	NextCursor string
+}
+
+// folderSizeTTL is how long a folder's size is reused before it is counted
+// again, so paging through a large folder walks it once.
+const folderSizeTTL = 30 * time.Second
+
+type cachedFolderSize struct {
+	bytes     int64
+	capped    bool
+	expiresAt time.Time
+}
+
+var (
+	folderSizesMu sync.Mutex
+	// folderSizes maps a folder's disk path to its last counted size.
+	folderSizes = map[string]cachedFolderSize{}
+)
+
+// folderSize returns the bytes of every file below dir, counted like an
+// archive of it: hidden entries are left out, symlinks to files followed.
+// Counting gives up after timeout, in which case capped is true and bytes
+// only covers what was counted so far.
+func folderSize(ctx context.Context, dir string, timeout time.Duration) (bytes int64, capped bool) {
+	now := time.Now()
+	folderSizesMu.Lock()
+	cached, ok := folderSizes[dir]
+	folderSizesMu.Unlock()
+	if ok && now.Before(cached.expiresAt) {
+		return cached.bytes, cached.capped
+	}
+
+	walkCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err := walkRegularFiles(walkCtx, dir, []string{dir}, func(_, _ string, info os.FileInfo) error {
+		bytes += info.Size()
+		return nil
+	})
+	if err != nil && ctx.Err() != nil {
+		// The client left; don't cache a count cut short on its account.
+		return bytes, true
+	}
+	capped = err != nil
+
+	folderSizesMu.Lock()
+	for d, c := range folderSizes {
+		if now.After(c.expiresAt) {
+			delete(folderSizes, d)
+		}
+	}
+	folderSizes[dir] = cachedFolderSize{bytes: bytes, capped: capped, expiresAt: now.Add(folderSizeTTL)}
+	folderSizesMu.Unlock()
+	return bytes, capped
+}
+
+// listingCursor marks the last entry of the previous page. Pages are
+// keyset-based, so entries added or removed between requests don't shift
+// later pages. Sort and Desc guard against reusing a cursor across orderings.
+type listingCursor struct {
+	Sort string `json:"s"`
+	Desc bool   `json:"o,omitempty"`
+	Dir  bool   `json:"d,omitempty"`
+	Num  int64  `json:"v,omitempty"`
+	Name string `json:"n"`
+}
+
+// dirEntry is a visible directory entry with its stat result.
+type dirEntry struct {
+	name string
+	info os.FileInfo
+}
+
+// parseListingOptions reads listing options from the query string.
+// Unknown sort keys fall back to name; limit is capped at pageSize.
+func parseListingOptions(r *http.Request, pageSize int) listingOptions {
+	q := r.URL.Query()
+	opts := listingOptions{
+		Sort:   q.Get("sort"),
+		Desc:   q.Get("order") == "desc",
+		Filter: strings.TrimSpace(q.Get("q")),
+		Cursor: q.Get("cursor"),
+		Limit:  pageSize,
+	}
+	if opts.Sort != sortSize && opts.Sort != sortDate {
+		opts.Sort = sortName
+	}
+	if n, err := strconv.Atoi(q.Get("limit")); err == nil && n > 0 && n < pageSize {
+		opts.Limit = n
+	}
+	return opts
+}
+
+// listDirectory returns one page of dirPath, sorted and filtered per opts.
+// Every visible entry is stat'ed so sorting and Total cover the whole
+// directory, but the more expensive per-entry work (MIME sniffing, child
+// counts) is only done for entries on the returned page.
+func listDirectory(dirPath, basePath string, opts listingOptions) (listingPage, error) {
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return listingPage{}, err
+	}
+
+	filter := strings.ToLower(opts.Filter)
+	visible := make([]dirEntry, 0, len(entries))
+	var page listingPage
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		if filter != "" && !strings.Contains(strings.ToLower(name), filter) {
+			continue
+		}
+		info, err := os.Stat(filepath.Join(dirPath, name))
+		if err != nil {
+			// Broken symlink — fall back to the link itself.
+			if info, err = entry.Info(); err != nil {
+				continue
+			}
+		}
+		visible = append(visible, dirEntry{name: name, info: info})
+	}
+	page.Total = len(visible)
+
+	slices.SortFunc(visible, func(a, b dirEntry) int {
+		return compareEntries(cursorFor(a, opts), cursorFor(b, opts), opts.Desc)
+	})
+
+	start := 0
+	if c, ok := decodeCursor(opts.Cursor); ok && c.Sort == opts.Sort && c.Desc == opts.Desc {
+		start, _ = slices.BinarySearchFunc(visible, c, func(e dirEntry, c listingCursor) int {
+			if compareEntries(cursorFor(e, opts), c, opts.Desc) <= 0 {
+				return -1
+			}
+			return 1
+		})
+	}
+	end := min(start+opts.Limit, len(visible))
+
+	rel := filepath.Join("/", strings.TrimPrefix(dirPath, basePath))
+	page.Files = make([]shared.FileInfo, 0, end-start)
+	for _, e := range visible[start:end] {
+		page.Files = append(page.Files, fileInfoFor(dirPath, rel, e))
+	}
+	if end < len(visible) {
+		page.NextCursor = encodeCursor(cursorFor(visible[end-1], opts))
+	}
+	return page, nil
+}
+
+// cursorFor returns the sort key of an entry under the given options.
+func cursorFor(e dirEntry, opts listingOptions) listingCursor {
+	c := listingCursor{Sort: opts.Sort, Desc: opts.Desc, Dir: e.info.IsDir(), Name: e.name}
+	switch opts.Sort {
+	case sortSize:
+		if !c.Dir {
+			c.Num = e.info.Size()
+		}
+	case sortDate:
+		c.Num = e.info.ModTime().Unix()
+	}
+	return c
+}
+
+// compareEntries orders directories before files, then by the sort key,
+// with the name as tie-breaker. desc reverses everything but the dirs-first rule.
+func compareEntries(a, b listingCursor, desc bool) int {
+	if a.Dir != b.Dir {
+		if a.Dir {
+			return -1
+		}
+		return 1
+	}
+	c := cmp.Compare(a.Num, b.Num)
+	if c == 0 {
+		c = cmp.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+	}
+	if c == 0 {
+		c = cmp.Compare(a.Name, b.Name)
+	}
+	if desc {
+		return -c
+	}
+	return c
+}
+
+func encodeCursor(c listingCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (listingCursor, bool) {
+	var c listingCursor
+	if s == "" {
+		return c, false
+	}
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || json.Unmarshal(data, &c) != nil {
+		return c, false
+	}
+	return c, true
+}
+
+// fileInfoFor builds the full FileInfo for an entry shown on a page.
+func fileInfoFor(dirPath, relDir string, e dirEntry) shared.FileInfo {
+	fullPath := filepath.Join(dirPath, e.name)
+	fi := shared.FileInfo{
+		Name:    e.name,
+		Path:    filepath.Join(relDir, e.name),
+		IsDir:   e.info.IsDir(),
+		ModTime: e.info.ModTime().Unix(),
+	}
+	if fi.IsDir {
+		fi.ChildCount = countChildren(fullPath)
+	} else {
+		fi.Size = e.info.Size()
+		fi.MimeType = detectMimeType(fullPath)
+	}
+	return fi
+}
+
+// listingURL returns the current listing URL with the given query
+// parameters replaced; empty values are removed.
+func listingURL(r *http.Request, set map[string]string) string {
+	q := r.URL.Query()
+	for k, v := range set {
+		if v == "" {
+			q.Del(k)
+		} else {
+			q.Set(k, v)
+		}
+	}
+	u := url.URL{Path: r.URL.Path, RawQuery: q.Encode()}
+	return u.String()
+}
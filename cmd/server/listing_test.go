@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestFolderSize(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"a.txt":         "12345",
+		"sub/b.txt":     "123",
+		"sub/deep/c":    "1",
+		".hidden/d.txt": "1234567890",
+		"sub/.e":        "1234567890",
+	})
+	outside := writeTree(t, map[string]string{"f.txt": "1234"})
+	os.Symlink(filepath.Join(outside, "f.txt"), filepath.Join(root, "link.txt"))
+
+	tests := []struct {
+		dir  string
+		want int64
+	}{
+		{root, 5 + 3 + 1 + 4},
+		{filepath.Join(root, "sub"), 3 + 1},
+		{filepath.Join(root, "sub", "deep"), 1},
+	}
+	for _, tt := range tests {
+		got, capped := folderSize(context.Background(), tt.dir, time.Minute)
+		if got != tt.want || capped {
+			t.Errorf("folderSize(%s) = %d, %v, want %d, false", tt.dir, got, capped, tt.want)
+		}
+	}
+
+	// Sizes are reused for a while, then counted again.
+	os.WriteFile(filepath.Join(root, "sub", "new.txt"), []byte("1234567"), 0644)
+	if got, _ := folderSize(context.Background(), filepath.Join(root, "sub"), time.Minute); got != 4 {
+		t.Errorf("folderSize right after a change = %d, want the cached 4", got)
+	}
+	folderSizesMu.Lock()
+	delete(folderSizes, filepath.Join(root, "sub"))
+	folderSizesMu.Unlock()
+	if got, _ := folderSize(context.Background(), filepath.Join(root, "sub"), time.Minute); got != 11 {
+		t.Errorf("folderSize once the cache expired = %d, want 11", got)
+	}
+}
+
+func TestFolderSizeCapped(t *testing.T) {
+	root := writeTree(t, map[string]string{"a.txt": "12345"})
+	if _, capped := folderSize(context.Background(), root, 0); !capped {
+		t.Error("folderSize without time to count: want capped")
+	}
+
+	// A client that left doesn't leave a capped size behind for others.
+	root = writeTree(t, map[string]string{"a.txt": "12345"})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, capped := folderSize(ctx, root, time.Minute); !capped {
+		t.Error("folderSize for a client that left: want capped")
+	}
+	if got, capped := folderSize(context.Background(), root, time.Minute); got != 5 || capped {
+		t.Errorf("folderSize after a cancelled count = %d, %v, want 5, false", got, capped)
+	}
+}
+
+// listAll pages through dir with opts and returns the names in order.
+func listAll(t *testing.T, dir string, opts listingOptions) []string {
+	t.Helper()
+	var names []string
+	for range 100 {
+		page, err := listDirectory(dir, dir, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range page.Files {
+			names = append(names, f.Name)
+		}
+		if page.NextCursor == "" {
+			return names
+		}
+		opts.Cursor = page.NextCursor
+	}
+	t.Fatal("listing never ended")
+	return nil
+}
+
+func TestListDirectoryPages(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"b.txt":      "12",
+		"A.txt":      "1234",
+		"c.txt":      "1",
+		"d.txt":      "123",
+		"same.txt":   "12",
+		"zdir/x":     "x",
+		"adir/x":     "x",
+		".hidden":    "1",
+		"filter.log": "12345",
+	})
+	base := time.Date(2026, time.October, 1, 0, 0, 0, 0, time.UTC)
+	// Folders come first, tied by date and so ordered by name.
+	for _, name := range []string{"adir", "zdir"} {
+		os.Chtimes(filepath.Join(root, name), base, base)
+	}
+	for i, name := range []string{"d.txt", "c.txt", "b.txt", "A.txt", "same.txt", "filter.log"} {
+		at := base.Add(time.Duration(i) * time.Hour)
+		os.Chtimes(filepath.Join(root, name), at, at)
+	}
+
+	tests := []struct {
+		opts listingOptions
+		want []string
+	}{
+		{listingOptions{Sort: sortName}, []string{"adir", "zdir", "A.txt", "b.txt", "c.txt", "d.txt", "filter.log", "same.txt"}},
+		{listingOptions{Sort: sortName, Desc: true}, []string{"zdir", "adir", "same.txt", "filter.log", "d.txt", "c.txt", "b.txt", "A.txt"}},
+		{listingOptions{Sort: sortSize}, []string{"adir", "zdir", "c.txt", "b.txt", "same.txt", "d.txt", "A.txt", "filter.log"}},
+		{listingOptions{Sort: sortDate}, []string{"adir", "zdir", "d.txt", "c.txt", "b.txt", "A.txt", "same.txt", "filter.log"}},
+		{listingOptions{Sort: sortName, Filter: "TXT"}, []string{"A.txt", "b.txt", "c.txt", "d.txt", "same.txt"}},
+	}
+	for _, tt := range tests {
+		for _, limit := range []int{1, 2, 3, 100} {
+			opts := tt.opts
+			opts.Limit = limit
+			if got := listAll(t, root, opts); !slices.Equal(got, tt.want) {
+				t.Errorf("%+v: pages of %d = %v, want %v", tt.opts, limit, got, tt.want)
+			}
+		}
+		page, err := listDirectory(root, root, listingOptions{Sort: tt.opts.Sort, Desc: tt.opts.Desc, Filter: tt.opts.Filter, Limit: 2})
+		if err != nil || page.Total != len(tt.want) {
+			t.Errorf("%+v: Total = %d, %v, want %d", tt.opts, page.Total, err, len(tt.want))
+		}
+	}
+}
+
+func TestListDirectoryCursor(t *testing.T) {
+	root := writeTree(t, map[string]string{"a": "", "c": "", "e": "", "g": ""})
+	opts := listingOptions{Sort: sortName, Limit: 2}
+	first, err := listDirectory(root, root, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Entries added before the cursor don't shift the next page, and
+	// removing the last entry shown doesn't lose its successor.
+	os.WriteFile(filepath.Join(root, "b"), nil, 0644)
+	os.Remove(filepath.Join(root, "c"))
+	opts.Cursor = first.NextCursor
+	second, err := listDirectory(root, root, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range second.Files {
+		got = append(got, f.Name)
+	}
+	if !slices.Equal(got, []string{"e", "g"}) {
+		t.Errorf("page after the cursor = %v, want [e g]", got)
+	}
+
+	// A cursor from another ordering, or garbage, starts over.
+	for _, cursor := range []string{first.NextCursor, "not-a-cursor", ""} {
+		opts := listingOptions{Sort: sortName, Desc: true, Limit: 1, Cursor: cursor}
+		page, err := listDirectory(root, root, opts)
+		if err != nil || len(page.Files) != 1 || page.Files[0].Name != "g" {
+			t.Errorf("cursor %q for a descending listing = %v, %v, want it to start at g", cursor, page.Files, err)
+		}
+	}
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	for _, c := range []listingCursor{
+		{Sort: sortName, Name: "a.txt"},
+		{Sort: sortSize, Desc: true, Num: 1 << 40, Name: "big file ü.bin"},
+		{Sort: sortDate, Dir: true, Num: -1, Name: "old"},
+	} {
+		got, ok := decodeCursor(encodeCursor(c))
+		if !ok || got != c {
+			t.Errorf("decodeCursor(encodeCursor(%+v)) = %+v, %v", c, got, ok)
+		}
+	}
+	for _, s := range []string{"", "!!!", "bm90IGpzb24"} {
+		if _, ok := decodeCursor(s); ok {
+			t.Errorf("decodeCursor(%q) succeeded, want it to fail", s)
+		}
+	}
+}
+
+func TestParseListingOptions(t *testing.T) {
+	tests := []struct {
+		query string
+		want  listingOptions
+	}{
+		{"", listingOptions{Sort: sortName, Limit: 50}},
+		{"sort=size&order=desc", listingOptions{Sort: sortSize, Desc: true, Limit: 50}},
+		{"sort=date&order=asc", listingOptions{Sort: sortDate, Limit: 50}},
+		{"sort=owner", listingOptions{Sort: sortName, Limit: 50}},
+		{"q=+report+&cursor=abc", listingOptions{Sort: sortName, Filter: "report", Cursor: "abc", Limit: 50}},
+		{"limit=10", listingOptions{Sort: sortName, Limit: 10}},
+		{"limit=500", listingOptions{Sort: sortName, Limit: 50}},
+		{"limit=0", listingOptions{Sort: sortName, Limit: 50}},
+		{"limit=-5", listingOptions{Sort: sortName, Limit: 50}},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/s/?"+tt.query, nil)
+		if got := parseListingOptions(r, 50); got != tt.want {
+			t.Errorf("parseListingOptions(%q) = %+v, want %+v", tt.query, got, tt.want)
+		}
+	}
+}
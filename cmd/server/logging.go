@@ -108,9 +108,10 @@ func (rl *requestLog) toJSON() ([]byte, error) {
 // Multipart metadata is read from context (already parsed by multipartMiddleware).
 // Other body types are read and restored for downstream handlers.
 func buildRequestLog(r *http.Request) *requestLog {
-	decodedURL, err := url.QueryUnescape(r.URL.RequestURI())
+	uri := redactedRequestURI(r.URL)
+	decodedURL, err := url.QueryUnescape(uri)
 	if err != nil {
-		decodedURL = r.URL.RequestURI()
+		decodedURL = uri
 	}
 
 	entry := &requestLog{
@@ -174,6 +175,27 @@ var sensitiveHeaders = map[string]bool{
 	http.CanonicalHeaderKey("x-auth-token"):  true,
 }
 
+// Query parameters whose values are replaced in request logs.
+var sensitiveParams = []string{accessParam, onceParam, shared.LinkSigParam}
+
+// redactedRequestURI returns u's request URI with sensitive query values masked.
+func redactedRequestURI(u *url.URL) string {
+	q := u.Query()
+	redacted := false
+	for _, name := range sensitiveParams {
+		if q.Has(name) {
+			q.Set(name, "REDACTED")
+			redacted = true
+		}
+	}
+	if !redacted {
+		return u.RequestURI()
+	}
+	c := *u
+	c.RawQuery = q.Encode()
+	return c.RequestURI()
+}
+
 // safeHeaders returns request headers with sensitive fields stripped.
 func safeHeaders(r *http.Request) map[string]string {
 	headers := make(map[string]string, len(r.Header))
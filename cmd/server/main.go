@@ -8,6 +8,7 @@ import (
 
 	"github.com/Wirezat/GoLog"
 	"github.com/Wirezat/fileshare/pkg/shared"
+	"golang.org/x/sync/semaphore"
 )
 
 var startTime = time.Now()
@@ -26,15 +27,19 @@ func buildMux() *http.ServeMux {
 
 	adminRoutes := map[string]http.HandlerFunc{
 		"/admin":                                       handleAdminUI,
-		"/admin/static/admin.css":                      handleAdminCSS,
-		"/admin/static/admin.js":                       handleAdminJS,
+		"/admin/static/admin.css":                      handleStaticAsset,
+		"/admin/static/admin.js":                       handleStaticAsset,
 		"/admin/api/shares":                            handleAdminShares,
+		"/admin/api/links":                             handleAdminLinks,
 		"/admin/api/logs":                              handleAdminLogs,
 		"/admin/api/logs/stream":                       handleAdminLogsStream,
 		"/admin/api/settings/username":                 handleAdminSettingsUsername,
 		"/admin/api/settings/password":                 handleAdminSettingsPassword,
 		"/admin/api/settings/max_post_size":            handleAdminSettingsMaxPostSize,
 		"/admin/api/settings/chunk_inactivity_timeout": handleAdminSettingsChunkInactivityTimeout,
+		"/admin/api/settings/chunk_assembly_mode":      handleAdminSettingsChunkAssemblyMode,
+		"/admin/api/settings/listing_page_size":        handleAdminSettingsListingPageSize,
+		"/admin/api/settings/default_language":         handleAdminSettingsDefaultLanguage,
 		"/admin/api/settings/prune_expired":            handleAdminFunctionPruneExpired,
 		"/admin/api/uptime":                            handleAdminUptime,
 	}
@@ -54,6 +59,14 @@ func buildMux() *http.ServeMux {
 	mux.HandleFunc("POST /{subpath}/chunk-init", handleChunkInit)
 	mux.HandleFunc("POST /{subpath}/chunk", handleChunkReceive)
 
+	// Visitor file operations — each checks the share's permissions.
+	mux.HandleFunc("POST /{subpath}/mkdir", handleVisitorMkdir)
+	mux.HandleFunc("POST /{subpath}/rename", handleVisitorRename)
+	mux.HandleFunc("POST /{subpath}/delete", handleVisitorDelete)
+
+	// WebDAV — folder shares mounted in file managers, logged like public routes.
+	mux.Handle(davPrefix+"{subpath}/", chain(http.HandlerFunc(handleWebDAV), loggingMiddleware))
+
 	// Share unlock — not wrapped in loggingMiddleware (form body contains password).
 	mux.HandleFunc("POST /{subpath}/unlock", handleUnlock)
 
@@ -63,8 +76,9 @@ func buildMux() *http.ServeMux {
 		loggingMiddleware,
 	)
 	mux.Handle("/", public)
-	mux.HandleFunc("/static/share.css", handleShareCSS)
-	mux.HandleFunc("/static/share.js", handleShareJS)
+	mux.HandleFunc("/static/share.css", handleStaticAsset)
+	mux.HandleFunc("/static/share.js", handleStaticAsset)
+	mux.HandleFunc("GET /static/logo/{subpath}", handleShareLogo)
 
 	return mux
 }
@@ -99,10 +113,26 @@ func main() {
 		os.Exit(1)
 	}
 
+	if created, err := shared.EnsureLinkSecret(config); err != nil {
+		GoLog.Errorf("failed to generate link secret: %v", err)
+		os.Exit(1)
+	} else if created {
+		if err := shared.SaveConfig(config); err != nil {
+			GoLog.Errorf("failed to save config: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	useAssetsDir(config.AssetsDir)
 	storage = NewLocalStorage(config)
+	archiveSlots = semaphore.NewWeighted(int64(config.MaxConcurrentArchives))
 	storage.StartReaper()
 	startTokenReaper()
 	startAdminTokenReaper()
-	startExpirationWatcher(5 * time.Minute)
+	startOwnedEntryReaper()
+	startDAVReaper()
+	startRangeGrantReaper()
+	startThumbnailReaper(shared.ThumbnailDir(config))
+	startShareScheduler(5 * time.Minute)
 	startServer(config)
 }
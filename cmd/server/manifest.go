@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Wirezat/GoLog"
+)
+
+// manifestName is the file name of embedded and downloaded manifests.
+const manifestName = "SHA256SUMS"
+
+// manifestResponse is the JSON form of a checksum manifest.
+// GET /{subpath}/{path}?manifest=sha256&format=json
+type manifestResponse struct {
+	Algorithm string          `json:"algorithm"`
+	Path      string          `json:"path"` // relative to the share root
+	Files     []manifestEntry `json:"files"`
+}
+
+// manifestEntry is one file in a manifest. Path is relative to the
+// manifest's directory, as in a ZIP of that directory.
+type manifestEntry struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// manifestFile is a file waiting to be hashed.
+type manifestFile struct {
+	key  fileKey
+	name string
+}
+
+// isManifestRequest reports whether r asks for a standalone manifest.
+// Together with ?download=, ?manifest= embeds one in the archive instead.
+func isManifestRequest(r *http.Request) bool {
+	q := r.URL.Query()
+	return q.Get("manifest") != "" && q.Get("download") == ""
+}
+
+// serveManifest answers ?manifest=sha256 with a SHA256SUMS listing of the
+// requested file or of every file below the requested directory, or with
+// a manifestResponse for JSON clients.
+func serveManifest(w http.ResponseWriter, r *http.Request, ctx *requestContext) {
+	if algo := r.URL.Query().Get("manifest"); algo != "sha256" {
+		http.Error(w, "Unsupported manifest algorithm (use sha256)", http.StatusBadRequest)
+		return
+	}
+
+	var files []manifestFile
+	if ctx.fileInfo.IsDir() {
+		_ = walkRegularFiles(r.Context(), ctx.diskPath, []string{ctx.diskPath}, func(path, name string, info os.FileInfo) error {
+			files = append(files, manifestFile{key: fileKeyOf(path, info), name: name})
+			return nil
+		})
+		slices.SortFunc(files, func(a, b manifestFile) int { return strings.Compare(a.name, b.name) })
+	} else {
+		files = []manifestFile{{key: fileKeyOf(ctx.diskPath, ctx.fileInfo), name: ctx.fileInfo.Name()}}
+	}
+
+	if !acquireArchiveSlot(r, ctx) {
+		return
+	}
+	start := time.Now()
+	entries, err := hashManifest(r.Context(), files)
+	archiveSlots.Release(1)
+	if err != nil {
+		if r.Context().Err() == nil {
+			GoLog.Errorf("manifest %s: %v", ctx.diskPath, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+	GoLog.Infof("manifest /%s: %d file(s) in %s", ctx.subpath, len(entries), time.Since(start).Round(time.Millisecond))
+
+	if wantsJSON(r) {
+		jsonResponse(w, manifestResponse{
+			Algorithm: "sha256",
+			Path:      filepath.Join("/", strings.TrimPrefix(ctx.diskPath, ctx.fileData.Path)),
+			Files:     entries,
+		})
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s"`, manifestName))
+	writeSHA256SUMS(w, entries)
+}
+
+// hashManifest hashes files in parallel, using cached sums where the file is
+// unchanged. Files that can't be read are left out; a file that changes while
+// it is hashed is retried once with its new size and mtime.
+func hashManifest(ctx context.Context, files []manifestFile) ([]manifestEntry, error) {
+	entries := make([]manifestEntry, len(files))
+	next := make(chan int)
+	var wg sync.WaitGroup
+
+	for range max(runtime.NumCPU(), 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				f := files[i]
+				sum, err := cachedSHA256(f.key)
+				if errors.Is(err, errFileChanged) {
+					if info, statErr := os.Stat(f.key.path); statErr == nil {
+						f.key = fileKeyOf(f.key.path, info)
+						sum, err = cachedSHA256(f.key)
+					}
+				}
+				if err != nil {
+					GoLog.Warnf("manifest: skipping %s: %v", f.key.path, err)
+					continue
+				}
+				entries[i] = manifestEntry{Path: f.name, Size: f.key.size, SHA256: sum}
+			}
+		}()
+	}
+
+feed:
+	for i := range files {
+		select {
+		case next <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(next)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return slices.DeleteFunc(entries, func(e manifestEntry) bool { return e.SHA256 == "" }), nil
+}
+
+// sumsEscaper escapes names the way sha256sum does for names containing
+// a backslash or line break.
+var sumsEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\r", `\r`)
+
+// writeSHA256SUMS writes entries in the format read by `sha256sum -c`.
+func writeSHA256SUMS(w io.Writer, entries []manifestEntry) {
+	for _, e := range entries {
+		name, prefix := e.Path, ""
+		if strings.ContainsAny(name, "\\\n\r") {
+			name, prefix = sumsEscaper.Replace(name), `\`
+		}
+		fmt.Fprintf(w, "%s%s  %s\n", prefix, e.SHA256, name)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Wirezat/fileshare/pkg/shared"
+	"golang.org/x/sync/semaphore"
+)
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestWriteSHA256SUMS(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"a.txt", "abc  a.txt\n"},
+		{"dir/with space.txt", "abc  dir/with space.txt\n"},
+		{`back\slash`, `\abc  back\\slash` + "\n"},
+		{"line\nbreak", `\abc  line\nbreak` + "\n"},
+		{"cr\rname", `\abc  cr\rname` + "\n"},
+	}
+	for _, tt := range tests {
+		var b strings.Builder
+		writeSHA256SUMS(&b, []manifestEntry{{Path: tt.path, SHA256: "abc"}})
+		if b.String() != tt.want {
+			t.Errorf("writeSHA256SUMS(%q) = %q, want %q", tt.path, b.String(), tt.want)
+		}
+	}
+}
+
+// manifestFilesOf returns the files of root by name, in the given order.
+func manifestFilesOf(t *testing.T, root string, names ...string) []manifestFile {
+	t.Helper()
+	var files []manifestFile
+	for _, name := range names {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		info, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, manifestFile{key: fileKeyOf(p, info), name: name})
+	}
+	return files
+}
+
+func TestHashManifest(t *testing.T) {
+	content := map[string]string{"a.txt": "alpha", "sub/b.txt": "beta", "empty": "", "gone.txt": "x", "grown.txt": "1"}
+	root := writeTree(t, content)
+	files := manifestFilesOf(t, root, "a.txt", "empty", "gone.txt", "grown.txt", "sub/b.txt")
+
+	// A file that disappears is left out; one that changes is hashed as it
+	// is now.
+	os.Remove(filepath.Join(root, "gone.txt"))
+	os.WriteFile(filepath.Join(root, "grown.txt"), []byte("12345"), 0644)
+	content["grown.txt"] = "12345"
+
+	entries, err := hashManifest(context.Background(), files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a.txt", "empty", "grown.txt", "sub/b.txt"}
+	if len(entries) != len(want) {
+		t.Fatalf("hashManifest = %v, want entries for %v", entries, want)
+	}
+	for i, e := range entries {
+		if e.Path != want[i] || e.SHA256 != sha256Hex(content[e.Path]) || e.Size != int64(len(content[e.Path])) {
+			t.Errorf("entry %d = %+v, want %s with %d bytes and sum %s", i, e, want[i], len(content[want[i]]), sha256Hex(content[want[i]]))
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := hashManifest(ctx, files); err == nil {
+		t.Error("hashManifest with a cancelled context: want an error")
+	}
+}
+
+func TestServeManifest(t *testing.T) {
+	if archiveSlots == nil {
+		archiveSlots = semaphore.NewWeighted(1)
+	}
+	root := writeTree(t, map[string]string{"b.txt": "beta", "a.txt": "alpha", "sub/c.txt": "gamma", ".hidden": "x"})
+	serve := func(target, query string, header http.Header) *httptest.ResponseRecorder {
+		diskPath := filepath.Join(root, target)
+		info, err := os.Stat(diskPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx := &requestContext{
+			config:   &shared.Config{},
+			fileData: shared.FileData{Path: root},
+			subpath:  "s",
+			diskPath: diskPath,
+			fileInfo: info,
+		}
+		r := httptest.NewRequest(http.MethodGet, "/s/"+target+"?"+query, nil)
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		serveManifest(w, r, ctx)
+		return w
+	}
+
+	w := serve("", "manifest=sha256", nil)
+	want := sha256Hex("alpha") + "  a.txt\n" + sha256Hex("beta") + "  b.txt\n" + sha256Hex("gamma") + "  sub/c.txt\n"
+	if w.Code != http.StatusOK || w.Body.String() != want {
+		t.Errorf("manifest of the share = %d %q, want %q", w.Code, w.Body.String(), want)
+	}
+	if cd := w.Header().Get("Content-Disposition"); !strings.Contains(cd, manifestName) {
+		t.Errorf("Content-Disposition = %q, want it to name %s", cd, manifestName)
+	}
+
+	// A file's manifest names just the file; a folder's is relative to it.
+	if w := serve("sub/c.txt", "manifest=sha256", nil); w.Body.String() != sha256Hex("gamma")+"  c.txt\n" {
+		t.Errorf("manifest of a file = %q", w.Body.String())
+	}
+	w = serve("sub", "manifest=sha256&format=json", nil)
+	var resp manifestResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("JSON manifest: %v: %s", err, w.Body.String())
+	}
+	if resp.Algorithm != "sha256" || resp.Path != "/sub" || len(resp.Files) != 1 ||
+		resp.Files[0] != (manifestEntry{Path: "c.txt", Size: 5, SHA256: sha256Hex("gamma")}) {
+		t.Errorf("JSON manifest of /sub = %+v", resp)
+	}
+
+	if w := serve("", "manifest=md5", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("manifest=md5: status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestIsManifestRequest(t *testing.T) {
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{"manifest=sha256", true},
+		{"manifest=sha256&format=json", true},
+		{"manifest=sha256&download=zip", false},
+		{"download=zip", false},
+		{"manifest=", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/s/?"+tt.query, nil)
+		if got := isManifestRequest(r); got != tt.want {
+			t.Errorf("isManifestRequest(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/Wirezat/GoLog"
+)
+
+// maxPlaylistEntries caps the tracks of one playlist.
+const maxPlaylistEntries = 10000
+
+// mediaExts are the audio and video types included in playlists.
+var mediaExts = []string{
+	".mp3", ".wav", ".flac", ".aac", ".m4a", ".ogg", ".opus",
+	".mp4", ".m4v", ".avi", ".mov", ".mkv", ".wmv", ".webm",
+}
+
+// playlistTypes maps each ?playlist= format to its Content-Type.
+var playlistTypes = map[string]string{
+	"m3u":  "audio/x-mpegurl",
+	"m3u8": "audio/x-mpegurl; charset=utf-8",
+	"xspf": "application/xspf+xml",
+}
+
+// playlistTrack is one media file of a playlist.
+type playlistTrack struct {
+	Title string
+	URL   string
+}
+
+// xspfPlaylist is the XML form of an XSPF playlist.
+type xspfPlaylist struct {
+	XMLName xml.Name    `xml:"http://xspf.org/ns/0/ playlist"`
+	Version string      `xml:"version,attr"`
+	Title   string      `xml:"title"`
+	Tracks  []xspfTrack `xml:"trackList>track"`
+}
+
+type xspfTrack struct {
+	Location string `xml:"location"`
+	Title    string `xml:"title"`
+}
+
+// isMediaFile reports whether name is an audio or video file.
+func isMediaFile(name string) bool {
+	return slices.Contains(mediaExts, strings.ToLower(filepath.Ext(name)))
+}
+
+// servePlaylist answers ?playlist=<format> for a directory with a playlist
+// of every audio and video file below it, sorted by path. Hidden files and
+// directories are skipped, as in the listing. Track URLs are absolute so
+// that players can open the file on its own; for password-protected shares
+// they carry a share token (see hasAccessToken), valid for shareTokenTTL.
+func servePlaylist(w http.ResponseWriter, r *http.Request, ctx *requestContext) {
+	format := r.URL.Query().Get("playlist")
+	contentType, ok := playlistTypes[format]
+	if !ok {
+		http.Error(w, "Unknown playlist format (use m3u, m3u8 or xspf)", http.StatusBadRequest)
+		return
+	}
+
+	var rels []string
+	_ = filepath.WalkDir(ctx.diskPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if ctxErr := r.Context().Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if p != ctx.diskPath && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || !isMediaFile(d.Name()) {
+			return nil
+		}
+		if len(rels) >= maxPlaylistEntries {
+			return filepath.SkipAll
+		}
+		rels = append(rels, filepath.Join("/", strings.TrimPrefix(p, ctx.fileData.Path)))
+		return nil
+	})
+	slices.Sort(rels)
+
+	var query string
+	if ctx.fileData.Password != "" {
+		token, err := generateShareToken()
+		if err != nil {
+			GoLog.Errorf("playlist token: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		storeShareToken(token, ctx.subpath)
+		query = "?" + accessParam + "=" + token
+	}
+
+	base := requestOrigin(r)
+	tracks := make([]playlistTrack, len(rels))
+	for i, rel := range rels {
+		tracks[i] = playlistTrack{
+			Title: strings.TrimSuffix(path.Base(rel), path.Ext(rel)),
+			URL:   base + shareURL(ctx.subpath, rel) + query,
+		}
+	}
+
+	title := filepath.Base(ctx.diskPath)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, title, format))
+	if format == "xspf" {
+		writeXSPF(w, title, tracks)
+	} else {
+		writeM3U(w, tracks)
+	}
+	GoLog.Infof("playlist /%s: %d track(s) as %s", ctx.subpath, len(tracks), format)
+}
+
+// writeM3U writes an extended M3U playlist. Titles are written as UTF-8;
+// newlines in them would start a new entry, so they are replaced.
+func writeM3U(w http.ResponseWriter, tracks []playlistTrack) {
+	fmt.Fprintln(w, "#EXTM3U")
+	for _, t := range tracks {
+		title := strings.NewReplacer("\r", " ", "\n", " ").Replace(t.Title)
+		fmt.Fprintf(w, "#EXTINF:-1,%s\n%s\n", title, t.URL)
+	}
+}
+
+func writeXSPF(w http.ResponseWriter, title string, tracks []playlistTrack) {
+	pl := xspfPlaylist{Version: "1", Title: title, Tracks: make([]xspfTrack, len(tracks))}
+	for i, t := range tracks {
+		pl.Tracks[i] = xspfTrack{Location: t.URL, Title: t.Title}
+	}
+	fmt.Fprint(w, xml.Header)
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(pl); err != nil {
+		GoLog.Warnf("playlist: %v", err)
+	}
+}
+
+// requestOrigin returns the scheme and host the client used to reach the
+// server, honouring X-Forwarded-Proto and X-Forwarded-Host from a trusted
+// reverse proxy.
+func requestOrigin(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if !isTrustedProxy(remoteIP(r)) {
+		return scheme + "://" + r.Host
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = strings.TrimSpace(strings.Split(proto, ",")[0])
+	}
+	host := r.Host
+	if fwd := r.Header.Get("X-Forwarded-Host"); fwd != "" {
+		host = strings.TrimSpace(strings.Split(fwd, ",")[0])
+	}
+	return scheme + "://" + host
+}
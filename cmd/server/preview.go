@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/Wirezat/GoLog"
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+// Preview kinds, selecting how a file is rendered.
+const (
+	previewText     = "text"
+	previewMarkdown = "markdown"
+	previewCode     = "code"
+	previewCSV      = "csv"
+	previewJSON     = "json"
+)
+
+const (
+	// maxPreviewRows caps the rows of a CSV preview table.
+	maxPreviewRows = 5000
+	// maxLexerCacheEntries bounds lexerCache; it is cleared when full.
+	maxLexerCacheEntries = 4096
+)
+
+// previewData contains the fields rendered by the preview template.
+type previewData struct {
+	Subpath   string
+	Path      string // relative to the share root
+	Name      string
+	Size      int64
+	ParentURL string // empty for single-file shares
+	Kind      string
+	Language  string // lexer name for code and JSON
+
+	Text      string        // previewText
+	Body      template.HTML // previewMarkdown, previewCode, previewJSON
+	Rows      [][]string    // previewCSV; the first row is the header
+	Truncated bool
+	MaxSize   int64
+
+	HighlightCSS template.CSS
+	Brand        shareBranding
+}
+
+var (
+	markdown = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+	codeFormatter = chromahtml.New(chromahtml.WithClasses(true),
+		chromahtml.WithLineNumbers(true), chromahtml.LineNumbersInTable(true))
+
+	// lexerCache memoizes lexers.Match, which tries every lexer's patterns,
+	// by extension (or by name for files without one).
+	lexerCacheMu sync.Mutex
+	lexerCache   = map[string]chroma.Lexer{}
+
+	highlightCSSOnce sync.Once
+	highlightCSS     template.CSS
+
+	previewTemplateOnce sync.Once
+	previewTemplate     *template.Template
+	previewTemplateErr  error
+)
+
+// isViewRequest reports whether r asks for a file's ?view=preview or ?view=raw.
+func isViewRequest(r *http.Request) bool {
+	return r.URL.Query().Get("view") != ""
+}
+
+// previewKindFor picks the preview kind for a file name, or "" if the name
+// alone doesn't say; such files are previewed as text if they look like text.
+func previewKindFor(name string) (string, chroma.Lexer) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".md", ".markdown":
+		return previewMarkdown, nil
+	case ".csv", ".tsv":
+		return previewCSV, nil
+	case ".json":
+		return previewJSON, lexers.Get("json")
+	case ".txt", ".log", ".text":
+		return previewText, nil
+	}
+	if l := matchLexer(name); l != nil {
+		return previewCode, l
+	}
+	return "", nil
+}
+
+// matchLexer returns the lexer for a file name, or nil if there is none.
+func matchLexer(name string) chroma.Lexer {
+	key, probe := strings.ToLower(name), name
+	if ext := filepath.Ext(name); ext != "" {
+		key, probe = strings.ToLower(ext), "file"+ext
+	}
+	lexerCacheMu.Lock()
+	defer lexerCacheMu.Unlock()
+	l, ok := lexerCache[key]
+	if !ok {
+		if len(lexerCache) >= maxLexerCacheEntries {
+			clear(lexerCache)
+		}
+		l = lexers.Match(probe)
+		lexerCache[key] = l
+	}
+	return l
+}
+
+// isPreviewable reports whether the listing should link name to its preview.
+func isPreviewable(name string) bool {
+	kind, _ := previewKindFor(name)
+	return kind != ""
+}
+
+// looksLikeText reports whether data is valid UTF-8 without NUL bytes,
+// ignoring a rune cut off at the end.
+func looksLikeText(data []byte) bool {
+	if bytes.IndexByte(data, 0) >= 0 {
+		return false
+	}
+	for i := 0; i < len(data); {
+		r, size := utf8.DecodeRune(data[i:])
+		if r == utf8.RuneError && size == 1 && len(data)-i >= utf8.UTFMax {
+			return false
+		}
+		i += size
+	}
+	return true
+}
+
+// serveView handles ?view= for files. view=raw serves the file as plain
+// text; view=preview renders it as HTML according to its kind. Only the first
+// Config.PreviewMaxSize bytes are previewed.
+func serveView(w http.ResponseWriter, r *http.Request, ctx *requestContext) {
+	switch r.URL.Query().Get("view") {
+	case "raw":
+		serveRaw(w, r, ctx)
+	case "preview":
+		servePreview(w, r, ctx)
+	default:
+		http.Error(w, "Unknown view (use preview or raw)", http.StatusBadRequest)
+	}
+}
+
+// serveRaw serves a file inline as text/plain, so that e.g. HTML files are
+// shown as source instead of being rendered on the share's origin.
+func serveRaw(w http.ResponseWriter, r *http.Request, ctx *requestContext) {
+	f, err := os.Open(ctx.diskPath)
+	if err != nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, "", ctx.fileInfo.ModTime(), f)
+}
+
+func servePreview(w http.ResponseWriter, r *http.Request, ctx *requestContext) {
+	f, err := os.Open(ctx.diskPath)
+	if err != nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+
+	maxSize := int64(ctx.config.PreviewMaxSize)
+	data, err := io.ReadAll(io.LimitReader(f, maxSize+1))
+	if err != nil {
+		GoLog.Errorf("preview %s: %v", ctx.diskPath, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	truncated := int64(len(data)) > maxSize
+	if truncated {
+		data = data[:maxSize]
+		if i := bytes.LastIndexByte(data, '\n'); i > 0 {
+			data = data[:i+1]
+		}
+	}
+
+	name := ctx.fileInfo.Name()
+	kind, lexer := previewKindFor(name)
+	if kind == "" {
+		kind = previewText
+	}
+	if !looksLikeText(data) {
+		http.Error(w, "No preview available for binary files", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	fd := ctx.fileData
+	pd := previewData{
+		Subpath:   ctx.subpath,
+		Path:      filepath.Join("/", strings.TrimPrefix(ctx.diskPath, fd.Path)),
+		Name:      name,
+		Size:      ctx.fileInfo.Size(),
+		Truncated: truncated,
+		MaxSize:   maxSize,
+		Brand:     brandingFor(ctx.subpath, fd, false),
+	}
+	if ctx.diskPath != fd.Path {
+		parentDir := strings.TrimPrefix(filepath.Dir(ctx.diskPath), fd.Path)
+		pd.ParentURL = path.Join("/", ctx.subpath, parentDir) + "/"
+	}
+	renderPreview(&pd, kind, lexer, data)
+
+	tmpl, err := loadPreviewTemplate(pageLanguage(w, r))
+	if err != nil {
+		GoLog.Errorf("failed to load preview template: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := tmpl.ExecuteTemplate(w, "preview", pd); err != nil {
+		GoLog.Errorf("failed to render preview template: %v", err)
+	}
+}
+
+// renderPreview fills the content fields of pd. Content that doesn't parse
+// as its kind (malformed CSV, invalid or truncated JSON) falls back to text.
+func renderPreview(pd *previewData, kind string, lexer chroma.Lexer, data []byte) {
+	pd.Kind = kind
+	switch kind {
+	case previewMarkdown:
+		// Raw HTML and unsafe link targets are dropped by goldmark's default renderer.
+		var buf bytes.Buffer
+		if err := markdown.Convert(data, &buf); err == nil {
+			pd.Body = template.HTML(buf.String())
+			return
+		}
+	case previewCSV:
+		cr := csv.NewReader(bytes.NewReader(data))
+		cr.LazyQuotes = true
+		cr.FieldsPerRecord = -1
+		if strings.EqualFold(filepath.Ext(pd.Name), ".tsv") {
+			cr.Comma = '\t'
+		}
+		for len(pd.Rows) <= maxPreviewRows {
+			row, err := cr.Read()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				break
+			}
+			pd.Rows = append(pd.Rows, row)
+		}
+		if len(pd.Rows) > maxPreviewRows {
+			pd.Rows, pd.Truncated = pd.Rows[:maxPreviewRows], true
+			return
+		}
+		pd.Rows = nil
+	case previewJSON:
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, data, "", "  "); err == nil {
+			if body, ok := highlight(lexer, buf.String()); ok {
+				pd.Body, pd.Language = body, lexer.Config().Name
+				pd.HighlightCSS = highlightStyles()
+				return
+			}
+		}
+	case previewCode:
+		if body, ok := highlight(lexer, string(data)); ok {
+			pd.Body, pd.Language = body, lexer.Config().Name
+			pd.HighlightCSS = highlightStyles()
+			return
+		}
+	}
+	pd.Kind = previewText
+	pd.Text = string(data)
+}
+
+// highlight renders source as syntax-highlighted HTML with line numbers.
+func highlight(lexer chroma.Lexer, source string) (template.HTML, bool) {
+	it, err := chroma.Coalesce(lexer).Tokenise(nil, source)
+	if err != nil {
+		return "", false
+	}
+	var buf bytes.Buffer
+	if err := codeFormatter.Format(&buf, styles.Get("github"), it); err != nil {
+		return "", false
+	}
+	return template.HTML(buf.String()), true
+}
+
+// highlightStyles returns the CSS classes used by highlight, with a dark
+// variant that applies while the page is in dark mode.
+func highlightStyles() template.CSS {
+	highlightCSSOnce.Do(func() {
+		var light, dark bytes.Buffer
+		_ = codeFormatter.WriteCSS(&light, styles.Get("github"))
+		_ = codeFormatter.WriteCSS(&dark, styles.Get("github-dark"))
+		// Each rule is written as "/* Name */ .selector { … }".
+		darkRules := strings.ReplaceAll(dark.String(), "*/ .", "*/ html.dark .")
+		highlightCSS = template.CSS(light.String() + darkRules)
+	})
+	return highlightCSS
+}
+
+func loadPreviewTemplate(lang string) (*template.Template, error) {
+	previewTemplateOnce.Do(func() {
+		previewTemplate, previewTemplateErr = parseTemplate("preview", previewHtmlPath,
+			template.FuncMap{"formatSize": formatSize})
+	})
+	if previewTemplateErr != nil {
+		return nil, previewTemplateErr
+	}
+	return localize(previewTemplate, lang)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// rangeGrantTTL is how long after a counted download the same client may
+// fetch further ranges of it for free, e.g. while a video plays or a
+// download manager resumes.
+const rangeGrantTTL = 6 * time.Hour
+
+var (
+	rangeGrantsMu sync.Mutex
+	// rangeGrants maps rangeGrantKey to when the grant runs out.
+	rangeGrants = map[string]time.Time{}
+)
+
+// rangeGrantKey ties a grant to what was counted, scope, and to the client.
+// Like davClientID, the address and user agent stand in for a session, as
+// players and download managers don't keep cookies.
+func rangeGrantKey(r *http.Request, scope string) string {
+	return scope + "\x00" + trustedClientIP(r) + "\x00" + r.UserAgent()
+}
+
+// isRangeContinuation reports whether r asks for a range of a download the
+// same client was already counted for under scope, as players and download
+// managers do after the first request. Which bytes a range asks for doesn't
+// matter: a client that wasn't counted pays for its first range, whatever
+// its shape.
+func isRangeContinuation(r *http.Request, scope string) bool {
+	if r.Header.Get("Range") == "" {
+		return false
+	}
+	rangeGrantsMu.Lock()
+	defer rangeGrantsMu.Unlock()
+	expiresAt, ok := rangeGrants[rangeGrantKey(r, scope)]
+	return ok && time.Now().Before(expiresAt)
+}
+
+// grantRangeContinuations lets the client of r, just counted for a download
+// under scope, follow it up with range requests for rangeGrantTTL.
+func grantRangeContinuations(r *http.Request, scope string) {
+	rangeGrantsMu.Lock()
+	rangeGrants[rangeGrantKey(r, scope)] = time.Now().Add(rangeGrantTTL)
+	rangeGrantsMu.Unlock()
+}
+
+func startRangeGrantReaper() {
+	go func() {
+		ticker := time.NewTicker(shareTokenReap)
+		defer ticker.Stop()
+		for range ticker.C {
+			now := time.Now()
+			rangeGrantsMu.Lock()
+			for key, expiresAt := range rangeGrants {
+				if now.After(expiresAt) {
+					delete(rangeGrants, key)
+				}
+			}
+			rangeGrantsMu.Unlock()
+		}
+	}()
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Wirezat/GoLog"
+	"github.com/Wirezat/fileshare/pkg/shared"
+)
+
+// schedulerWake makes the share scheduler look at the config again before
+// its next planned run, e.g. after an activation was changed.
+var schedulerWake = make(chan struct{}, 1)
+
+// wakeScheduler asks the share scheduler to run now.
+func wakeScheduler() {
+	select {
+	case schedulerWake <- struct{}{}:
+	default:
+	}
+}
+
+// startShareScheduler runs the shares' schedules in the background: it marks
+// shares as expired when IsExpired returns true, logs shares going live at
+// their activation time and prunes the use counts of expired signed links.
+// It wakes for the next activation or expiration, and at least every
+// interval to pick up other changes.
+func startShareScheduler(interval time.Duration) {
+	go func() {
+		GoLog.Infof("share scheduler started (interval: %s)", interval)
+		// pending holds the shares last seen waiting for their activation.
+		pending := map[string]bool{}
+		for {
+			next := time.Now().Add(interval)
+			config, err := shared.LoadConfig()
+			if err != nil {
+				GoLog.Errorf("failed to load config: %v", err)
+			} else {
+				next = runSchedule(config, pending, next)
+			}
+
+			timer := time.NewTimer(time.Until(next))
+			select {
+			case <-timer.C:
+			case <-schedulerWake:
+				timer.Stop()
+			}
+		}
+	}()
+}
+
+// runSchedule applies the schedules of config's shares and returns when it
+// should run again, at the latest by next.
+func runSchedule(config *shared.Config, pending map[string]bool, next time.Time) time.Time {
+	soonest := func(unix int64) {
+		if t := time.Unix(unix, 0); t.Before(next) {
+			next = t
+		}
+	}
+
+	changed := false
+	for subpath, fd := range config.Files {
+		switch {
+		case shared.IsPending(fd):
+			if !pending[subpath] {
+				GoLog.Infof("share scheduled: %s goes live at %s", subpath, time.Unix(fd.Activation, 0).Format(time.RFC3339))
+				pending[subpath] = true
+			}
+			soonest(fd.Activation)
+		case pending[subpath]:
+			delete(pending, subpath)
+			if !shared.IsExpired(fd) {
+				GoLog.Infof("share activated: %s", subpath)
+			}
+		}
+
+		if !fd.Expired && shared.IsExpired(fd) {
+			fd.Expired = true
+			config.Files[subpath] = fd
+			changed = true
+			GoLog.Infof("file expired: %s", subpath)
+		} else if !fd.Expired && fd.Expiration != 0 {
+			// IsExpired turns true once the second of Expiration has passed.
+			soonest(fd.Expiration + 1)
+		}
+	}
+	for subpath := range pending {
+		if _, exists := config.Files[subpath]; !exists {
+			delete(pending, subpath)
+		}
+	}
+
+	if changed {
+		if err := shared.SaveConfig(config); err != nil {
+			GoLog.Errorf("failed to save config after expiration update: %v", err)
+		}
+	}
+	if err := pruneLinkUses(); err != nil {
+		GoLog.Errorf("failed to prune signed link uses: %v", err)
+	}
+	return next
+}
+
+// liveOrErr tells visitors of a share that is closed when it opens: before
+// its activation, or outside its availability windows. Browsers get a
+// countdown, other clients a plain 403. Both are told when to retry. It
+// returns true if the share is open.
+func liveOrErr(w http.ResponseWriter, r *http.Request, subpath string, fd shared.FileData) bool {
+	now := time.Now()
+	opens := now
+	if shared.IsPending(fd) {
+		opens = time.Unix(fd.Activation, 0)
+	}
+	closed := false
+	if fd.Availability != "" {
+		a, err := shared.ParseAvailability(fd.Availability, fd.TimeZone)
+		if err != nil {
+			GoLog.Errorf("availability of share %s: %v", subpath, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return false
+		}
+		if next := a.NextOpen(opens); !next.Equal(opens) {
+			opens, closed = next, true
+		}
+	}
+	if !opens.After(now) {
+		return true
+	}
+
+	left := int64(opens.Sub(now).Seconds()) + 1
+	w.Header().Set("Retry-After", strconv.FormatInt(left, 10))
+	// Nothing may keep the countdown once the share is open.
+	w.Header().Set("Cache-Control", "no-store")
+	if !wantsGatePage(r) {
+		http.Error(w, "Forbidden: this share opens at "+opens.UTC().Format(time.RFC3339), http.StatusForbidden)
+		return false
+	}
+	serveGatePage(w, r, gateData{
+		Subpath: subpath,
+		Brand:   brandingFor(subpath, fd, false),
+		Opens:   opens,
+		OpensIn: left,
+		Closed:  closed,
+	})
+	return false
+}
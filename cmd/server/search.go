@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Wirezat/GoLog"
+	"github.com/Wirezat/fileshare/pkg/shared"
+)
+
+// indexRecheckInterval limits how often an in-memory index is checked for
+// changes. Within this window searches use the index as-is.
+const indexRecheckInterval = 10 * time.Second
+
+// searchResult holds the matches of a recursive search.
+// Truncated is set when the result limit or the timeout was hit.
+type searchResult struct {
+	Files     []shared.FileInfo
+	Truncated bool
+}
+
+// nameMatcher reports whether a file name matches a search query.
+// Queries containing glob metacharacters (* ? [) are matched with path.Match
+// against the whole name; anything else is a substring match. Both are case-insensitive.
+func nameMatcher(query string) func(name string) bool {
+	q := strings.ToLower(query)
+	if strings.ContainsAny(q, "*?[") {
+		return func(name string) bool {
+			ok, _ := path.Match(q, strings.ToLower(name))
+			return ok
+		}
+	}
+	return func(name string) bool {
+		return strings.Contains(strings.ToLower(name), q)
+	}
+}
+
+// searchShare finds entries below dirPath whose names match query.
+// basePath is the share root; result paths are relative to it, result names
+// relative to dirPath. Hidden files and directories are skipped, matching the
+// listing. Symlinked directories are not followed.
+func searchShare(ctx context.Context, dirPath, basePath, query string, limit int, useIndex bool) searchResult {
+	match := nameMatcher(query)
+	var res searchResult
+
+	add := func(rel string) bool {
+		if len(res.Files) >= limit {
+			res.Truncated = true
+			return false
+		}
+		full := filepath.Join(basePath, rel)
+		info, err := os.Stat(full)
+		if err != nil {
+			if info, err = os.Lstat(full); err != nil {
+				return true
+			}
+		}
+		name, _ := filepath.Rel(dirPath, full)
+		fi := fileInfoFor(filepath.Dir(full), filepath.Dir(rel), dirEntry{name: filepath.Base(full), info: info})
+		fi.Name = name
+		res.Files = append(res.Files, fi)
+		return true
+	}
+
+	if useIndex {
+		entries, err := indexFor(basePath).snapshot(ctx)
+		if err != nil {
+			res.Truncated = true
+			return res
+		}
+		prefix := filepath.Join("/", strings.TrimPrefix(dirPath, basePath))
+		for _, rel := range entries {
+			if ctx.Err() != nil {
+				res.Truncated = true
+				break
+			}
+			if rel == prefix || !isBelow(rel, prefix) || !match(path.Base(rel)) {
+				continue
+			}
+			if !add(rel) {
+				break
+			}
+		}
+		return res
+	}
+
+	err := filepath.WalkDir(dirPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if p == dirPath {
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if match(d.Name()) && !add(filepath.Join("/", strings.TrimPrefix(p, basePath))) {
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	if err != nil {
+		res.Truncated = true
+	}
+	return res
+}
+
+// isBelow reports whether rel lies inside the directory prefix.
+func isBelow(rel, prefix string) bool {
+	return prefix == "/" || strings.HasPrefix(rel, prefix+"/")
+}
+
+// ── In-memory index ─────────────────────────────────────────────────────────
+
+// shareIndex caches the visible tree of one share root.
+// It is rebuilt when any indexed directory's mtime changes, which happens
+// whenever an entry inside it is created, removed or renamed.
+type shareIndex struct {
+	root string
+
+	mu       sync.Mutex
+	entries  []string             // paths relative to the share root, with leading slash
+	dirs     map[string]time.Time // absolute dir path → mtime at build time
+	checked  time.Time
+	building chan struct{} // non-nil while a rebuild is running
+}
+
+var (
+	searchIndexesMu sync.Mutex
+	searchIndexes   = map[string]*shareIndex{}
+)
+
+// indexFor returns the index for a share root, creating it on first use.
+func indexFor(root string) *shareIndex {
+	searchIndexesMu.Lock()
+	defer searchIndexesMu.Unlock()
+	idx, ok := searchIndexes[root]
+	if !ok {
+		idx = &shareIndex{root: root}
+		searchIndexes[root] = idx
+	}
+	return idx
+}
+
+// snapshot returns the current entries, rebuilding the index first if it is
+// missing or stale. The rebuild runs in the background so a request timeout
+// only abandons the wait, not the build itself.
+func (idx *shareIndex) snapshot(ctx context.Context) ([]string, error) {
+	idx.mu.Lock()
+	if idx.building == nil && (idx.entries == nil || idx.staleLocked()) {
+		done := make(chan struct{})
+		idx.building = done
+		go idx.rebuild(done)
+	}
+	building := idx.building
+	if building == nil {
+		entries := idx.entries
+		idx.mu.Unlock()
+		return entries, nil
+	}
+	idx.mu.Unlock()
+
+	select {
+	case <-building:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return idx.entries, nil
+}
+
+// staleLocked reports whether any indexed directory changed since the last
+// build. Checks are rate-limited by indexRecheckInterval. Caller holds idx.mu.
+func (idx *shareIndex) staleLocked() bool {
+	if time.Since(idx.checked) < indexRecheckInterval {
+		return false
+	}
+	idx.checked = time.Now()
+	for dir, mtime := range idx.dirs {
+		info, err := os.Stat(dir)
+		if err != nil || !info.ModTime().Equal(mtime) {
+			return true
+		}
+	}
+	return false
+}
+
+func (idx *shareIndex) rebuild(done chan struct{}) {
+	start := time.Now()
+	var entries []string
+	dirs := make(map[string]time.Time)
+
+	_ = filepath.WalkDir(idx.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if p != idx.root && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				dirs[p] = info.ModTime()
+			}
+		}
+		if p != idx.root {
+			entries = append(entries, filepath.Join("/", strings.TrimPrefix(p, idx.root)))
+		}
+		return nil
+	})
+
+	if entries == nil {
+		entries = []string{} // non-nil marks the index as built
+	}
+
+	idx.mu.Lock()
+	idx.entries = entries
+	idx.dirs = dirs
+	idx.checked = time.Now()
+	idx.building = nil
+	idx.mu.Unlock()
+	close(done)
+	GoLog.Infof("search index rebuilt: %s (%d entries, %s)", idx.root, len(entries), time.Since(start).Round(time.Millisecond))
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestNameMatcher(t *testing.T) {
+	tests := []struct {
+		query string
+		name  string
+		want  bool
+	}{
+		{"report", "Annual Report.pdf", true},
+		{"REPORT", "annual report.pdf", true},
+		{"port.p", "report.pdf", true},
+		{"summary", "report.pdf", false},
+		{"*.pdf", "report.pdf", true},
+		{"*.PDF", "Report.pdf", true},
+		// Globs match the whole name, not part of it.
+		{"*.pdf", "report.pdf.bak", false},
+		{"rep*", "report.pdf", true},
+		{"rep*", "my report.pdf", false},
+		{"img_?.jpg", "IMG_1.jpg", true},
+		{"img_?.jpg", "img_10.jpg", false},
+		{"[ab]*.txt", "b-side.txt", true},
+		{"[ab]*.txt", "c-side.txt", false},
+		// A malformed pattern matches nothing rather than everything.
+		{"[abc", "abc", false},
+	}
+	for _, tt := range tests {
+		if got := nameMatcher(tt.query)(tt.name); got != tt.want {
+			t.Errorf("nameMatcher(%q)(%q) = %v, want %v", tt.query, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSearchShare(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"report.pdf":             "1",
+		"notes.txt":              "2",
+		"2025/report-q1.pdf":     "3",
+		"2025/q2/report-q2.pdf":  "4",
+		"2025/q2/photo.jpg":      "5",
+		"reports/index.txt":      "6",
+		".secret/report.pdf":     "7",
+		"2025/.draft-report.pdf": "8",
+	})
+
+	tests := []struct {
+		dir   string // relative to root
+		query string
+		limit int
+		want  []string // result paths
+		trunc bool
+	}{
+		{"", "report", 100, []string{"/2025/q2/report-q2.pdf", "/2025/report-q1.pdf", "/report.pdf", "/reports"}, false},
+		{"", "*.pdf", 100, []string{"/2025/q2/report-q2.pdf", "/2025/report-q1.pdf", "/report.pdf"}, false},
+		{"", "*.txt", 100, []string{"/notes.txt", "/reports/index.txt"}, false},
+		{"2025", "report", 100, []string{"/2025/q2/report-q2.pdf", "/2025/report-q1.pdf"}, false},
+		{"2025", "2025", 100, nil, false},
+		{"", "nothing", 100, nil, false},
+		{"", "report", 2, nil, true},
+	}
+	for _, useIndex := range []bool{false, true} {
+		for _, tt := range tests {
+			res := searchShare(context.Background(), filepath.Join(root, tt.dir), root, tt.query, tt.limit, useIndex)
+			var got []string
+			for _, f := range res.Files {
+				got = append(got, filepath.ToSlash(f.Path))
+			}
+			slices.Sort(got)
+			if tt.trunc {
+				if !res.Truncated || len(got) != tt.limit {
+					t.Errorf("index %v, %q in /%s with limit %d = %v, truncated %v; want %d results, truncated",
+						useIndex, tt.query, tt.dir, tt.limit, got, res.Truncated, tt.limit)
+				}
+				continue
+			}
+			if !slices.Equal(got, tt.want) || res.Truncated {
+				t.Errorf("index %v, %q in /%s = %v, truncated %v; want %v", useIndex, tt.query, tt.dir, got, res.Truncated, tt.want)
+			}
+		}
+	}
+
+	// Names are relative to the folder searched.
+	res := searchShare(context.Background(), filepath.Join(root, "2025"), root, "q2", 100, false)
+	var names []string
+	for _, f := range res.Files {
+		names = append(names, filepath.ToSlash(f.Name))
+	}
+	slices.Sort(names)
+	if want := []string{"q2", "q2/report-q2.pdf"}; !slices.Equal(names, want) {
+		t.Errorf("names of %q in /2025 = %v, want %v", "q2", names, want)
+	}
+}
+
+func TestSearchShareCancelled(t *testing.T) {
+	root := writeTree(t, map[string]string{"a.txt": "a"})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, useIndex := range []bool{false, true} {
+		if res := searchShare(ctx, root, root, "a", 100, useIndex); !res.Truncated {
+			t.Errorf("index %v: search for a client that left = %v, want truncated", useIndex, res.Files)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/Wirezat/GoLog"
+)
+
+const (
+	maxSelectionPaths = 10000
+	maxSelectionBody  = 4 << 20 // 4 MB
+)
+
+// isSelectionRequest reports whether r asks for an archive of selected entries:
+// POST /{subpath}/{dir}?download=<format> with the paths in the body.
+func isSelectionRequest(r *http.Request) bool {
+	return r.Method == http.MethodPost && r.URL.Query().Get("download") != ""
+}
+
+// selectionRoots reads the entries selected in a listing from the body of r.
+// The body is either a form with repeated "paths" fields or a JSON object
+// {"paths": [...]}. Paths are relative to the share root, as in listings,
+// and must lie inside the requested directory. Writes an HTTP error and
+// returns false if the selection is invalid.
+func selectionRoots(w http.ResponseWriter, r *http.Request, ctx *requestContext) ([]string, bool) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxSelectionBody)
+	paths, err := readSelection(r)
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return nil, false
+	}
+	if len(paths) == 0 {
+		http.Error(w, "No paths selected", http.StatusBadRequest)
+		return nil, false
+	}
+	if len(paths) > maxSelectionPaths {
+		http.Error(w, fmt.Sprintf("Too many paths (max %d)", maxSelectionPaths), http.StatusRequestEntityTooLarge)
+		return nil, false
+	}
+
+	roots, err := resolveSelection(ctx.fileData.Path, ctx.diskPath, paths)
+	if err != nil {
+		GoLog.Warnf("archive selection /%s: %v", ctx.subpath, err)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil, false
+	}
+	if len(roots) == 0 {
+		http.NotFound(w, r)
+		return nil, false
+	}
+	return roots, true
+}
+
+// readSelection extracts the selected paths from a form or JSON body.
+func readSelection(r *http.Request) ([]string, error) {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var body struct {
+			Paths []string `json:"paths"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			return nil, err
+		}
+		return body.Paths, nil
+	}
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	return r.PostForm["paths"], nil
+}
+
+// resolveSelection maps share-relative paths to absolute paths below dirPath.
+// Any path escaping dirPath is an error. Missing entries are dropped, and so
+// are hidden ones, which prepareRequest doesn't serve either. Duplicates and
+// entries already covered by a selected parent directory are dropped too, so
+// no file is added to the archive twice.
+func resolveSelection(basePath, dirPath string, paths []string) ([]string, error) {
+	abs := make([]string, 0, len(paths))
+	for _, p := range paths {
+		rel := filepath.Join("/", p)
+		full := filepath.Join(basePath, rel)
+		if full != dirPath && !strings.HasPrefix(full, dirPath+"/") {
+			return nil, fmt.Errorf("path outside of %s: %q", dirPath, p)
+		}
+		if isHiddenPath(filepath.ToSlash(rel)) {
+			continue
+		}
+		if _, err := os.Lstat(full); err != nil {
+			continue
+		}
+		abs = append(abs, full)
+	}
+
+	// Shorter paths first, so parents are kept before their children are checked.
+	slices.SortFunc(abs, func(a, b string) int { return len(a) - len(b) })
+	kept := make(map[string]bool, len(abs))
+	roots := abs[:0]
+	for _, p := range abs {
+		if coveredBy(kept, p, dirPath) {
+			continue
+		}
+		kept[p] = true
+		roots = append(roots, p)
+	}
+	slices.Sort(roots)
+	return roots, nil
+}
+
+// coveredBy reports whether p or one of its parents up to dirPath is in kept.
+func coveredBy(kept map[string]bool, p, dirPath string) bool {
+	for {
+		if kept[p] {
+			return true
+		}
+		if p == dirPath || len(p) < len(dirPath) {
+			return false
+		}
+		p = filepath.Dir(p)
+	}
+}
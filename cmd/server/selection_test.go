@@ -0,0 +1,60 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestResolveSelection(t *testing.T) {
+	base := writeTree(t, map[string]string{
+		"docs/a.txt":        "a",
+		"docs/b.txt":        "b",
+		"docs/sub/c.txt":    "c",
+		"docs/.env":         "secret",
+		"docs/.git/config":  "x",
+		"docs/sub/.d.part":  "partial",
+		"other/e.txt":       "e",
+		"docs-archive/f.gz": "f",
+	})
+	dir := filepath.Join(base, "docs")
+	in := func(rels ...string) []string {
+		var abs []string
+		for _, rel := range rels {
+			abs = append(abs, filepath.Join(base, rel))
+		}
+		return abs
+	}
+
+	tests := []struct {
+		name    string
+		paths   []string
+		want    []string
+		wantErr bool
+	}{
+		{"files", []string{"/docs/b.txt", "/docs/a.txt"}, in("docs/a.txt", "docs/b.txt"), false},
+		{"relative paths", []string{"docs/a.txt"}, in("docs/a.txt"), false},
+		{"duplicates", []string{"/docs/a.txt", "/docs/a.txt", "/docs/./a.txt"}, in("docs/a.txt"), false},
+		{"covered by a parent", []string{"/docs/sub/c.txt", "/docs/sub"}, in("docs/sub"), false},
+		{"the folder itself", []string{"/docs", "/docs/a.txt"}, in("docs"), false},
+		{"missing", []string{"/docs/nope.txt", "/docs/a.txt"}, in("docs/a.txt"), false},
+		{"hidden file", []string{"/docs/.env", "/docs/a.txt"}, in("docs/a.txt"), false},
+		{"hidden folder", []string{"/docs/.git"}, nil, false},
+		{"inside a hidden folder", []string{"/docs/.git/config"}, nil, false},
+		{"part file", []string{"/docs/sub/.d.part"}, nil, false},
+		{"sibling folder", []string{"/other/e.txt"}, nil, true},
+		{"shared prefix", []string{"/docs-archive/f.gz"}, nil, true},
+		{"traversal", []string{"/docs/../other/e.txt"}, nil, true},
+		{"traversal past the root", []string{"../../etc/passwd"}, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := resolveSelection(base, dir, tt.paths)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: resolveSelection(%q) error = %v, want error %v", tt.name, tt.paths, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !slices.Equal(got, tt.want) {
+			t.Errorf("%s: resolveSelection(%q) = %q, want %q", tt.name, tt.paths, got, tt.want)
+		}
+	}
+}
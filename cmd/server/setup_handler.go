@@ -18,7 +18,7 @@ func handleSetupUI(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/admin", http.StatusFound)
 		return
 	}
-	http.ServeFile(w, r, setupHtmlPath)
+	http.ServeFileFS(w, r, webFS, setupHtmlPath)
 }
 
 // POST /setup/api/init — set initial admin username and password.
@@ -2,15 +2,43 @@ package main
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/Wirezat/GoLog"
+	"github.com/Wirezat/fileshare/pkg/shared"
 )
 
 const (
 	shareTokenTTL  = 24 * time.Hour
 	shareTokenReap = 5 * time.Minute
+
+	// accessParam is the query parameter carrying a share token in URLs
+	// handed to clients without cookies, such as media players.
+	accessParam = "access"
+
+	// onceParam is the query parameter carrying a one-time token, which
+	// unlocks a share for a single download.
+	onceParam       = "once"
+	oneTimeTokenTTL = 15 * time.Minute
+
+	// basicAuthTTL is how long a share password checked via Basic auth is
+	// trusted without running bcrypt again. Clients send it with every
+	// request, and file managers send many.
+	basicAuthTTL = time.Hour
+
+	// After basicAuthFreeFailures wrong Basic auth passwords, an address
+	// has to wait before its next one is checked: basicAuthBackoff, doubled
+	// with each further failure up to basicAuthMaxBackoff. Failures are
+	// forgotten once an address has been quiet for basicAuthForget.
+	basicAuthFreeFailures = 5
+	basicAuthBackoff      = time.Second
+	basicAuthMaxBackoff   = 5 * time.Minute
+	basicAuthForget       = 15 * time.Minute
 )
 
 type tokenEntry struct {
@@ -18,9 +46,35 @@ type tokenEntry struct {
 	expiresAt time.Time
 }
 
+// authFailures counts an address's wrong Basic auth passwords in a row.
+type authFailures struct {
+	count int
+	last  time.Time
+}
+
+// retryAt returns when the address's next password may be checked.
+func (f authFailures) retryAt() time.Time {
+	if f.count < basicAuthFreeFailures {
+		return f.last
+	}
+	wait := basicAuthMaxBackoff
+	if shift := f.count - basicAuthFreeFailures; shift < 20 {
+		wait = min(basicAuthBackoff<<shift, basicAuthMaxBackoff)
+	}
+	return f.last.Add(wait)
+}
+
 var (
 	shareTokensMu sync.RWMutex
 	shareTokens   = map[string]tokenEntry{}
+	// oneTimeTokens are removed by the request that uses them.
+	oneTimeTokens = map[string]tokenEntry{}
+	// basicAuthChecks maps checked Basic auth credentials, see basicAuthKey,
+	// to when they expire.
+	basicAuthChecks = map[string]time.Time{}
+	// basicAuthFailures maps client addresses, see trustedClientIP, to
+	// their recent wrong passwords.
+	basicAuthFailures = map[string]authFailures{}
 )
 
 func generateShareToken() (string, error) {
@@ -48,6 +102,14 @@ func validateShareToken(token, subpath string) bool {
 	return ok && entry.subpath == subpath && time.Now().Before(entry.expiresAt)
 }
 
+func storeOneTimeToken(token, subpath string) time.Time {
+	expiresAt := time.Now().Add(oneTimeTokenTTL)
+	shareTokensMu.Lock()
+	oneTimeTokens[token] = tokenEntry{subpath: subpath, expiresAt: expiresAt}
+	shareTokensMu.Unlock()
+	return expiresAt
+}
+
 func startTokenReaper() {
 	go func() {
 		ticker := time.NewTicker(shareTokenReap)
@@ -60,6 +122,21 @@ func startTokenReaper() {
 					delete(shareTokens, token)
 				}
 			}
+			for token, entry := range oneTimeTokens {
+				if now.After(entry.expiresAt) {
+					delete(oneTimeTokens, token)
+				}
+			}
+			for key, expiresAt := range basicAuthChecks {
+				if now.After(expiresAt) {
+					delete(basicAuthChecks, key)
+				}
+			}
+			for ip, f := range basicAuthFailures {
+				if now.Sub(f.last) > basicAuthForget {
+					delete(basicAuthFailures, ip)
+				}
+			}
 			shareTokensMu.Unlock()
 		}
 	}()
@@ -73,6 +150,100 @@ func hasPasswordCookie(r *http.Request, subpath string) bool {
 	return validateShareToken(cookie.Value, subpath)
 }
 
+// hasAccessToken reports whether the URL carries a valid share token for subpath.
+func hasAccessToken(r *http.Request, subpath string) bool {
+	token := r.URL.Query().Get(accessParam)
+	return token != "" && validateShareToken(token, subpath)
+}
+
+// hasOneTimeToken reports whether the URL carries a valid one-time token for
+// subpath. GET requests use the token up; HEAD requests, which download
+// managers send first, only check it.
+func hasOneTimeToken(r *http.Request, subpath string) bool {
+	token := r.URL.Query().Get(onceParam)
+	if token == "" {
+		return false
+	}
+	shareTokensMu.Lock()
+	defer shareTokensMu.Unlock()
+	entry, ok := oneTimeTokens[token]
+	if !ok || entry.subpath != subpath || time.Now().After(entry.expiresAt) {
+		return false
+	}
+	if r.Method != http.MethodHead {
+		delete(oneTimeTokens, token)
+	}
+	return true
+}
+
+// hasBasicAuth reports whether r carries the share's password in an
+// Authorization: Basic header. The user name is ignored. Passwords from an
+// address that keeps sending wrong ones are refused unchecked for a while
+// (see basicAuthFreeFailures), so guessing can't keep bcrypt busy.
+func hasBasicAuth(r *http.Request, subpath string, fd shared.FileData) bool {
+	_, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	key := basicAuthKey(subpath, fd.Password, password)
+	ip := trustedClientIP(r)
+	shareTokensMu.RLock()
+	expiresAt, checked := basicAuthChecks[key]
+	failures := basicAuthFailures[ip]
+	shareTokensMu.RUnlock()
+	if checked && time.Now().Before(expiresAt) {
+		return true
+	}
+	if time.Now().Before(failures.retryAt()) {
+		GoLog.Warnf("refused Basic auth for share /%s from %s after %d failures", subpath, ip, failures.count)
+		return false
+	}
+
+	if !shared.CheckPassword(password, fd.Password) {
+		shareTokensMu.Lock()
+		f := basicAuthFailures[ip]
+		f.count++
+		f.last = time.Now()
+		basicAuthFailures[ip] = f
+		shareTokensMu.Unlock()
+		GoLog.Warnf("failed Basic auth for share /%s from %s", subpath, ip)
+		return false
+	}
+	shareTokensMu.Lock()
+	basicAuthChecks[key] = time.Now().Add(basicAuthTTL)
+	delete(basicAuthFailures, ip)
+	shareTokensMu.Unlock()
+	return true
+}
+
+// basicAuthKey identifies a checked password without keeping it in memory.
+// The stored hash is part of it, so changing the password invalidates it.
+func basicAuthKey(subpath, hash, password string) string {
+	sum := sha256.Sum256([]byte(hash + "\x00" + password))
+	return subpath + "\x00" + hex.EncodeToString(sum[:])
+}
+
+// isShareUnlocked reports whether r may access a password-protected share:
+// through the cookie from handleUnlock, an access or one-time token in the
+// URL, or the password via Basic auth.
+func isShareUnlocked(r *http.Request, subpath string, fd shared.FileData) bool {
+	return hasPasswordCookie(r, subpath) || hasAccessToken(r, subpath) ||
+		hasBasicAuth(r, subpath, fd) || hasOneTimeToken(r, subpath)
+}
+
+// wantsGatePage reports whether r comes from a browser, which gets the HTML
+// password gate. Other clients get a Basic auth challenge.
+func wantsGatePage(r *http.Request) bool {
+	return !wantsJSON(r) && strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// requestBasicAuth answers a request for a locked share with 401 and a
+// Basic auth challenge.
+func requestBasicAuth(w http.ResponseWriter, subpath string) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="/`+subpath+`", charset="UTF-8"`)
+	http.Error(w, "Unauthorized: password required", http.StatusUnauthorized)
+}
+
 func setPasswordCookie(w http.ResponseWriter, subpath, token string) {
 	http.SetCookie(w, &http.Cookie{
 		Name:     "share_pw_" + subpath,
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Wirezat/fileshare/pkg/shared"
+)
+
+func TestHasBasicAuth(t *testing.T) {
+	hash, err := shared.HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd := shared.FileData{Password: hash}
+	t.Cleanup(func() {
+		shareTokensMu.Lock()
+		delete(basicAuthFailures, "192.0.2.1")
+		shareTokensMu.Unlock()
+	})
+	request := func(ip, user, password string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/basic/", nil)
+		r.RemoteAddr = ip + ":1234"
+		if user != "" || password != "" {
+			r.SetBasicAuth(user, password)
+		}
+		return r
+	}
+
+	tests := []struct {
+		name     string
+		user     string
+		password string
+		want     bool
+	}{
+		{"password", "", "secret", true},
+		{"any user name", "alice", "secret", true},
+		{"cached", "", "secret", true},
+		{"wrong password", "", "guess", false},
+		{"no header", "", "", false},
+	}
+	for _, tt := range tests {
+		if got := hasBasicAuth(request("192.0.2.1", tt.user, tt.password), "basic", fd); got != tt.want {
+			t.Errorf("%s: hasBasicAuth = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	// Checks are cached per share; another share with the same password
+	// checks it again.
+	if !hasBasicAuth(request("192.0.2.1", "", "secret"), "other", fd) {
+		t.Error("hasBasicAuth for another share with the same password = false, want true")
+	}
+
+	// A new password hash drops what was cached for the old one.
+	newHash, _ := shared.HashPassword("changed")
+	if hasBasicAuth(request("192.0.2.1", "", "secret"), "basic", shared.FileData{Password: newHash}) {
+		t.Error("hasBasicAuth with the old password after a change = true, want false")
+	}
+}
+
+func TestHasBasicAuthBackoff(t *testing.T) {
+	hash, err := shared.HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd := shared.FileData{Password: hash}
+	const ip = "192.0.2.2"
+	t.Cleanup(func() {
+		shareTokensMu.Lock()
+		delete(basicAuthFailures, ip)
+		shareTokensMu.Unlock()
+	})
+	try := func(password string) bool {
+		r := httptest.NewRequest(http.MethodGet, "/backoff/", nil)
+		r.RemoteAddr = ip + ":1234"
+		r.SetBasicAuth("", password)
+		return hasBasicAuth(r, "backoff", fd)
+	}
+
+	for range basicAuthFreeFailures {
+		try("guess")
+	}
+	shareTokensMu.RLock()
+	failures := basicAuthFailures[ip]
+	shareTokensMu.RUnlock()
+	if failures.count != basicAuthFreeFailures {
+		t.Fatalf("%d failures recorded, want %d", failures.count, basicAuthFreeFailures)
+	}
+	// Even the right password waits until the backoff is over.
+	if try("secret") {
+		t.Error("hasBasicAuth during the backoff = true, want false")
+	}
+
+	shareTokensMu.Lock()
+	basicAuthFailures[ip] = authFailures{count: failures.count, last: time.Now().Add(-basicAuthBackoff)}
+	shareTokensMu.Unlock()
+	if !try("secret") {
+		t.Error("hasBasicAuth after the backoff = false, want true")
+	}
+	shareTokensMu.RLock()
+	_, still := basicAuthFailures[ip]
+	shareTokensMu.RUnlock()
+	if still {
+		t.Error("failures kept after the right password")
+	}
+}
+
+func TestAuthFailuresRetryAt(t *testing.T) {
+	last := time.Date(2026, time.October, 19, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		count int
+		want  time.Duration
+	}{
+		{0, 0},
+		{basicAuthFreeFailures - 1, 0},
+		{basicAuthFreeFailures, basicAuthBackoff},
+		{basicAuthFreeFailures + 1, 2 * basicAuthBackoff},
+		{basicAuthFreeFailures + 3, 8 * basicAuthBackoff},
+		{basicAuthFreeFailures + 20, basicAuthMaxBackoff},
+		{basicAuthFreeFailures + 100, basicAuthMaxBackoff},
+	}
+	for _, tt := range tests {
+		f := authFailures{count: tt.count, last: last}
+		if got := f.retryAt().Sub(last); got != tt.want {
+			t.Errorf("retryAt after %d failures = +%s, want +%s", tt.count, got, tt.want)
+		}
+	}
+}
+
+func TestHasOneTimeToken(t *testing.T) {
+	request := func(method, token string) *http.Request {
+		return httptest.NewRequest(method, "/once/file.txt?"+onceParam+"="+token, nil)
+	}
+
+	const token = "0123456789abcdef0123456789abcdef"
+	storeOneTimeToken(token, "once")
+	tests := []struct {
+		name string
+		r    *http.Request
+		sub  string
+		want bool
+	}{
+		{"other share", request(http.MethodGet, token), "other", false},
+		{"unknown token", request(http.MethodGet, "feedface"), "once", false},
+		{"no token", httptest.NewRequest(http.MethodGet, "/once/file.txt", nil), "once", false},
+		{"head only checks", request(http.MethodHead, token), "once", true},
+		{"get", request(http.MethodGet, token), "once", true},
+		{"used up", request(http.MethodGet, token), "once", false},
+	}
+	for _, tt := range tests {
+		if got := hasOneTimeToken(tt.r, tt.sub); got != tt.want {
+			t.Errorf("%s: hasOneTimeToken = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	const expired = "fedcba9876543210fedcba9876543210"
+	shareTokensMu.Lock()
+	oneTimeTokens[expired] = tokenEntry{subpath: "once", expiresAt: time.Now().Add(-time.Second)}
+	shareTokensMu.Unlock()
+	t.Cleanup(func() {
+		shareTokensMu.Lock()
+		delete(oneTimeTokens, expired)
+		shareTokensMu.Unlock()
+	})
+	if hasOneTimeToken(request(http.MethodGet, expired), "once") {
+		t.Error("hasOneTimeToken with an expired token = true, want false")
+	}
+}
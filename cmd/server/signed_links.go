@@ -0,0 +1,199 @@
+package main
+
+import (
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Wirezat/GoLog"
+	"github.com/Wirezat/fileshare/pkg/shared"
+)
+
+// isSignedLinkRequest reports whether r carries a signed link.
+func isSignedLinkRequest(r *http.Request) bool {
+	return r.URL.Query().Has(shared.LinkSigParam)
+}
+
+// signedLinkOrErr checks the signed link r carries for relPath in the share
+// at subpath, and counts downloads against the link's use limit. A valid
+// link stands in for the share's password. It writes an error and returns
+// false if the link is forged, expired, used up or bound to another address.
+func signedLinkOrErr(w http.ResponseWriter, r *http.Request, config *shared.Config, subpath, relPath string) bool {
+	q := r.URL.Query()
+	expires, err := strconv.ParseInt(q.Get(shared.LinkExpiresParam), 10, 64)
+	maxUses := 0
+	if v := q.Get(shared.LinkUsesParam); v != "" && err == nil {
+		maxUses, err = strconv.Atoi(v)
+	}
+	link := shared.SignedLink{
+		Subpath: subpath,
+		Path:    relPath,
+		Expires: expires,
+		MaxUses: maxUses,
+		IP:      q.Get(shared.LinkIPParam),
+	}
+	sig := q.Get(shared.LinkSigParam)
+	if err != nil || !link.Verify(config.LinkSecret, sig) {
+		GoLog.Warnf("invalid signed link for /%s%s from %s", subpath, relPath, clientIP(r))
+		http.Error(w, "Forbidden: invalid link", http.StatusForbidden)
+		return false
+	}
+
+	if time.Now().Unix() >= link.Expires {
+		http.Error(w, "Gone: this link has expired", http.StatusGone)
+		return false
+	}
+
+	if ip := trustedClientIP(r); link.IP != "" && !net.ParseIP(link.IP).Equal(net.ParseIP(ip)) {
+		GoLog.Warnf("signed link for /%s%s used from %s, bound to %s", subpath, relPath, ip, link.IP)
+		http.Error(w, "Forbidden: this link is bound to another address", http.StatusForbidden)
+		return false
+	}
+
+	if link.MaxUses == 0 {
+		return true
+	}
+	// Count downloads like handleGet does: not HEAD requests, and not the
+	// follow-up ranges of a player or download manager.
+	scope := "link\x00" + sig
+	count := r.Method == http.MethodGet && !isRangeContinuation(r, scope)
+	allowed, err := useSignedLink(sig, link, count)
+	if err != nil {
+		GoLog.Errorf("failed to save config: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return false
+	}
+	if !allowed {
+		http.Error(w, "Gone: this link has been used up", http.StatusGone)
+		return false
+	}
+	if count {
+		grantRangeContinuations(r, scope)
+	}
+	return true
+}
+
+// linkUsesMu serializes changes to Config.LinkUses, so that concurrent
+// downloads of a link can't both take its last use.
+var linkUsesMu sync.Mutex
+
+// useSignedLink reports whether the link with signature sig has uses left
+// and, if count is set, takes one. The cached config and its maps are
+// shared with every request, so the new count goes into a copy.
+func useSignedLink(sig string, link shared.SignedLink, count bool) (bool, error) {
+	linkUsesMu.Lock()
+	defer linkUsesMu.Unlock()
+	config, err := shared.LoadConfig()
+	if err != nil {
+		return false, err
+	}
+	usage := config.LinkUses[sig]
+	if usage.Uses >= link.MaxUses {
+		return false, nil
+	}
+	if !count {
+		return true, nil
+	}
+	usage.Uses++
+	usage.Expires = link.Expires
+	next := *config
+	next.LinkUses = maps.Clone(config.LinkUses)
+	if next.LinkUses == nil {
+		next.LinkUses = make(map[string]shared.LinkUsage)
+	}
+	next.LinkUses[sig] = usage
+	return true, shared.SaveConfig(&next)
+}
+
+// pruneLinkUses drops the use counts of expired links from the config and
+// saves it if there were any.
+func pruneLinkUses() error {
+	linkUsesMu.Lock()
+	defer linkUsesMu.Unlock()
+	config, err := shared.LoadConfig()
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	uses := maps.Clone(config.LinkUses)
+	maps.DeleteFunc(uses, func(_ string, usage shared.LinkUsage) bool {
+		return usage.Expires <= now
+	})
+	if len(uses) == len(config.LinkUses) {
+		return nil
+	}
+	next := *config
+	next.LinkUses = uses
+	return shared.SaveConfig(&next)
+}
+
+// handleAdminLinks signs a direct link to a file in a share.
+// POST /admin/api/links
+// Body: {"subpath": "docs", "path": "/reports/q1.pdf", "expires": 1712000000,
+// "max_uses": 3, "ip": "203.0.113.7"}  (max_uses and ip are optional)
+// Response: {"url": "https://host/docs/reports/q1.pdf?expires=…&sig=…"}
+func handleAdminLinks(w http.ResponseWriter, r *http.Request) {
+	if !methodOnly(w, r, http.MethodPost) {
+		return
+	}
+	var req struct {
+		Subpath string `json:"subpath"`
+		Path    string `json:"path"`
+		Expires int64  `json:"expires"`
+		MaxUses int    `json:"max_uses"`
+		IP      string `json:"ip"`
+	}
+	if !decodeOrErr(w, r, &req) {
+		return
+	}
+	if req.Expires <= time.Now().Unix() {
+		http.Error(w, "expires must be in the future", http.StatusBadRequest)
+		return
+	}
+	if req.MaxUses < 0 {
+		http.Error(w, "max_uses must not be negative", http.StatusBadRequest)
+		return
+	}
+	if req.IP != "" && net.ParseIP(req.IP) == nil {
+		http.Error(w, "invalid ip: "+req.IP, http.StatusBadRequest)
+		return
+	}
+
+	config, ok := configOrErr(w)
+	if !ok {
+		return
+	}
+	fd, exists := config.Files[req.Subpath]
+	if !exists {
+		http.Error(w, "share not found", http.StatusNotFound)
+		return
+	}
+	rel := path.Clean("/" + req.Path)
+	diskPath := filepath.Join(fd.Path, filepath.FromSlash(rel))
+	info, err := os.Stat(diskPath)
+	if err != nil {
+		http.Error(w, "file not found in share", http.StatusNotFound)
+		return
+	}
+	if info.IsDir() {
+		http.Error(w, "links can only point to files", http.StatusBadRequest)
+		return
+	}
+
+	link := shared.SignedLink{
+		Subpath: req.Subpath,
+		Path:    rel,
+		Expires: req.Expires,
+		MaxUses: req.MaxUses,
+		IP:      req.IP,
+	}
+	GoLog.Infof("signed link created: /%s%s (expires %s, uses %d, ip %q)",
+		req.Subpath, rel, time.Unix(req.Expires, 0).Format(time.RFC3339), req.MaxUses, req.IP)
+	jsonResponse(w, map[string]string{"url": requestOrigin(r) + link.URL(config.LinkSecret)})
+}
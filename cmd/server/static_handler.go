@@ -1,20 +1,155 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"html/template"
 	"io"
+	"io/fs"
 	"net/http"
+	"os"
+	"strings"
 
 	"github.com/Wirezat/GoLog"
+	"github.com/Wirezat/fileshare/assets"
 )
 
-// handleShareCSS and handleShareJS serve static assets.
-func handleShareCSS(w http.ResponseWriter, r *http.Request) {
-	http.ServeFile(w, r, shareCssPath)
+var (
+	// builtinWeb holds the embedded templates, stylesheets and scripts.
+	builtinWeb = embeddedWeb()
+	// webFS is what is served: builtinWeb, unless main overlays
+	// Config.AssetsDir on it (see useAssetsDir).
+	webFS = builtinWeb
+)
+
+// assetHashes caches assetHash by path, size and modification time.
+var assetHashes sumCache[string]
+
+func embeddedWeb() fs.FS {
+	sub, err := fs.Sub(assets.Web, "web")
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
+
+// overlayFS serves files from dir where they exist and from base otherwise,
+// so an operator only needs to copy the files they customize.
+type overlayFS struct {
+	dir  fs.FS
+	base fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	f, err := o.dir.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return o.base.Open(name)
+	}
+	return f, err
+}
+
+// useAssetsDir overlays the files in dir on the embedded assets; this is the
+// operator's theme directory. Templates are parsed on first use (see
+// parseTemplate), so changes to them take effect after a restart; stylesheets
+// and scripts are read on every request.
+func useAssetsDir(dir string) {
+	if dir == "" {
+		return
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		GoLog.Warnf("assets directory %s not usable, serving embedded assets only: %v", dir, err)
+		return
+	}
+	webFS = overlayFS{dir: os.DirFS(dir), base: webFS}
+	GoLog.Infof("serving web assets from %s, falling back to the embedded ones", dir)
+}
+
+// staticAssets maps the URL of each static asset to its file.
+var staticAssets = map[string]string{
+	"/static/share.css":       shareCssPath,
+	"/static/share.js":        shareJsPath,
+	"/admin/static/admin.css": adminCssPath,
+	"/admin/static/admin.js":  adminJsPath,
 }
 
-func handleShareJS(w http.ResponseWriter, r *http.Request) {
-	http.ServeFile(w, r, shareJsPath)
+// parseTemplate parses the template file at path in webFS, with the asset
+// and i18n funcs and funcs available. The i18n funcs render in the fallback
+// language until the template is localized (see localize). If an override from Config.AssetsDir doesn't
+// parse, the built-in template is used instead, so a broken theme can't take
+// the pages down.
+func parseTemplate(name, path string, funcs template.FuncMap) (*template.Template, error) {
+	parse := func(fsys fs.FS) (*template.Template, error) {
+		return template.New(name).
+			Funcs(template.FuncMap{"asset": assetURL}).
+			Funcs(i18nFuncs(supportedLanguages[0])).
+			Funcs(funcs).
+			ParseFS(fsys, path)
+	}
+	tmpl, err := parse(webFS)
+	if _, overlaid := webFS.(overlayFS); err == nil || !overlaid {
+		return tmpl, err
+	}
+	GoLog.Errorf("template %s from the assets directory: %v; using the built-in one", path, err)
+	return parse(builtinWeb)
+}
+
+// assetHash returns a short content hash of a static asset file. Hashes are
+// cached by path, size and modification time, so edits to overrides are
+// picked up.
+func assetHash(path string) (string, bool) {
+	info, err := fs.Stat(webFS, path)
+	if err != nil {
+		return "", false
+	}
+	k := fileKeyOf(path, info)
+	if hash, ok := assetHashes.get(k); ok {
+		return hash, true
+	}
+	data, err := fs.ReadFile(webFS, path)
+	if err != nil {
+		return "", false
+	}
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:8])
+	assetHashes.put(k, hash)
+	return hash, true
+}
+
+// assetURL returns the URL of a static asset with its content hash in ?v=,
+// for use in templates. The URL changes whenever the file does, so browsers
+// can cache it for good.
+func assetURL(urlPath string) string {
+	if hash, ok := assetHash(staticAssets[urlPath]); ok {
+		return urlPath + "?v=" + hash
+	}
+	return urlPath
+}
+
+// handleStaticAsset serves a static asset with its content hash as ETag.
+// Requests for the current hashed URL may be cached for a year without
+// revalidation; anything else must revalidate. Admin assets are only cached
+// privately, as they sit behind the admin login.
+func handleStaticAsset(w http.ResponseWriter, r *http.Request) {
+	path, ok := staticAssets[r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if hash, ok := assetHash(path); ok {
+		w.Header().Set("ETag", `"`+hash+`"`)
+		scope := "public"
+		if strings.HasPrefix(r.URL.Path, "/admin/") {
+			scope = "private"
+		}
+		if r.URL.Query().Get("v") == hash {
+			w.Header().Set("Cache-Control", scope+", max-age=31536000, immutable")
+		} else {
+			w.Header().Set("Cache-Control", scope+", no-cache")
+		}
+	}
+	http.ServeFileFS(w, r, webFS, path)
 }
 
 // handleLogEvent allows the client to send log messages to the server.
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	GoLog "github.com/Wirezat/GoLog"
+	"github.com/klauspost/compress/zstd"
+	"github.com/klauspost/pgzip"
+)
+
+const (
+	tarPlain = iota
+	tarGzip
+	tarZstd
+)
+
+// gzipBlockSize is the block size each pgzip goroutine compresses at once.
+// RAM use is bounded by gzipBlockSize × 2 × workers.
+const gzipBlockSize = 1 << 20 // 1 MB
+
+// tarContent holds the Content-Type and file extension per compression.
+var tarContent = map[int]struct{ mimeType, ext string }{
+	tarPlain: {"application/x-tar", "tar"},
+	tarGzip:  {"application/gzip", "tar.gz"},
+	tarZstd:  {"application/zstd", "tar.zst"},
+}
+
+// tarWriter returns an archiveWriter producing a tarball with the given compression.
+func tarWriter(compression int) archiveWriter {
+	return func(ctx context.Context, w http.ResponseWriter, archiveName, baseDir string, paths []string) {
+		tarPathsAndServe(ctx, w, compression, archiveName, baseDir, paths)
+	}
+}
+
+// tarPathsAndServe streams a tarball of the given files and directories.
+//
+// Unlike ZIP, tar keeps permissions, ownership and symlinks: symlinks are
+// stored as links and never followed. Files are read one at a time, so only
+// the compressor holds data in RAM. gzip and zstd compress blocks in parallel
+// across all CPUs. The walk stops as soon as ctx is done.
+func tarPathsAndServe(ctx context.Context, w http.ResponseWriter, compression int, archiveName, baseDir string, paths []string) {
+	content := tarContent[compression]
+	w.Header().Set("Content-Type", content.mimeType)
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="%s.%s"`, archiveName, content.ext))
+
+	bw := bufio.NewWriterSize(w, httpWriteBufSize)
+	var out io.Writer = bw
+	var closeCompressor func() error
+
+	switch compression {
+	case tarGzip:
+		gw := pgzip.NewWriter(bw)
+		if err := gw.SetConcurrency(gzipBlockSize, runtime.NumCPU()); err != nil {
+			GoLog.Errorf("gzip concurrency: %v", err)
+		}
+		out, closeCompressor = gw, gw.Close
+	case tarZstd:
+		zw, err := zstd.NewWriter(bw, zstd.WithEncoderConcurrency(runtime.NumCPU()))
+		if err != nil {
+			GoLog.Errorf("zstd writer: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		out, closeCompressor = zw, zw.Close
+	}
+
+	tw := tar.NewWriter(out)
+	files := 0
+	var failed error
+
+	for _, root := range paths {
+		failed = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			if err != nil {
+				GoLog.Warnf("walk %s: %v", path, err)
+				return nil
+			}
+			if skip, err := skipHidden(path, root, info); skip {
+				return err
+			}
+			rel, err := filepath.Rel(baseDir, path)
+			if err != nil || rel == "." {
+				return nil
+			}
+			if err := writeTarEntry(tw, path, filepath.ToSlash(rel), info); err != nil {
+				// A half-written entry corrupts the rest of the stream, so stop here.
+				return err
+			}
+			if info.Mode().IsRegular() {
+				files++
+			}
+			return nil
+		})
+		if failed != nil {
+			break
+		}
+	}
+
+	cancelled := ctx.Err() != nil
+	switch {
+	case cancelled:
+	case failed != nil:
+		GoLog.Errorf("tar %s: %v", archiveName, failed)
+	default:
+		if err := tw.Close(); err != nil {
+			GoLog.Errorf("tar close: %v", err)
+		}
+	}
+	if closeCompressor != nil {
+		// Always close: the compressors run goroutines that only exit on Close.
+		if err := closeCompressor(); err != nil && !cancelled {
+			GoLog.Errorf("%s close: %v", content.ext, err)
+		}
+	}
+	if cancelled {
+		GoLog.Infof("%s cancelled: %s after %d files", content.ext, archiveName, files)
+		return
+	}
+	_ = bw.Flush()
+
+	GoLog.Infof("%s served: %s (%d files)", content.ext, archiveName, files)
+}
+
+// writeTarEntry writes the header and, for regular files, the content of one entry.
+// Sockets, devices and named pipes are skipped.
+func writeTarEntry(tw *tar.Writer, path, name string, info os.FileInfo) error {
+	var link string
+	switch mode := info.Mode(); {
+	case mode&os.ModeSymlink != 0:
+		target, err := os.Readlink(path)
+		if err != nil {
+			GoLog.Warnf("readlink %s: %v", path, err)
+			return nil
+		}
+		link = target
+	case !mode.IsRegular() && !mode.IsDir():
+		return nil
+	}
+
+	// Open before writing the header, so an unreadable file is skipped
+	// rather than leaving a header without content.
+	var f *os.File
+	if info.Mode().IsRegular() {
+		var err error
+		if f, err = os.Open(path); err != nil {
+			GoLog.Warnf("open %s: %v", path, err)
+			return nil
+		}
+		defer f.Close()
+	}
+
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		GoLog.Warnf("tar header %s: %v", path, err)
+		return nil
+	}
+	hdr.Name = name
+	if info.IsDir() {
+		hdr.Name += "/"
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("header %s: %w", name, err)
+	}
+	if f == nil {
+		return nil
+	}
+	// The header already declares the size; copy exactly that much even if
+	// the file grew since it was stat'ed.
+	if _, err := io.CopyN(tw, f, hdr.Size); err != nil {
+		return fmt.Errorf("copy %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Wirezat/GoLog"
+	"github.com/Wirezat/fileshare/pkg/shared"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+	"golang.org/x/sync/semaphore"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// thumbnailPixelBudget bounds the pixels of all images being decoded at
+	// once (~4 bytes each). Larger images are not thumbnailed at all.
+	thumbnailPixelBudget = 100_000_000
+	thumbnailQuality     = 80
+	// thumbnailMaxAge removes cached thumbnails that haven't been served for this long.
+	thumbnailMaxAge = 30 * 24 * time.Hour
+	// thumbnailTouchAfter limits how often a cache hit refreshes the file's mtime.
+	thumbnailTouchAfter = 24 * time.Hour
+)
+
+// thumbnailSizes are the bounding-box edge lengths, in pixels, accepted by ?thumb=.
+var thumbnailSizes = []int{256, 512, 1024}
+
+// thumbnailExts are the image types that can be thumbnailed.
+var thumbnailExts = []string{".jpg", ".jpeg", ".png", ".gif", ".webp"}
+
+var errImageTooLarge = errors.New("image too large")
+
+var (
+	thumbnailPixels = semaphore.NewWeighted(thumbnailPixelBudget)
+	thumbnailGroup  singleflight.Group
+)
+
+// isThumbnailRequest reports whether r asks for a thumbnail of a file.
+func isThumbnailRequest(r *http.Request) bool {
+	return r.URL.Query().Get("thumb") != ""
+}
+
+// serveThumbnail answers ?thumb=<size> for an image with a downscaled copy
+// that fits a size×size box, upright according to its EXIF orientation.
+// Thumbnails are cached in shared.ThumbnailDir, keyed by path, file
+// size, mtime and edge length, so a changed image gets a new one. Images that
+// can't be decoded or exceed the pixel budget are served unchanged.
+func serveThumbnail(w http.ResponseWriter, r *http.Request, ctx *requestContext) {
+	size, err := strconv.Atoi(r.URL.Query().Get("thumb"))
+	if err != nil || !slices.Contains(thumbnailSizes, size) {
+		http.Error(w, "Unsupported thumbnail size (use 256, 512 or 1024)", http.StatusBadRequest)
+		return
+	}
+	if ctx.fileInfo.IsDir() || !slices.Contains(thumbnailExts, strings.ToLower(filepath.Ext(ctx.diskPath))) {
+		http.Error(w, "No thumbnail available for this file type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	cachePath := thumbnailPath(shared.ThumbnailDir(ctx.config), ctx.diskPath, ctx.fileInfo, size)
+	if f, err := os.Open(cachePath); err == nil {
+		defer f.Close()
+		if info, err := f.Stat(); err == nil && time.Since(info.ModTime()) > thumbnailTouchAfter {
+			now := time.Now()
+			_ = os.Chtimes(cachePath, now, now)
+		}
+		http.ServeContent(w, r, "", ctx.fileInfo.ModTime(), f)
+		return
+	}
+
+	data, err, _ := thumbnailGroup.Do(cachePath, func() (any, error) {
+		// Not tied to this request: other clients may be waiting on the same thumbnail.
+		data, err := makeThumbnail(ctx.diskPath, size)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeFileAtomic(cachePath, data); err != nil {
+			GoLog.Warnf("thumbnail cache %s: %v", cachePath, err)
+		}
+		return data, nil
+	})
+	if err != nil {
+		GoLog.Warnf("thumbnail %s: %v; serving original", ctx.diskPath, err)
+		http.ServeFile(w, r, ctx.diskPath)
+		return
+	}
+	http.ServeContent(w, r, "", ctx.fileInfo.ModTime(), bytes.NewReader(data.([]byte)))
+}
+
+// thumbnailPath returns the cache file for one version of an image at one size.
+func thumbnailPath(cacheDir, path string, info os.FileInfo, size int) string {
+	sum := sha256.Sum256(fmt.Appendf(nil, "%s\x00%d\x00%d\x00%d", path, info.Size(), info.ModTime().UnixNano(), size))
+	key := hex.EncodeToString(sum[:16])
+	return filepath.Join(cacheDir, key[:2], key)
+}
+
+// makeThumbnail decodes the image at path and returns it scaled down to fit
+// a size×size box, as JPEG or, if it has transparency, as PNG.
+func makeThumbnail(path string, size int) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cfg, format, err := image.DecodeConfig(f)
+	if err != nil {
+		return nil, err
+	}
+	pixels := int64(cfg.Width) * int64(cfg.Height)
+	if pixels > thumbnailPixelBudget {
+		return nil, fmt.Errorf("%w: %dx%d", errImageTooLarge, cfg.Width, cfg.Height)
+	}
+
+	orientation := 1
+	if format == "jpeg" {
+		if _, err := f.Seek(0, 0); err != nil {
+			return nil, err
+		}
+		orientation = jpegOrientation(f)
+	}
+
+	if err := thumbnailPixels.Acquire(context.Background(), max(pixels, 1)); err != nil {
+		return nil, err
+	}
+	defer thumbnailPixels.Release(max(pixels, 1))
+
+	if _, err := f.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	src, _, err := image.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+
+	b := src.Bounds()
+	scale := min(1, float64(size)/float64(max(b.Dx(), b.Dy())))
+	dw, dh := max(1, int(float64(b.Dx())*scale+0.5)), max(1, int(float64(b.Dy())*scale+0.5))
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	draw.BiLinear.Scale(dst, dst.Bounds(), src, b, draw.Src, nil)
+	dst = applyOrientation(dst, orientation)
+
+	var buf bytes.Buffer
+	if dst.Opaque() {
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: thumbnailQuality})
+	} else {
+		err = png.Encode(&buf, dst)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeFileAtomic writes data to path via a temporary file in the same
+// directory, creating the directory if needed.
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// startThumbnailReaper periodically removes cached thumbnails that haven't
+// been served for thumbnailMaxAge, including those of deleted or changed images.
+func startThumbnailReaper(cacheDir string) {
+	go func() {
+		ticker := time.NewTicker(24 * time.Hour)
+		defer ticker.Stop()
+		for {
+			removed := 0
+			cutoff := time.Now().Add(-thumbnailMaxAge)
+			_ = filepath.WalkDir(cacheDir, func(path string, d fs.DirEntry, err error) error {
+				if err != nil || d.IsDir() {
+					return nil
+				}
+				if info, err := d.Info(); err == nil && info.ModTime().Before(cutoff) {
+					if os.Remove(path) == nil {
+						removed++
+					}
+				}
+				return nil
+			})
+			if removed > 0 {
+				GoLog.Infof("thumbnail cache: removed %d stale thumbnail(s)", removed)
+			}
+			<-ticker.C
+		}
+	}()
+}
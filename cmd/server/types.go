@@ -6,17 +6,21 @@ import (
 	"github.com/Wirezat/fileshare/pkg/shared"
 )
 
+// Paths of the web assets inside webFS.
 const (
-	shareHtmlPath = "./web/html/share.html"
-	shareCssPath  = "./web/css/share.css"
-	shareJsPath   = "./web/js/share.js"
-	adminHtmlPath = "./web/html/admin.html"
-	adminCssPath  = "./web/css/admin.css"
-	adminJsPath   = "./web/js/admin.js"
-	setupHtmlPath = "./web/html/setup.html"
-	setupJsPath   = "./web/js/setup.js"
-	setupCssPath  = "./web/css/setup.css"
-	gateHtmlPath  = "./web/html/gate.html"
+	shareHtmlPath   = "html/share.html"
+	shareCssPath    = "css/share.css"
+	shareJsPath     = "js/share.js"
+	adminHtmlPath   = "html/admin.html"
+	adminCssPath    = "css/admin.css"
+	adminJsPath     = "js/admin.js"
+	setupHtmlPath   = "html/setup.html"
+	setupJsPath     = "js/setup.js"
+	setupCssPath    = "css/setup.css"
+	gateHtmlPath    = "html/gate.html"
+	previewHtmlPath = "html/preview.html"
+	archiveHtmlPath = "html/archive.html"
+	i18nDir         = "i18n" // one <lang>.json catalog per language
 )
 
 // requestContext holds all resolved data for an incoming request,
@@ -35,9 +39,28 @@ type PageData struct {
 	UploadTime   int64
 	DirPath      string
 	Files        []shared.FileInfo
+	TotalSize    int64
+	SizeCapped   bool
+	Total        int
+	Sort         string
+	Desc         bool
+	Filter       string
+	NextURL      string
+	FirstURL     string
+	ToggleURL    string
+	Search       string
+	Truncated    bool
 	ParentDir    string
 	HasParentDir bool
 	Uses         int
 	Expiration   int64
 	AllowPost    bool
+
+	// ArchiveFormats are the ?download= formats offered for this share.
+	ArchiveFormats []string
+	// HasMedia is set if the page lists audio or video files.
+	HasMedia bool
+	Brand    shareBranding
+	// Perms are the visitor actions offered; AllowPost mirrors Perms.Upload.
+	Perms listingPerms
 }
@@ -16,6 +16,11 @@ import (
 
 const chunkTempBase = "/tmp/fileshare-chunks"
 
+// maxUploadChunks bounds totalChunks, and with it the memory a session's
+// received set, bitmap and missing list take. At the client's 5 MiB chunks
+// that's 5 TiB per file.
+const maxUploadChunks = 1 << 20
+
 var bufPool = sync.Pool{
 	New: func() any {
 		b := make([]byte, 32*1024)
@@ -23,11 +28,20 @@ var bufPool = sync.Pool{
 	},
 }
 
+// Assembly modes for chunked uploads.
+// assemblyConcat stores every chunk as its own file and concatenates them on completion.
+// assemblySparse preallocates the destination and writes each chunk at its offset.
+const (
+	assemblyConcat = "concat"
+	assemblySparse = "sparse"
+)
+
 // Storage is the interface for chunked file uploads.
 type Storage interface {
-	InitChunk(uploadID, filename string, totalChunks int, destDir string) (missingChunks []int, err error)
-	ReceiveChunk(uploadID string, index int, r io.Reader) (done bool, err error)
+	InitChunk(uploadID, filename string, totalChunks int, fileSize, chunkSize int64, destDir string) (missingChunks []int, err error)
+	ReceiveChunk(uploadID string, index int, r io.Reader) (dest string, err error)
 	SetInactivityTimeout(d time.Duration)
+	SetAssemblyMode(mode string)
 }
 
 // sessionMeta is persisted as meta.json inside each chunk directory.
@@ -41,6 +55,12 @@ type sessionMeta struct {
 	TotalChunks  int       `json:"totalChunks"`
 	DestDir      string    `json:"destDir"`
 	LastActivity time.Time `json:"lastActivity"`
+
+	// Only set for sparse sessions. Mode is empty for sessions written
+	// before sparse assembly existed, which are treated as concat.
+	Mode      string `json:"mode,omitempty"`
+	FileSize  int64  `json:"fileSize,omitempty"`
+	ChunkSize int64  `json:"chunkSize,omitempty"`
 }
 
 type chunkSession struct {
@@ -53,6 +73,7 @@ type chunkSession struct {
 type LocalStorage struct {
 	mu                sync.RWMutex
 	inactivityTimeout time.Duration
+	assemblyMode      string
 }
 
 var (
@@ -63,6 +84,7 @@ var (
 func NewLocalStorage(cfg *shared.Config) *LocalStorage {
 	return &LocalStorage{
 		inactivityTimeout: time.Duration(cfg.ChunkInactivityTimeout) * time.Second,
+		assemblyMode:      cfg.ChunkAssemblyMode,
 	}
 }
 
@@ -72,14 +94,24 @@ func (s *LocalStorage) SetInactivityTimeout(d time.Duration) {
 	s.mu.Unlock()
 }
 
+// SetAssemblyMode changes the mode used for new upload sessions.
+// Sessions already in progress keep the mode they were created with.
+func (s *LocalStorage) SetAssemblyMode(mode string) {
+	s.mu.Lock()
+	s.assemblyMode = mode
+	s.mu.Unlock()
+}
+
 // InitChunk registers or resumes an upload session.
 // uploadID is provided by the client (deterministic hash of filename+size+lastModified).
+// fileSize and chunkSize are optional (0 if the client did not declare them);
+// sparse assembly is only used when both are known.
 // Returns the list of chunk indices still missing so the client can skip already-uploaded chunks.
-func (s *LocalStorage) InitChunk(uploadID, filename string, totalChunks int, destDir string) ([]int, error) {
+func (s *LocalStorage) InitChunk(uploadID, filename string, totalChunks int, fileSize, chunkSize int64, destDir string) ([]int, error) {
 	sessionsMu.Lock()
 	defer sessionsMu.Unlock()
 
-	sess, err := s.loadOrCreateSession(uploadID, filename, totalChunks, destDir)
+	sess, err := s.loadOrCreateSession(uploadID, filename, totalChunks, fileSize, chunkSize, destDir)
 	if err != nil {
 		return nil, err
 	}
@@ -97,7 +129,7 @@ func (s *LocalStorage) InitChunk(uploadID, filename string, totalChunks int, des
 // When resuming from disk, it scans actual chunk files to rebuild received state —
 // not meta.json — so a crash between writing the chunk and updating meta.json
 // never causes a chunk to be re-sent unnecessarily.
-func (s *LocalStorage) loadOrCreateSession(uploadID, filename string, totalChunks int, destDir string) (*chunkSession, error) {
+func (s *LocalStorage) loadOrCreateSession(uploadID, filename string, totalChunks int, fileSize, chunkSize int64, destDir string) (*chunkSession, error) {
 	// 1. RAM hit
 	if sess, ok := sessions[uploadID]; ok {
 		return sess, nil
@@ -114,7 +146,13 @@ func (s *LocalStorage) loadOrCreateSession(uploadID, filename string, totalChunk
 
 		// Scan disk for chunk files — because the server could crash before updating meta.json after a chunk upload.
 		// A chunk is considered received if and only if its file exists on disk.
-		received, err := scanReceivedChunks(dir, meta.TotalChunks)
+		// Sparse sessions have no chunk files; their bitmap is the source of truth instead.
+		var received map[int]struct{}
+		if meta.Mode == assemblySparse {
+			received, err = readBitmap(dir, &meta)
+		} else {
+			received, err = scanReceivedChunks(dir, meta.TotalChunks)
+		}
 		if err != nil {
 			return nil, fmt.Errorf("scanning chunks for %q: %w", uploadID, err)
 		}
@@ -135,6 +173,20 @@ func (s *LocalStorage) loadOrCreateSession(uploadID, filename string, totalChunk
 		DestDir:      destDir,
 		LastActivity: time.Now(),
 	}
+
+	s.mu.RLock()
+	mode := s.assemblyMode
+	s.mu.RUnlock()
+	if mode == assemblySparse && fileSize > 0 && chunkSize > 0 {
+		meta.Mode = assemblySparse
+		meta.FileSize = fileSize
+		meta.ChunkSize = chunkSize
+		if err := createSparseTarget(dir, &meta); err != nil {
+			os.RemoveAll(dir)
+			return nil, err
+		}
+	}
+
 	if err := writeMeta(dir, meta); err != nil {
 		return nil, err
 	}
@@ -157,41 +209,32 @@ func scanReceivedChunks(dir string, totalChunks int) (map[int]struct{}, error) {
 }
 
 // ReceiveChunk stores a single chunk and updates meta.json (LastActivity only).
-// Returns done=true when all chunks have arrived and the file has been assembled.
-func (s *LocalStorage) ReceiveChunk(uploadID string, index int, r io.Reader) (bool, error) {
+// Once all chunks have arrived and the file has been assembled, it returns
+// the file's path; before that, dest is empty.
+func (s *LocalStorage) ReceiveChunk(uploadID string, index int, r io.Reader) (string, error) {
 	sessionsMu.RLock()
 	sess, ok := sessions[uploadID]
 	sessionsMu.RUnlock()
 	if !ok {
-		return false, fmt.Errorf("unknown upload session %q", uploadID)
+		return "", fmt.Errorf("unknown upload session %q", uploadID)
 	}
 	if index < 0 || index >= sess.meta.TotalChunks {
-		return false, fmt.Errorf("chunk index %d out of range [0, %d)", index, sess.meta.TotalChunks)
+		return "", fmt.Errorf("chunk index %d out of range [0, %d)", index, sess.meta.TotalChunks)
 	}
 
 	sess.mu.Lock()
 	_, alreadyReceived := sess.received[index]
 	sess.mu.Unlock()
 	if alreadyReceived {
-		return len(sess.received) == sess.meta.TotalChunks, nil
+		return "", nil
 	}
 
-	chunkPath := filepath.Join(chunkTempBase, uploadID, fmt.Sprintf("%05d", index))
-	f, err := os.Create(chunkPath)
-	if err != nil {
-		return false, fmt.Errorf("creating chunk file: %w", err)
-	}
-	buf := bufPool.Get().(*[]byte)
-	_, err = io.CopyBuffer(f, r, *buf)
-	bufPool.Put(buf)
-	if err != nil {
-		f.Close()
-		os.Remove(chunkPath)
-		return false, fmt.Errorf("writing chunk %d: %w", index, err)
-	}
-	if err := f.Close(); err != nil {
-		os.Remove(chunkPath)
-		return false, fmt.Errorf("closing chunk %d: %w", index, err)
+	if sess.meta.Mode == assemblySparse {
+		if err := writeSparseChunk(&sess.meta, index, r); err != nil {
+			return "", err
+		}
+	} else if err := writeChunkFile(uploadID, index, r); err != nil {
+		return "", err
 	}
 
 	// Chunk is now safely on disk. Update in-RAM state and persist LastActivity.
@@ -199,6 +242,14 @@ func (s *LocalStorage) ReceiveChunk(uploadID string, index int, r io.Reader) (bo
 	// A failure to write meta.json here is non-fatal: the chunk file exists on disk
 	// and will be discovered by scanReceivedChunks on next resume.
 	sess.mu.Lock()
+	if sess.meta.Mode == assemblySparse {
+		// The bitmap bit is only set after the chunk data has been synced, so a
+		// crash in between at worst causes this chunk to be sent again.
+		if err := markBitmap(filepath.Join(chunkTempBase, uploadID), index); err != nil {
+			sess.mu.Unlock()
+			return "", err
+		}
+	}
 	sess.received[index] = struct{}{}
 	sess.meta.LastActivity = time.Now()
 	metaSnap := sess.meta
@@ -208,25 +259,53 @@ func (s *LocalStorage) ReceiveChunk(uploadID string, index int, r io.Reader) (bo
 	if err := writeMeta(filepath.Join(chunkTempBase, uploadID), metaSnap); err != nil {
 		GoLog.Warnf("chunk upload: failed to persist meta for %q: %v (non-fatal)", uploadID, err)
 	}
-	if done {
-		if err := assemble(&metaSnap, uploadID); err != nil {
-			return false, err
-		}
-		cleanupSession(uploadID)
-		GoLog.Infof("chunk upload complete: %q → %q", metaSnap.Filename, metaSnap.DestDir)
+	if !done {
+		return "", nil
 	}
-	return done, nil
+	finalize := assemble
+	if metaSnap.Mode == assemblySparse {
+		finalize = finalizeSparse
+	}
+	dest, err := finalize(&metaSnap, uploadID)
+	if err != nil {
+		return "", err
+	}
+	cleanupSession(uploadID)
+	GoLog.Infof("chunk upload complete: %q → %q", metaSnap.Filename, dest)
+	return dest, nil
+}
+
+// writeChunkFile stores a single chunk as its own file in the session directory.
+func writeChunkFile(uploadID string, index int, r io.Reader) error {
+	chunkPath := filepath.Join(chunkTempBase, uploadID, fmt.Sprintf("%05d", index))
+	f, err := os.Create(chunkPath)
+	if err != nil {
+		return fmt.Errorf("creating chunk file: %w", err)
+	}
+	buf := bufPool.Get().(*[]byte)
+	_, err = io.CopyBuffer(f, r, *buf)
+	bufPool.Put(buf)
+	if err != nil {
+		f.Close()
+		os.Remove(chunkPath)
+		return fmt.Errorf("writing chunk %d: %w", index, err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(chunkPath)
+		return fmt.Errorf("closing chunk %d: %w", index, err)
+	}
+	return nil
 }
 
 // assemble writes all chunks sequentially into the destination file.
-// Uses a .tmp file + os.Rename for an atomic result.
-func assemble(meta *sessionMeta, uploadID string) error {
+// Uses a .tmp file + os.Rename for an atomic result. Returns the file's path.
+func assemble(meta *sessionMeta, uploadID string) (string, error) {
 	dest := resolveDestPath(meta.DestDir, meta.Filename)
 	tmp := dest + ".tmp"
 
 	out, err := os.Create(tmp)
 	if err != nil {
-		return fmt.Errorf("creating target file: %w", err)
+		return "", fmt.Errorf("creating target file: %w", err)
 	}
 
 	failed := false
@@ -245,25 +324,25 @@ func assemble(meta *sessionMeta, uploadID string) error {
 		in, err := os.Open(chunkPath)
 		if err != nil {
 			failed = true
-			return fmt.Errorf("missing chunk %d: %w", i, err)
+			return "", fmt.Errorf("missing chunk %d: %w", i, err)
 		}
 		_, err = io.CopyBuffer(out, in, *buf)
 		in.Close()
 		if err != nil {
 			failed = true
-			return fmt.Errorf("assembling chunk %d: %w", i, err)
+			return "", fmt.Errorf("assembling chunk %d: %w", i, err)
 		}
 	}
 
 	if err := out.Close(); err != nil {
 		failed = true
-		return fmt.Errorf("closing tmp file: %w", err)
+		return "", fmt.Errorf("closing tmp file: %w", err)
 	}
 	if err := os.Rename(tmp, dest); err != nil {
 		failed = true
-		return fmt.Errorf("renaming assembled file: %w", err)
+		return "", fmt.Errorf("renaming assembled file: %w", err)
 	}
-	return nil
+	return dest, nil
 }
 
 // resolveDestPath returns dest/filename, appending a nanosecond suffix on collision.
@@ -363,6 +442,30 @@ func missingChunks(received map[int]struct{}, total int) []int {
 	return missing
 }
 
+// validUploadID reports whether id looks like the hex hash clients use as
+// upload IDs. IDs name the session's directory in chunkTempBase, so anything
+// else could point outside it.
+func validUploadID(id string) bool {
+	if len(id) < 8 || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// chunkCount returns how many chunks of chunkSize make up fileSize bytes.
+func chunkCount(fileSize, chunkSize int64) int64 {
+	n := fileSize / chunkSize
+	if fileSize%chunkSize != 0 {
+		n++
+	}
+	return n
+}
+
 func sanitizeFilename(name string) string {
 	return filepath.Base(name)
 }
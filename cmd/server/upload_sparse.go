@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/Wirezat/GoLog"
+)
+
+// bitmapFile stores one bit per chunk for sparse sessions.
+// A set bit means the chunk has been written to the target and synced.
+const bitmapFile = "received.bitmap"
+
+// targetFile is the preallocated file of a sparse session.
+const targetFile = "target.part"
+
+// sparseTargetPath returns the preallocated temp file for a sparse session.
+// It lives in the session directory rather than the share, so visitors can't
+// see or download the unfinished file in any way.
+func sparseTargetPath(meta *sessionMeta) string {
+	return filepath.Join(chunkTempBase, meta.UploadID, targetFile)
+}
+
+// createSparseTarget preallocates the target file at its declared size and
+// creates an empty bitmap in the session directory.
+func createSparseTarget(dir string, meta *sessionMeta) error {
+	if int64(meta.TotalChunks) != chunkCount(meta.FileSize, meta.ChunkSize) {
+		return fmt.Errorf("totalChunks %d does not match fileSize %d / chunkSize %d", meta.TotalChunks, meta.FileSize, meta.ChunkSize)
+	}
+
+	f, err := os.OpenFile(sparseTargetPath(meta), os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("creating sparse target: %w", err)
+	}
+	// Truncate extends the file without writing data, so the filesystem
+	// only allocates blocks as chunks arrive.
+	if err := f.Truncate(meta.FileSize); err != nil {
+		f.Close()
+		os.Remove(sparseTargetPath(meta))
+		return fmt.Errorf("preallocating sparse target: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(sparseTargetPath(meta))
+		return fmt.Errorf("closing sparse target: %w", err)
+	}
+
+	bitmap := make([]byte, (meta.TotalChunks+7)/8)
+	if err := os.WriteFile(filepath.Join(dir, bitmapFile), bitmap, 0600); err != nil {
+		os.Remove(sparseTargetPath(meta))
+		return fmt.Errorf("writing bitmap: %w", err)
+	}
+	return nil
+}
+
+// readBitmap rebuilds the received set of a sparse session from its bitmap.
+// If the target file has disappeared, all chunks are reported missing and
+// the target is recreated so the upload can start over.
+func readBitmap(dir string, meta *sessionMeta) (map[int]struct{}, error) {
+	if _, err := os.Stat(sparseTargetPath(meta)); err != nil {
+		GoLog.Warnf("chunk upload: sparse target for %q missing, restarting upload", meta.UploadID)
+		if err := createSparseTarget(dir, meta); err != nil {
+			return nil, err
+		}
+		return make(map[int]struct{}), nil
+	}
+
+	bitmap, err := os.ReadFile(filepath.Join(dir, bitmapFile))
+	if err != nil {
+		return nil, fmt.Errorf("reading bitmap: %w", err)
+	}
+	received := make(map[int]struct{}, meta.TotalChunks)
+	for i := range meta.TotalChunks {
+		if i/8 < len(bitmap) && bitmap[i/8]&(1<<(i%8)) != 0 {
+			received[i] = struct{}{}
+		}
+	}
+	return received, nil
+}
+
+// markBitmap sets the bit for index and syncs the bitmap to disk.
+// Callers must serialize calls per session.
+func markBitmap(dir string, index int) error {
+	f, err := os.OpenFile(filepath.Join(dir, bitmapFile), os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("opening bitmap: %w", err)
+	}
+	defer f.Close()
+
+	b := make([]byte, 1)
+	if _, err := f.ReadAt(b, int64(index/8)); err != nil {
+		return fmt.Errorf("reading bitmap: %w", err)
+	}
+	b[0] |= 1 << (index % 8)
+	if _, err := f.WriteAt(b, int64(index/8)); err != nil {
+		return fmt.Errorf("updating bitmap: %w", err)
+	}
+	return f.Sync()
+}
+
+// writeSparseChunk writes a chunk directly at its offset in the target file.
+// The chunk must have exactly the expected length; only the last chunk may be shorter.
+func writeSparseChunk(meta *sessionMeta, index int, r io.Reader) error {
+	offset := int64(index) * meta.ChunkSize
+	want := min(meta.ChunkSize, meta.FileSize-offset)
+
+	f, err := os.OpenFile(sparseTargetPath(meta), os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("opening sparse target: %w", err)
+	}
+	defer f.Close()
+
+	buf := bufPool.Get().(*[]byte)
+	n, err := io.CopyBuffer(io.NewOffsetWriter(f, offset), io.LimitReader(r, want), *buf)
+	bufPool.Put(buf)
+	if err != nil {
+		return fmt.Errorf("writing chunk %d: %w", index, err)
+	}
+	if n != want {
+		return fmt.Errorf("chunk %d has %d bytes, expected %d", index, n, want)
+	}
+	// Anything left in r means the chunk is oversized. It is rejected instead
+	// of written, since the extra bytes would overlap the next chunk.
+	if m, _ := r.Read(make([]byte, 1)); m > 0 {
+		return fmt.Errorf("chunk %d exceeds expected %d bytes", index, want)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("syncing chunk %d: %w", index, err)
+	}
+	return nil
+}
+
+// finalizeSparse moves the completed target to its final name and returns it.
+// That's a rename if the share is on the same filesystem as chunkTempBase;
+// otherwise the target is copied over once.
+func finalizeSparse(meta *sessionMeta, uploadID string) (string, error) {
+	dest := resolveDestPath(meta.DestDir, meta.Filename)
+	err := os.Rename(sparseTargetPath(meta), dest)
+	if errors.Is(err, syscall.EXDEV) {
+		err = copySparseTarget(sparseTargetPath(meta), dest)
+	}
+	if err != nil {
+		return "", fmt.Errorf("moving sparse target: %w", err)
+	}
+	return dest, nil
+}
+
+// copySparseTarget copies src to dest through a .tmp file + os.Rename, like
+// assemble, for targets that can't be renamed across filesystems.
+func copySparseTarget(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := dest + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	buf := bufPool.Get().(*[]byte)
+	_, err = io.CopyBuffer(out, in, *buf)
+	bufPool.Put(buf)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, dest)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
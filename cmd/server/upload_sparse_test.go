@@ -0,0 +1,190 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidUploadID(t *testing.T) {
+	tests := []struct {
+		id   string
+		want bool
+	}{
+		{"0123456789abcdef0123456789abcdef", true},
+		{"DEADBEEF", true},
+		{strings.Repeat("a", 64), true},
+		{"", false},
+		{"abc", false},
+		{strings.Repeat("a", 65), false},
+		{"0123456789abcdeg", false},
+		{"x/../../y", false},
+		{"../../../../etc", false},
+		{"abcdef12/..", false},
+		{"abcdef12\x00", false},
+	}
+	for _, tt := range tests {
+		if got := validUploadID(tt.id); got != tt.want {
+			t.Errorf("validUploadID(%q) = %v, want %v", tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestChunkCount(t *testing.T) {
+	tests := []struct {
+		fileSize, chunkSize, want int64
+	}{
+		{1, 5, 1},
+		{5, 5, 1},
+		{6, 5, 2},
+		{12, 5, 3},
+		{1 << 40, 5 << 20, 209716},
+		// No overflow near the top of the range.
+		{1<<63 - 1, 1<<63 - 1, 1},
+		{1<<63 - 1, 5, 1844674407370955162},
+	}
+	for _, tt := range tests {
+		if got := chunkCount(tt.fileSize, tt.chunkSize); got != tt.want {
+			t.Errorf("chunkCount(%d, %d) = %d, want %d", tt.fileSize, tt.chunkSize, got, tt.want)
+		}
+	}
+}
+
+// newSparseSession creates a sparse session for content in chunks of
+// chunkSize, with its target in chunkTempBase and its destination in a
+// temporary directory.
+func newSparseSession(t *testing.T, content string, chunkSize int64) (string, *sessionMeta) {
+	t.Helper()
+	b := make([]byte, 16)
+	rand.Read(b)
+	meta := &sessionMeta{
+		UploadID:    hex.EncodeToString(b),
+		Filename:    "file.bin",
+		DestDir:     t.TempDir(),
+		Mode:        assemblySparse,
+		FileSize:    int64(len(content)),
+		ChunkSize:   chunkSize,
+		TotalChunks: int(chunkCount(int64(len(content)), chunkSize)),
+	}
+	dir := filepath.Join(chunkTempBase, meta.UploadID)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	if err := createSparseTarget(dir, meta); err != nil {
+		t.Fatal(err)
+	}
+	return dir, meta
+}
+
+func TestSparseAssembly(t *testing.T) {
+	const content = "AAAAABBBBBCC"
+	dir, meta := newSparseSession(t, content, 5)
+
+	if info, err := os.Stat(sparseTargetPath(meta)); err != nil || info.Size() != int64(len(content)) {
+		t.Fatalf("target after create: %v, %v; want %d bytes", info, err, len(content))
+	}
+	if entries, _ := os.ReadDir(meta.DestDir); len(entries) != 0 {
+		t.Fatalf("destination has %d entries before the upload completed, want none", len(entries))
+	}
+
+	// Chunks may arrive in any order.
+	for _, index := range []int{2, 0} {
+		chunk := content[index*5 : min(index*5+5, len(content))]
+		if err := writeSparseChunk(meta, index, strings.NewReader(chunk)); err != nil {
+			t.Fatalf("writeSparseChunk(%d): %v", index, err)
+		}
+		if err := markBitmap(dir, index); err != nil {
+			t.Fatalf("markBitmap(%d): %v", index, err)
+		}
+	}
+
+	received, err := readBitmap(dir, meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if missing := missingChunks(received, meta.TotalChunks); len(missing) != 1 || missing[0] != 1 {
+		t.Fatalf("missing chunks = %v, want [1]", missing)
+	}
+
+	if err := writeSparseChunk(meta, 1, strings.NewReader("BBBBB")); err != nil {
+		t.Fatal(err)
+	}
+	dest, err := finalizeSparse(meta, meta.UploadID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(dest) != meta.DestDir {
+		t.Errorf("finalizeSparse = %q, want a file in %q", dest, meta.DestDir)
+	}
+	if got, _ := os.ReadFile(dest); string(got) != content {
+		t.Errorf("assembled file = %q, want %q", got, content)
+	}
+	if _, err := os.Stat(sparseTargetPath(meta)); !os.IsNotExist(err) {
+		t.Errorf("target still exists after finalizing: %v", err)
+	}
+}
+
+func TestWriteSparseChunkSize(t *testing.T) {
+	_, meta := newSparseSession(t, "AAAAABBBBBCC", 5)
+	tests := []struct {
+		index   int
+		chunk   string
+		wantErr bool
+	}{
+		{0, "AAAAA", false},
+		{2, "CC", false},
+		{0, "AAAA", true},
+		{0, "AAAAAA", true},
+		{2, "C", true},
+		{2, "CCC", true},
+	}
+	for _, tt := range tests {
+		err := writeSparseChunk(meta, tt.index, strings.NewReader(tt.chunk))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("writeSparseChunk(%d, %q) = %v, want error %v", tt.index, tt.chunk, err, tt.wantErr)
+		}
+	}
+}
+
+func TestReadBitmapRecreatesMissingTarget(t *testing.T) {
+	dir, meta := newSparseSession(t, "AAAAABBBBBCC", 5)
+	if err := markBitmap(dir, 1); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(sparseTargetPath(meta))
+
+	received, err := readBitmap(dir, meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != 0 {
+		t.Errorf("received = %v after the target disappeared, want none", received)
+	}
+	if _, err := os.Stat(sparseTargetPath(meta)); err != nil {
+		t.Errorf("target wasn't recreated: %v", err)
+	}
+}
+
+func TestCopySparseTarget(t *testing.T) {
+	dir := t.TempDir()
+	src, dest := filepath.Join(dir, "src"), filepath.Join(dir, "dest")
+	if err := os.WriteFile(src, []byte("payload"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := copySparseTarget(src, dest); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(dest); string(got) != "payload" {
+		t.Errorf("copied file = %q, want %q", got, "payload")
+	}
+	if _, err := os.Stat(dest + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temp file left behind: %v", err)
+	}
+	if err := copySparseTarget(filepath.Join(dir, "missing"), dest+"2"); err == nil {
+		t.Error("copySparseTarget of a missing file: want an error")
+	}
+}
@@ -4,47 +4,13 @@ import (
 	"net"
 	"net/http"
 	"strings"
-	"time"
 
-	"github.com/Wirezat/GoLog"
 	"github.com/Wirezat/fileshare/pkg/shared"
 )
 
-// startExpirationWatcher polls the config at the given interval and marks
-// shares as expired when IsExpired returns true. Runs as a background goroutine.
-func startExpirationWatcher(interval time.Duration) {
-	go func() {
-		GoLog.Infof("expiration watcher started (interval: %s)", interval)
-		for {
-			time.Sleep(interval)
-
-			config, err := shared.LoadConfig()
-			if err != nil {
-				GoLog.Errorf("failed to load config: %v", err)
-				continue
-			}
-
-			changed := false
-			for subpath, fd := range config.Files {
-				if !fd.Expired && shared.IsExpired(fd) {
-					fd.Expired = true
-					config.Files[subpath] = fd
-					changed = true
-					GoLog.Infof("file expired: %s", subpath)
-				}
-			}
-
-			if changed {
-				if err := shared.SaveConfig(config); err != nil {
-					GoLog.Errorf("failed to save config after expiration update: %v", err)
-				}
-			}
-		}
-	}()
-}
-
-// clientIP extracts the real client IP.
+// clientIP extracts the real client IP for logging.
 // Priority: X-Forwarded-For (first entry) → CF-Connecting-IP → RemoteAddr.
+// The headers can be forged, so decisions use trustedClientIP instead.
 func clientIP(r *http.Request) string {
 	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
 		if i := strings.IndexByte(xff, ','); i != -1 {
@@ -61,3 +27,63 @@ func clientIP(r *http.Request) string {
 	}
 	return r.RemoteAddr
 }
+
+// remoteIP returns the address of the peer that sent r.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// isTrustedProxy reports whether ip is in the config's TrustedProxies.
+func isTrustedProxy(ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	config, err := shared.LoadConfig()
+	if err != nil {
+		return false
+	}
+	for _, p := range config.TrustedProxies {
+		if _, network, err := net.ParseCIDR(p); err == nil {
+			if network.Contains(addr) {
+				return true
+			}
+		} else if addr.Equal(net.ParseIP(p)) {
+			return true
+		}
+	}
+	return false
+}
+
+// trustedClientIP returns the client's address for decisions such as IP
+// bindings and visitor limits. Forwarding headers are only believed from
+// trusted proxies: X-Forwarded-For is read from the right, skipping the
+// proxies' own entries, so a client can't slip in an address of its choice.
+func trustedClientIP(r *http.Request) string {
+	ip := remoteIP(r)
+	if !isTrustedProxy(ip) {
+		return ip
+	}
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		hops := strings.Split(strings.Join(xff, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if net.ParseIP(hop) == nil {
+				break
+			}
+			ip = hop
+			if !isTrustedProxy(hop) {
+				break
+			}
+		}
+		return ip
+	}
+	if cf := strings.TrimSpace(r.Header.Get("Cf-Connecting-Ip")); net.ParseIP(cf) != nil {
+		return cf
+	}
+	return ip
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Wirezat/GoLog"
+	"github.com/Wirezat/fileshare/pkg/shared"
+)
+
+// visitorCookiePrefix names the cookie that identifies a visitor's browser
+// session to a share, for FileData.OwnFilesOnly.
+const visitorCookiePrefix = "visitor_"
+
+// ownedEntryTTL bounds how long a created entry stays modifiable by its
+// visitor under OwnFilesOnly, whatever the browser does with the cookie.
+const ownedEntryTTL = 24 * time.Hour
+
+type ownedEntry struct {
+	visitor   string
+	expiresAt time.Time
+}
+
+// visitorOpsOrigin rejects visitor changes sent by other sites. Browsers
+// resend cached Basic auth credentials with cross-site requests, and a
+// plain form can post a JSON body, so the credentials alone don't show that
+// the visitor meant to make the change.
+var visitorOpsOrigin = http.NewCrossOriginProtection()
+
+var (
+	ownedEntriesMu sync.Mutex
+	// ownedEntries maps the disk paths of files and folders visitors created
+	// to their visitor ID. Kept in memory only, like share tokens.
+	ownedEntries = map[string]ownedEntry{}
+)
+
+// visitorID returns the visitor ID from the request's cookie for subpath, or "".
+func visitorID(r *http.Request, subpath string) string {
+	c, err := r.Cookie(visitorCookiePrefix + subpath)
+	if err != nil {
+		return ""
+	}
+	return c.Value
+}
+
+// ensureVisitorID returns the visitor ID of the request, issuing a new
+// session cookie if there is none yet.
+func ensureVisitorID(w http.ResponseWriter, r *http.Request, subpath string) (string, error) {
+	if id := visitorID(r, subpath); id != "" {
+		return id, nil
+	}
+	id, err := generateShareToken()
+	if err != nil {
+		return "", err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     visitorCookiePrefix + subpath,
+		Value:    id,
+		Path:     "/" + subpath,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return id, nil
+}
+
+// recordOwned notes that visitor created the entry at diskPath.
+func recordOwned(diskPath, visitor string) {
+	if visitor == "" {
+		return
+	}
+	ownedEntriesMu.Lock()
+	ownedEntries[diskPath] = ownedEntry{visitor: visitor, expiresAt: time.Now().Add(ownedEntryTTL)}
+	ownedEntriesMu.Unlock()
+}
+
+// isOwnedBy reports whether visitor created the entry at diskPath.
+func isOwnedBy(diskPath, visitor string) bool {
+	ownedEntriesMu.Lock()
+	e, ok := ownedEntries[diskPath]
+	ownedEntriesMu.Unlock()
+	return ok && visitor != "" && e.visitor == visitor && time.Now().Before(e.expiresAt)
+}
+
+// ownedPaths returns the share-relative paths of the entries visitor created
+// below root, sorted.
+func ownedPaths(root, visitor string) []string {
+	if visitor == "" {
+		return nil
+	}
+	now := time.Now()
+	var paths []string
+	ownedEntriesMu.Lock()
+	for p, e := range ownedEntries {
+		if e.visitor == visitor && now.Before(e.expiresAt) && strings.HasPrefix(p, root+"/") {
+			paths = append(paths, strings.TrimPrefix(p, root))
+		}
+	}
+	ownedEntriesMu.Unlock()
+	slices.Sort(paths)
+	return paths
+}
+
+// moveOwned carries the ownership of an entry, and of everything below it,
+// over to its new path.
+func moveOwned(from, to string) {
+	ownedEntriesMu.Lock()
+	defer ownedEntriesMu.Unlock()
+	for p, e := range ownedEntries {
+		if p == from || strings.HasPrefix(p, from+"/") {
+			delete(ownedEntries, p)
+			ownedEntries[to+strings.TrimPrefix(p, from)] = e
+		}
+	}
+}
+
+func forgetOwned(diskPath string) {
+	ownedEntriesMu.Lock()
+	delete(ownedEntries, diskPath)
+	ownedEntriesMu.Unlock()
+}
+
+func startOwnedEntryReaper() {
+	go func() {
+		ticker := time.NewTicker(shareTokenReap)
+		defer ticker.Stop()
+		for range ticker.C {
+			now := time.Now()
+			ownedEntriesMu.Lock()
+			for p, e := range ownedEntries {
+				if now.After(e.expiresAt) {
+					delete(ownedEntries, p)
+				}
+			}
+			ownedEntriesMu.Unlock()
+		}
+	}()
+}
+
+// listingPerms are the visitor actions a listing offers.
+type listingPerms struct {
+	Read, Upload, Mkdir, Rename, Delete bool
+	// Owned holds the paths the visitor may rename or delete under
+	// OwnFilesOnly; nil means any entry.
+	Owned map[string]bool
+}
+
+// entryActions are the actions offered on one listed entry.
+type entryActions struct {
+	Path, Name     string
+	Rename, Delete bool
+}
+
+// For returns the actions offered on f, for {{template "entry-actions"}}.
+func (p listingPerms) For(f shared.FileInfo) entryActions {
+	mine := p.Owned == nil || p.Owned[f.Path]
+	return entryActions{Path: f.Path, Name: f.Name, Rename: p.Rename && mine, Delete: p.Delete && mine}
+}
+
+// listingPermsFor returns the visitor actions a listing of a share offers
+// to the visitor making r.
+func listingPermsFor(r *http.Request, subpath string, fd shared.FileData) listingPerms {
+	p := listingPerms{
+		Read:   shared.HasPermission(fd, shared.PermRead),
+		Upload: shared.HasPermission(fd, shared.PermUpload),
+		Mkdir:  shared.HasPermission(fd, shared.PermMkdir),
+		Rename: shared.HasPermission(fd, shared.PermRename),
+		Delete: shared.HasPermission(fd, shared.PermDelete),
+	}
+	if fd.OwnFilesOnly && (p.Rename || p.Delete) {
+		p.Owned = make(map[string]bool)
+		for _, rel := range ownedPaths(fd.Path, visitorID(r, subpath)) {
+			p.Owned[rel] = true
+		}
+	}
+	return p
+}
+
+// withinShare reports whether diskPath, a cleaned path, is root or below it.
+func withinShare(root, diskPath string) bool {
+	return diskPath == root || strings.HasPrefix(diskPath, root+"/")
+}
+
+// resolveVisitorShare loads the share of a visitor file operation on
+// /{subpath}/... and checks it like prepareRequest does: it must exist, be
+// live and unlocked, and grant perm.
+func resolveVisitorShare(w http.ResponseWriter, r *http.Request, perm string) (string, shared.FileData, bool) {
+	config, err := shared.LoadConfig()
+	if err != nil {
+		GoLog.Errorf("failed to load config: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return "", shared.FileData{}, false
+	}
+	if err := visitorOpsOrigin.Check(r); err != nil {
+		GoLog.Warnf("cross-site %s %s from %s refused", r.Method, r.URL.Path, clientIP(r))
+		http.Error(w, "Forbidden: cross-site request", http.StatusForbidden)
+		return "", shared.FileData{}, false
+	}
+	subpath := r.PathValue("subpath")
+	fd, exists := config.Files[subpath]
+	if !exists {
+		http.NotFound(w, r)
+		return "", shared.FileData{}, false
+	}
+	if shared.IsExpired(fd) {
+		http.Error(w, "File share expired", http.StatusGone)
+		return "", shared.FileData{}, false
+	}
+	if !liveOrErr(w, r, subpath, fd) {
+		return "", shared.FileData{}, false
+	}
+	if fd.Password != "" && !isShareUnlocked(r, subpath, fd) {
+		requestBasicAuth(w, subpath)
+		return "", shared.FileData{}, false
+	}
+	if !shared.HasPermission(fd, perm) {
+		http.Error(w, "Forbidden: this share does not allow "+perm, http.StatusForbidden)
+		return "", shared.FileData{}, false
+	}
+	return subpath, fd, true
+}
+
+// resolveShareDir maps a share-relative directory path from a request body to
+// disk, for uploads and new folders. It must be an existing directory.
+func resolveShareDir(w http.ResponseWriter, fd shared.FileData, rel string) (string, bool) {
+	dir := filepath.Join(fd.Path, filepath.FromSlash("/"+rel))
+	if !withinShare(fd.Path, dir) || !resolvesWithinShare(fd.Path, dir) {
+		GoLog.Warnf("path traversal attempt: %s", dir)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return "", false
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		http.Error(w, "Not Found: no such folder", http.StatusNotFound)
+		return "", false
+	}
+	return dir, true
+}
+
+// resolveShareEntry maps a share-relative path from a request body to the
+// visible entry it names. The share root and hidden entries, such as
+// uploads in progress, can't be modified.
+func resolveShareEntry(w http.ResponseWriter, fd shared.FileData, rel string) (string, bool) {
+	diskPath := filepath.Join(fd.Path, filepath.FromSlash("/"+rel))
+	if !withinShare(fd.Path, diskPath) || !resolvesWithinShare(fd.Path, filepath.Dir(diskPath)) {
+		GoLog.Warnf("path traversal attempt: %s", diskPath)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return "", false
+	}
+	if diskPath == fd.Path || strings.HasPrefix(filepath.Base(diskPath), ".") {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return "", false
+	}
+	if _, err := os.Lstat(diskPath); err != nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return "", false
+	}
+	return diskPath, true
+}
+
+// resolvesWithinShare reports whether dir still lies within root once
+// symlinks are followed. Listings may follow symlinks out of a share, but
+// changes must not. A dir that doesn't exist yet is judged by its closest
+// existing parent.
+func resolvesWithinShare(root, dir string) bool {
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return false
+	}
+	for {
+		realDir, err := filepath.EvalSymlinks(dir)
+		if err == nil {
+			return withinShare(realRoot, realDir)
+		}
+		parent := filepath.Dir(dir)
+		if !errors.Is(err, fs.ErrNotExist) || parent == dir {
+			return false
+		}
+		dir = parent
+	}
+}
+
+// linkWithinShare reports whether diskPath, if it is a symlink, points at an
+// existing entry within root. Writing to a symlink writes to its target, so
+// a link out of the share, or to nothing yet, must not be written through.
+// Anything that isn't a symlink passes.
+func linkWithinShare(root, diskPath string) bool {
+	info, err := os.Lstat(diskPath)
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		return true
+	}
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return false
+	}
+	realPath, err := filepath.EvalSymlinks(diskPath)
+	if err != nil {
+		return false
+	}
+	return withinShare(realRoot, realPath)
+}
+
+// validEntryName reports whether a visitor may give an entry this name: a
+// single visible path element.
+func validEntryName(name string) bool {
+	return name != "" && len(name) <= 255 && !strings.HasPrefix(name, ".") &&
+		!strings.ContainsAny(name, "/\\\x00")
+}
+
+// ownsOrErr enforces FileData.OwnFilesOnly for a change to diskPath.
+func ownsOrErr(w http.ResponseWriter, r *http.Request, subpath string, fd shared.FileData, diskPath string) bool {
+	if fd.OwnFilesOnly && !isOwnedBy(diskPath, visitorID(r, subpath)) {
+		http.Error(w, "Forbidden: only files you uploaded in this session can be changed", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// handleVisitorMkdir creates a folder in a share.
+// POST /{subpath}/mkdir
+// Body: {"dir": "/docs", "name": "New folder"}  (dir is relative to the share)
+// Response: 201 + {"path": "/docs/New folder"}
+func handleVisitorMkdir(w http.ResponseWriter, r *http.Request) {
+	subpath, fd, ok := resolveVisitorShare(w, r, shared.PermMkdir)
+	if !ok {
+		return
+	}
+	var body struct {
+		Dir  string `json:"dir"`
+		Name string `json:"name"`
+	}
+	if !decodeOrErr(w, r, &body) {
+		return
+	}
+	if !validEntryName(body.Name) {
+		http.Error(w, "Bad Request: invalid folder name", http.StatusBadRequest)
+		return
+	}
+	parent, ok := resolveShareDir(w, fd, body.Dir)
+	if !ok {
+		return
+	}
+	visitor, err := ensureVisitorID(w, r, subpath)
+	if err != nil {
+		GoLog.Errorf("mkdir: visitor id: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	dir := filepath.Join(parent, body.Name)
+	if err := os.Mkdir(dir, 0755); err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			http.Error(w, "Conflict: an entry of that name exists", http.StatusConflict)
+			return
+		}
+		GoLog.Errorf("mkdir %s: %v", dir, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	recordOwned(dir, visitor)
+	rel := strings.TrimPrefix(dir, fd.Path)
+	GoLog.Infof("%s: created folder /%s%s", clientIP(r), subpath, rel)
+	w.WriteHeader(http.StatusCreated)
+	jsonResponse(w, map[string]string{"path": rel})
+}
+
+// handleVisitorRename renames a file or folder in place.
+// POST /{subpath}/rename
+// Body: {"path": "/docs/a.txt", "name": "b.txt"}
+// Response: 200 + {"path": "/docs/b.txt"}
+func handleVisitorRename(w http.ResponseWriter, r *http.Request) {
+	subpath, fd, ok := resolveVisitorShare(w, r, shared.PermRename)
+	if !ok {
+		return
+	}
+	var body struct {
+		Path string `json:"path"`
+		Name string `json:"name"`
+	}
+	if !decodeOrErr(w, r, &body) {
+		return
+	}
+	if !validEntryName(body.Name) {
+		http.Error(w, "Bad Request: invalid name", http.StatusBadRequest)
+		return
+	}
+	src, ok := resolveShareEntry(w, fd, body.Path)
+	if !ok || !ownsOrErr(w, r, subpath, fd, src) {
+		return
+	}
+
+	dest := filepath.Join(filepath.Dir(src), body.Name)
+	if dest != src {
+		// os.Rename replaces an existing file, so check first. The window
+		// between the two is no worse than two visitors uploading at once.
+		if _, err := os.Lstat(dest); err == nil {
+			http.Error(w, "Conflict: an entry of that name exists", http.StatusConflict)
+			return
+		}
+		if err := os.Rename(src, dest); err != nil {
+			GoLog.Errorf("rename %s: %v", src, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		moveOwned(src, dest)
+	}
+	rel := strings.TrimPrefix(dest, fd.Path)
+	GoLog.Infof("%s: renamed /%s%s to %s", clientIP(r), subpath, strings.TrimPrefix(src, fd.Path), body.Name)
+	jsonResponse(w, map[string]string{"path": rel})
+}
+
+// handleVisitorDelete deletes a file or an empty folder.
+// POST /{subpath}/delete
+// Body: {"path": "/docs/a.txt"}
+// Response: 204
+func handleVisitorDelete(w http.ResponseWriter, r *http.Request) {
+	subpath, fd, ok := resolveVisitorShare(w, r, shared.PermDelete)
+	if !ok {
+		return
+	}
+	var body struct {
+		Path string `json:"path"`
+	}
+	if !decodeOrErr(w, r, &body) {
+		return
+	}
+	target, ok := resolveShareEntry(w, fd, body.Path)
+	if !ok || !ownsOrErr(w, r, subpath, fd, target) {
+		return
+	}
+
+	// os.Remove only removes empty folders, so nothing is deleted unseen.
+	if err := os.Remove(target); err != nil {
+		if info, statErr := os.Lstat(target); statErr == nil && info.IsDir() {
+			http.Error(w, "Conflict: the folder is not empty", http.StatusConflict)
+			return
+		}
+		GoLog.Errorf("delete %s: %v", target, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	forgetOwned(target)
+	GoLog.Infof("%s: deleted /%s%s", clientIP(r), subpath, strings.TrimPrefix(target, fd.Path))
+	w.WriteHeader(http.StatusNoContent)
+}
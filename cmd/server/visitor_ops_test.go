@@ -0,0 +1,216 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Wirezat/fileshare/pkg/shared"
+)
+
+func TestOwnedEntries(t *testing.T) {
+	root := t.TempDir()
+	p := func(rel string) string { return filepath.Join(root, rel) }
+	t.Cleanup(func() {
+		ownedEntriesMu.Lock()
+		for path := range ownedEntries {
+			if withinShare(root, path) {
+				delete(ownedEntries, path)
+			}
+		}
+		ownedEntriesMu.Unlock()
+	})
+
+	recordOwned(p("a.txt"), "alice")
+	recordOwned(p("dir"), "alice")
+	recordOwned(p("dir/b.txt"), "alice")
+	recordOwned(p("c.txt"), "bob")
+	recordOwned(p("nobody.txt"), "")
+	ownedEntriesMu.Lock()
+	ownedEntries[p("old.txt")] = ownedEntry{visitor: "alice", expiresAt: time.Now().Add(-time.Second)}
+	ownedEntriesMu.Unlock()
+
+	tests := []struct {
+		path    string
+		visitor string
+		want    bool
+	}{
+		{"a.txt", "alice", true},
+		{"a.txt", "bob", false},
+		{"a.txt", "", false},
+		{"c.txt", "bob", true},
+		{"nobody.txt", "", false},
+		{"old.txt", "alice", false},
+		{"missing.txt", "alice", false},
+	}
+	for _, tt := range tests {
+		if got := isOwnedBy(p(tt.path), tt.visitor); got != tt.want {
+			t.Errorf("isOwnedBy(%s, %q) = %v, want %v", tt.path, tt.visitor, got, tt.want)
+		}
+	}
+	if got, want := ownedPaths(root, "alice"), []string{"/a.txt", "/dir", "/dir/b.txt"}; !slices.Equal(got, want) {
+		t.Errorf("ownedPaths(alice) = %v, want %v", got, want)
+	}
+	if got := ownedPaths(root, ""); got != nil {
+		t.Errorf("ownedPaths without a visitor = %v, want none", got)
+	}
+
+	// Renaming a folder carries what is below it along, but not entries
+	// that merely share its name as a prefix.
+	recordOwned(p("dir2"), "bob")
+	moveOwned(p("dir"), p("moved"))
+	if got, want := ownedPaths(root, "alice"), []string{"/a.txt", "/moved", "/moved/b.txt"}; !slices.Equal(got, want) {
+		t.Errorf("ownedPaths(alice) after moving dir = %v, want %v", got, want)
+	}
+	if !isOwnedBy(p("dir2"), "bob") {
+		t.Error("moving dir took dir2 along")
+	}
+
+	forgetOwned(p("a.txt"))
+	if isOwnedBy(p("a.txt"), "alice") {
+		t.Error("isOwnedBy after forgetOwned = true, want false")
+	}
+}
+
+func TestOwnsOrErr(t *testing.T) {
+	root := t.TempDir()
+	mine, theirs := filepath.Join(root, "mine.txt"), filepath.Join(root, "theirs.txt")
+	recordOwned(mine, "me")
+	recordOwned(theirs, "them")
+	t.Cleanup(func() { forgetOwned(mine); forgetOwned(theirs) })
+
+	tests := []struct {
+		own    bool
+		cookie string
+		path   string
+		want   bool
+	}{
+		{false, "", theirs, true},
+		{true, "me", mine, true},
+		{true, "me", theirs, false},
+		{true, "", mine, false},
+		{true, "someone", filepath.Join(root, "new.txt"), false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/s/delete", nil)
+		if tt.cookie != "" {
+			r.AddCookie(&http.Cookie{Name: visitorCookiePrefix + "s", Value: tt.cookie})
+		}
+		w := httptest.NewRecorder()
+		fd := shared.FileData{Path: root, OwnFilesOnly: tt.own}
+		if got := ownsOrErr(w, r, "s", fd, tt.path); got != tt.want {
+			t.Errorf("OwnFilesOnly %v, visitor %q, %s: ownsOrErr = %v, want %v", tt.own, tt.cookie, filepath.Base(tt.path), got, tt.want)
+		}
+		if !tt.want && w.Code != http.StatusForbidden {
+			t.Errorf("OwnFilesOnly %v, visitor %q: status %d, want %d", tt.own, tt.cookie, w.Code, http.StatusForbidden)
+		}
+	}
+}
+
+func TestValidEntryName(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"report.pdf", true},
+		{"New folder", true},
+		{"ümlaut", true},
+		{"a..b", true},
+		{strings.Repeat("a", 255), true},
+		{"", false},
+		{".", false},
+		{"..", false},
+		{".hidden", false},
+		{"a/b", false},
+		{`a\b`, false},
+		{"a\x00b", false},
+		{strings.Repeat("a", 256), false},
+	}
+	for _, tt := range tests {
+		if got := validEntryName(tt.name); got != tt.want {
+			t.Errorf("validEntryName(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestShareSymlinks(t *testing.T) {
+	root := writeTree(t, map[string]string{"a.txt": "a", "dir/b.txt": "b"})
+	outside := writeTree(t, map[string]string{"c.txt": "c"})
+	os.Symlink(outside, filepath.Join(root, "outdir"))
+	os.Symlink(filepath.Join(outside, "c.txt"), filepath.Join(root, "out.txt"))
+	os.Symlink(filepath.Join(root, "dir"), filepath.Join(root, "indir"))
+	os.Symlink(filepath.Join(root, "a.txt"), filepath.Join(root, "in.txt"))
+	os.Symlink(filepath.Join(root, "missing"), filepath.Join(root, "dangling"))
+
+	tests := []struct {
+		path         string
+		wantResolves bool // resolvesWithinShare
+		wantLink     bool // linkWithinShare
+	}{
+		{"", true, true},
+		{"dir", true, true},
+		{"a.txt", true, true},
+		{"new", true, true},
+		{"dir/new", true, true},
+		{"dir/new/deeper", true, true},
+		{"outdir/new/deeper", false, true},
+		{"outdir", false, false},
+		{"outdir/c.txt", false, true},
+		{"outdir/new", false, true},
+		{"out.txt", false, false},
+		{"indir", true, true},
+		{"indir/b.txt", true, true},
+		{"in.txt", true, true},
+		{"dangling", true, false},
+	}
+	for _, tt := range tests {
+		p := filepath.Join(root, tt.path)
+		if got := resolvesWithinShare(root, p); got != tt.wantResolves {
+			t.Errorf("resolvesWithinShare(/%s) = %v, want %v", tt.path, got, tt.wantResolves)
+		}
+		if got := linkWithinShare(root, p); got != tt.wantLink {
+			t.Errorf("linkWithinShare(/%s) = %v, want %v", tt.path, got, tt.wantLink)
+		}
+	}
+}
+
+func TestResolveShareEntry(t *testing.T) {
+	root := writeTree(t, map[string]string{"a.txt": "a", "dir/b.txt": "b", ".part": "p"})
+	outside := writeTree(t, map[string]string{"c.txt": "c"})
+	os.Symlink(outside, filepath.Join(root, "outdir"))
+	fd := shared.FileData{Path: root}
+
+	tests := []struct {
+		rel  string
+		want int // 0 if resolved
+	}{
+		{"/a.txt", 0},
+		{"dir/b.txt", 0},
+		{"/dir", 0},
+		{"/outdir", 0},
+		{"/", http.StatusForbidden},
+		{"", http.StatusForbidden},
+		{"/.part", http.StatusForbidden},
+		{"/../a.txt", http.StatusForbidden},
+		{"/outdir/c.txt", http.StatusForbidden},
+		{"/missing.txt", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		got, ok := resolveShareEntry(w, fd, tt.rel)
+		if tt.want == 0 {
+			if !ok || !withinShare(root, got) {
+				t.Errorf("resolveShareEntry(%q) = %q, %v (status %d), want a path in the share", tt.rel, got, ok, w.Code)
+			}
+			continue
+		}
+		if ok || w.Code != tt.want {
+			t.Errorf("resolveShareEntry(%q) = %q, %v, status %d; want status %d", tt.rel, got, ok, w.Code, tt.want)
+		}
+	}
+}
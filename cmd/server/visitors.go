@@ -0,0 +1,216 @@
+package main
+
+import (
+	"maps"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Wirezat/GoLog"
+	"github.com/Wirezat/fileshare/pkg/shared"
+)
+
+// visitorCookieMaxAge keeps visitors recognized across browser restarts.
+const visitorCookieMaxAge = 365 * 24 * time.Hour
+
+// visitCookiePrefix names the signed cookie that recognizes a visitor of a
+// share that counts uses per visitor. It is separate from the session
+// cookie of visitorCookiePrefix, which is neither signed nor long-lived.
+const visitCookiePrefix = "visit_"
+
+// visitorSeenInterval is how often a visitor who only browses has their
+// last visit saved; downloads are always saved.
+const visitorSeenInterval = time.Hour
+
+// maxTrackedVisitors bounds the visitors remembered for a share without a
+// visitor limit; beyond it, the ones seen longest ago are forgotten.
+const maxTrackedVisitors = 10000
+
+// visitorsMu serializes changes to the shares' visitor counters.
+var visitorsMu sync.Mutex
+
+// visitorOrErr admits r to a share that counts uses per visitor (see
+// shared.CountsVisitors). Visitors are recognized by a signed cookie, or
+// without one by their address, so clearing cookies doesn't make a new
+// visitor. New visitors are let in while the share has room for them.
+// If download is set, r downloads the file at rel, which counts against the
+// visitor's downloads of it. Visitors are remembered, and count against the
+// share's limit, from their first request on, so the limit applies to
+// browsing as well. It writes an error and returns false if the visitor is
+// turned away.
+func visitorOrErr(w http.ResponseWriter, r *http.Request, config *shared.Config, subpath, rel string, download bool) bool {
+	visitorsMu.Lock()
+	defer visitorsMu.Unlock()
+
+	// Counters are saved in copies of the config (see recordVisit),
+	// so the one r started with may be behind.
+	if current, err := shared.LoadConfig(); err == nil {
+		config = current
+	}
+	fd := config.Files[subpath]
+	ip := trustedClientIP(r)
+
+	id, fromCookie := "", false
+	if c, err := r.Cookie(visitCookiePrefix + subpath); err == nil {
+		id, fromCookie = shared.ParseVisitorCookie(config.LinkSecret, subpath, c.Value)
+	}
+	usage, known := fd.Visitors[id]
+	if !fromCookie {
+		id, known = visitorByIP(fd, ip)
+		usage = fd.Visitors[id]
+	}
+
+	if !known {
+		if fd.MaxVisitors > 0 && len(fd.Visitors) >= fd.MaxVisitors {
+			GoLog.Infof("share %s is full (%d visitors), turned away %s", subpath, fd.MaxVisitors, ip)
+			http.Error(w, "Forbidden: this share has reached its visitor limit", http.StatusForbidden)
+			return false
+		}
+		if !fromCookie {
+			var err error
+			if id, err = shared.NewVisitorID(); err != nil {
+				GoLog.Errorf("failed to create visitor ID: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return false
+			}
+		}
+	}
+
+	// Follow-up ranges of a download were counted with it.
+	scope := "visitor\x00" + subpath + "\x00" + id + "\x00" + rel
+	if download && isRangeContinuation(r, scope) {
+		download = false
+	}
+	if download && fd.MaxDownloadsPerFile > 0 && usage.Downloads[rel] >= fd.MaxDownloadsPerFile {
+		http.Error(w, "Gone: you have used up your downloads of this file", http.StatusGone)
+		return false
+	}
+	// Browsing alone is saved only now and then, not on every page.
+	if download || !known || time.Since(time.Unix(usage.LastSeen, 0)) >= visitorSeenInterval {
+		counted := ""
+		if download {
+			counted = rel
+		}
+		if !recordVisit(w, config, subpath, id, usage, counted, ip) {
+			return false
+		}
+		if !known {
+			GoLog.Infof("new visitor of share %s from %s (%d so far)", subpath, ip, len(fd.Visitors)+1)
+		}
+	}
+	if download {
+		grantRangeContinuations(r, scope)
+	}
+
+	if !fromCookie {
+		http.SetCookie(w, &http.Cookie{
+			Name:     visitCookiePrefix + subpath,
+			Value:    shared.VisitorCookieValue(config.LinkSecret, subpath, id),
+			Path:     "/" + subpath,
+			MaxAge:   int(visitorCookieMaxAge.Seconds()),
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+	return true
+}
+
+// recordVisit notes a visit by visitor id from ip, counts a download of rel
+// unless it is "", and saves the config. The cached config and its maps are shared
+// with every request, which may be reading them right now, so the changes
+// go into copies.
+func recordVisit(w http.ResponseWriter, config *shared.Config, subpath, id string, usage shared.VisitorUsage, rel, ip string) bool {
+	now := time.Now().Unix()
+	if usage.FirstSeen == 0 {
+		usage.FirstSeen = now
+	}
+	usage.IP, usage.LastSeen = ip, now
+	if rel != "" {
+		usage.Downloads = maps.Clone(usage.Downloads)
+		if usage.Downloads == nil {
+			usage.Downloads = make(map[string]int)
+		}
+		usage.Downloads[rel]++
+	}
+
+	fd := config.Files[subpath]
+	visitors := maps.Clone(fd.Visitors)
+	if visitors == nil {
+		visitors = make(map[string]shared.VisitorUsage)
+	}
+	visitors[id] = usage
+	if fd.MaxVisitors == 0 {
+		forgetOldestVisitors(visitors, maxTrackedVisitors)
+	}
+	fd.Visitors = visitors
+	next := *config
+	next.Files = maps.Clone(config.Files)
+	next.Files[subpath] = fd
+	if err := shared.SaveConfig(&next); err != nil {
+		GoLog.Errorf("failed to save config: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// forgetOldestVisitors drops the visitors seen longest ago until at most
+// keep are left.
+func forgetOldestVisitors(visitors map[string]shared.VisitorUsage, keep int) {
+	for len(visitors) > keep {
+		oldest, lastSeen := "", int64(0)
+		for id, usage := range visitors {
+			if oldest == "" || usage.LastSeen < lastSeen {
+				oldest, lastSeen = id, usage.LastSeen
+			}
+		}
+		delete(visitors, oldest)
+	}
+}
+
+// visitorByIP finds the visitor of a share last seen from ip.
+func visitorByIP(fd shared.FileData, ip string) (string, bool) {
+	id, lastSeen := "", int64(-1)
+	for vid, usage := range fd.Visitors {
+		if usage.IP == ip && usage.LastSeen > lastSeen {
+			id, lastSeen = vid, usage.LastSeen
+		}
+	}
+	return id, lastSeen >= 0
+}
+
+// visitorDownloadPath names what a download request gets for the
+// per-file counters: the file, or for archives the folder with a trailing
+// slash. ok is false if r downloads nothing.
+func visitorDownloadPath(r *http.Request, ctx *requestContext) (string, bool) {
+	if r.Method == http.MethodHead {
+		return "", false
+	}
+	rel := shareRelPath(ctx)
+	if ctx.fileInfo.IsDir() {
+		if r.URL.Query().Get("download") == "" {
+			return "", false
+		}
+		return strings.TrimSuffix(rel, "/") + "/", true
+	}
+	// Like for Uses, these count as opening the file's folder. Entries
+	// taken out of an archive are counted by serveArchiveBrowse, once it
+	// knows the path names a file.
+	if isManifestRequest(r) || isThumbnailRequest(r) || isArchiveBrowseRequest(r) {
+		return "", false
+	}
+	return rel, true
+}
+
+// shareRelPath returns the slash-separated path of the request's file or
+// folder within its share. For a single-file share, it is the file's name.
+func shareRelPath(ctx *requestContext) string {
+	rel := path.Clean("/" + filepath.ToSlash(strings.TrimPrefix(ctx.diskPath, ctx.fileData.Path)))
+	if rel == "/" && !ctx.fileInfo.IsDir() {
+		rel += path.Base(ctx.diskPath)
+	}
+	return rel
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Wirezat/fileshare/pkg/shared"
+)
+
+func TestVisitorDownloadPath(t *testing.T) {
+	root := writeTree(t, map[string]string{"a.txt": "a", "sub/b.zip": "b"})
+	tests := []struct {
+		share  string // the share's path, relative to root
+		target string // the requested path, relative to root
+		method string
+		query  string
+		want   string
+		wantOK bool
+	}{
+		{"", "a.txt", http.MethodGet, "", "/a.txt", true},
+		{"", "sub/b.zip", http.MethodGet, "", "/sub/b.zip", true},
+		{"", "a.txt", http.MethodHead, "", "", false},
+		{"", "a.txt", http.MethodGet, "manifest=sha256", "", false},
+		{"", "a.txt", http.MethodGet, "thumb=1", "", false},
+		{"", "sub/b.zip", http.MethodGet, "archive=x.txt", "", false},
+		{"", "", http.MethodGet, "", "", false},
+		{"", "", http.MethodGet, "download=zip", "/", true},
+		{"", "sub", http.MethodGet, "download=tar", "/sub/", true},
+		{"", "sub", http.MethodPost, "download=zip", "/sub/", true},
+		// A single-file share counts its file by name.
+		{"a.txt", "a.txt", http.MethodGet, "", "/a.txt", true},
+	}
+	for _, tt := range tests {
+		diskPath := filepath.Join(root, tt.target)
+		info, err := os.Stat(diskPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx := &requestContext{
+			fileData: shared.FileData{Path: filepath.Join(root, tt.share)},
+			diskPath: diskPath,
+			fileInfo: info,
+		}
+		r := httptest.NewRequest(tt.method, "/s/"+tt.target+"?"+tt.query, nil)
+		got, ok := visitorDownloadPath(r, ctx)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("%s /%s?%s = %q, %v, want %q, %v", tt.method, tt.target, tt.query, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Wirezat/GoLog"
+	"github.com/Wirezat/fileshare/pkg/shared"
+	"golang.org/x/net/webdav"
+)
+
+// davPrefix is where folder shares are mounted over WebDAV: /dav/<subpath>/.
+const davPrefix = "/dav/"
+
+// davClientIdle is how long a WebDAV client may stay quiet before its next
+// request counts as a new use of the share.
+const davClientIdle = time.Hour
+
+var (
+	davMu sync.Mutex
+	// davClients maps davClientID to the time of the client's last request.
+	davClients = map[string]time.Time{}
+	// davLocks holds the WebDAV locks of each share, in memory only.
+	davLocks = map[string]webdav.LockSystem{}
+)
+
+// handleWebDAV serves a folder share over WebDAV, checked like
+// prepareRequest and handleGet: the share must exist, be live and unlocked
+// (via Basic auth, as file managers can't use the gate), and every request
+// needs the permission its method stands for.
+// /dav/{subpath}/...
+func handleWebDAV(w http.ResponseWriter, r *http.Request) {
+	config, err := shared.LoadConfig()
+	if err != nil {
+		GoLog.Errorf("failed to load config: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	subpath := r.PathValue("subpath")
+	fd, exists := config.Files[subpath]
+	if !exists {
+		http.NotFound(w, r)
+		return
+	}
+
+	if shared.IsExpired(fd) {
+		if !fd.Expired {
+			fd.Expired = true
+			config.Files[subpath] = fd
+			if err := shared.SaveConfig(config); err != nil {
+				GoLog.Errorf("failed to save config after expiry: %v", err)
+			}
+		}
+		http.Error(w, "File share expired. Please ask your host to re-share it", http.StatusGone)
+		return
+	}
+
+	if !liveOrErr(w, r, subpath, fd) {
+		return
+	}
+
+	if fd.Password != "" && !hasBasicAuth(r, subpath, fd) {
+		requestBasicAuth(w, subpath)
+		return
+	}
+
+	if info, err := os.Stat(fd.Path); err != nil || !info.IsDir() {
+		http.Error(w, "Not Found: WebDAV is only available for folder shares", http.StatusNotFound)
+		return
+	}
+
+	client := davClientID(r, subpath)
+	if status, msg := davCheckRequest(r, subpath, fd, client); status != 0 {
+		http.Error(w, msg, status)
+		return
+	}
+
+	if shared.CountsVisitors(fd) {
+		// File managers rarely keep cookies, so visitors are mostly told
+		// apart by address here.
+		rel := path.Clean("/" + strings.TrimPrefix(r.URL.Path, davPrefix+subpath))
+		info, err := os.Stat(davDiskPath(fd.Path, rel))
+		download := r.Method == http.MethodGet && err == nil && !info.IsDir()
+		if !visitorOrErr(w, r, config, subpath, rel, download) {
+			return
+		}
+	} else if davNewClient(client) && fd.Uses > 0 {
+		fd.Uses--
+		if fd.Uses == 0 {
+			fd.Expired = true
+		}
+		config.Files[subpath] = fd
+		if err := shared.SaveConfig(config); err != nil {
+			GoLog.Errorf("failed to save config: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Cache-Control", shareCacheControl(fd))
+	handler := &webdav.Handler{
+		Prefix:     davPrefix + subpath,
+		FileSystem: davFS{Dir: webdav.Dir(fd.Path), root: fd.Path, client: client},
+		LockSystem: davLockSystem(subpath),
+		Logger: func(r *http.Request, err error) {
+			if err != nil {
+				GoLog.Debugf("webdav %s %s: %v", r.Method, r.URL.Path, err)
+			}
+		},
+	}
+	handler.ServeHTTP(w, r)
+}
+
+// davClientID identifies a WebDAV client of a share. File managers send no
+// cookies, so the address and user agent stand in for the browser session:
+// they decide what counts as a use and, for OwnFilesOnly, who created what.
+func davClientID(r *http.Request, subpath string) string {
+	return "dav\x00" + subpath + "\x00" + trustedClientIP(r) + "\x00" + r.UserAgent()
+}
+
+// davNewClient notes a request of client and reports whether it starts a
+// new visit, i.e. the client hasn't been heard from within davClientIdle.
+func davNewClient(client string) bool {
+	davMu.Lock()
+	defer davMu.Unlock()
+	last, seen := davClients[client]
+	davClients[client] = time.Now()
+	return !seen || time.Since(last) >= davClientIdle
+}
+
+func davLockSystem(subpath string) webdav.LockSystem {
+	davMu.Lock()
+	defer davMu.Unlock()
+	ls, ok := davLocks[subpath]
+	if !ok {
+		ls = webdav.NewMemLS()
+		davLocks[subpath] = ls
+	}
+	return ls
+}
+
+func startDAVReaper() {
+	go func() {
+		ticker := time.NewTicker(shareTokenReap)
+		defer ticker.Stop()
+		for range ticker.C {
+			davMu.Lock()
+			for k, t := range davClients {
+				if time.Since(t) >= davClientIdle {
+					delete(davClients, k)
+				}
+			}
+			davMu.Unlock()
+		}
+	}()
+}
+
+// davCheckRequest checks a WebDAV request against the share's permissions
+// before the WebDAV handler sees it, so refusals come back as a plain 403
+// rather than whatever status the handler picks for a failed operation.
+// It returns 0 if the request may go ahead.
+func davCheckRequest(r *http.Request, subpath string, fd shared.FileData, client string) (int, string) {
+	target := strings.TrimPrefix(r.URL.Path, davPrefix+subpath)
+	reads := slices.Contains([]string{http.MethodOptions, http.MethodGet, http.MethodHead, "PROPFIND"}, r.Method)
+	if isHiddenPath(target) {
+		if reads {
+			return http.StatusNotFound, "Not Found"
+		}
+		return http.StatusForbidden, "Forbidden"
+	}
+	denied := func(perm string) (int, string) {
+		return http.StatusForbidden, "Forbidden: this share does not allow " + perm
+	}
+	// owns applies FileData.OwnFilesOnly to changing the entry at rel.
+	owns := func(rel string) bool {
+		return !fd.OwnFilesOnly || isOwnedBy(davDiskPath(fd.Path, rel), client)
+	}
+	notOwned := "Forbidden: only files you uploaded can be changed"
+	// Listings may follow symlinks out of a share, but changes must not,
+	// whether the link is a parent folder or the entry itself.
+	escapes := func(rel string) bool {
+		diskPath := davDiskPath(fd.Path, rel)
+		if !resolvesWithinShare(fd.Path, filepath.Dir(diskPath)) || !linkWithinShare(fd.Path, diskPath) {
+			GoLog.Warnf("path traversal attempt: %s", diskPath)
+			return true
+		}
+		return false
+	}
+	if !reads && escapes(target) {
+		return http.StatusForbidden, "Forbidden"
+	}
+
+	switch r.Method {
+	case http.MethodOptions:
+		return 0, ""
+
+	case http.MethodGet, http.MethodHead, "PROPFIND":
+		if !shared.HasPermission(fd, shared.PermRead) {
+			return denied(shared.PermRead)
+		}
+
+	case http.MethodPut:
+		if !shared.HasPermission(fd, shared.PermUpload) {
+			return denied(shared.PermUpload)
+		}
+		// Overwriting destroys a file, so it takes the delete permission.
+		// Empty files are exempt: some clients create a file before writing it.
+		info, err := os.Stat(davDiskPath(fd.Path, target))
+		if err == nil && info.Size() > 0 {
+			if !shared.HasPermission(fd, shared.PermDelete) {
+				return denied(shared.PermDelete)
+			}
+			if !owns(target) {
+				return http.StatusForbidden, notOwned
+			}
+		}
+
+	case "MKCOL":
+		if !shared.HasPermission(fd, shared.PermMkdir) {
+			return denied(shared.PermMkdir)
+		}
+
+	case http.MethodDelete:
+		if !shared.HasPermission(fd, shared.PermDelete) {
+			return denied(shared.PermDelete)
+		}
+		if !owns(target) {
+			return http.StatusForbidden, notOwned
+		}
+		// As on the listing page, only empty folders can be deleted.
+		diskPath := davDiskPath(fd.Path, target)
+		if diskPath == fd.Path {
+			return http.StatusForbidden, "Forbidden"
+		}
+		if entries, err := os.ReadDir(diskPath); err == nil && len(entries) > 0 {
+			return http.StatusConflict, "Conflict: the folder is not empty"
+		}
+
+	case "COPY", "MOVE":
+		perm := shared.PermUpload
+		if r.Method == "MOVE" {
+			perm = shared.PermRename
+		}
+		if !shared.HasPermission(fd, perm) {
+			return denied(perm)
+		}
+		if r.Method == "MOVE" && (!owns(target) || davDiskPath(fd.Path, target) == fd.Path) {
+			return http.StatusForbidden, notOwned
+		}
+		dest, ok := davDestination(r, subpath)
+		if !ok {
+			// The WebDAV handler would resolve anything after /dav/<subpath>
+			// inside the share, so nothing unchecked may reach it.
+			return http.StatusForbidden, "Forbidden: the destination must be in this share"
+		}
+		if isHiddenPath(dest) || escapes(dest) || davDiskPath(fd.Path, dest) == fd.Path {
+			return http.StatusForbidden, "Forbidden"
+		}
+		if _, err := os.Stat(davDiskPath(fd.Path, dest)); err == nil && r.Header.Get("Overwrite") != "F" {
+			if !shared.HasPermission(fd, shared.PermDelete) {
+				return denied(shared.PermDelete)
+			}
+			if !owns(dest) {
+				return http.StatusForbidden, notOwned
+			}
+		}
+
+	default:
+		// LOCK, UNLOCK and PROPPATCH only make sense on a writable share.
+		if !slices.ContainsFunc(shared.EffectivePermissions(fd), func(p string) bool { return p != shared.PermRead }) {
+			return http.StatusForbidden, "Forbidden: this share is read-only"
+		}
+	}
+	return 0, ""
+}
+
+// davDestination returns the share-relative path of a COPY or MOVE
+// destination. ok is false unless the destination is a path below
+// /dav/<subpath>/ on the same host.
+func davDestination(r *http.Request, subpath string) (rel string, ok bool) {
+	dest := r.Header.Get("Destination")
+	u, err := url.Parse(dest)
+	if dest == "" || err != nil || (u.Host != "" && u.Host != r.Host) {
+		return "", false
+	}
+	rel, ok = strings.CutPrefix(u.Path, davPrefix+subpath+"/")
+	return "/" + rel, ok
+}
+
+// davDiskPath maps a share-relative WebDAV path to disk the way webdav.Dir
+// does.
+func davDiskPath(root, rel string) string {
+	return filepath.Join(root, filepath.FromSlash(path.Clean("/"+rel)))
+}
+
+// isHiddenPath reports whether any element of a slash-separated path is
+// hidden, i.e. starts with a dot.
+func isHiddenPath(p string) bool {
+	for elem := range strings.SplitSeq(p, "/") {
+		if strings.HasPrefix(elem, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// davFS is a share's folder as served over WebDAV. Hidden entries are left
+// out of listings like on the share page, and entries the client creates
+// are recorded for FileData.OwnFilesOnly.
+type davFS struct {
+	webdav.Dir
+	root   string
+	client string
+}
+
+func (d davFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	if err := d.Dir.Mkdir(ctx, name, perm); err != nil {
+		return err
+	}
+	recordOwned(davDiskPath(d.root, name), d.client)
+	return nil
+}
+
+func (d davFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	_, statErr := d.Dir.Stat(ctx, name)
+	f, err := d.Dir.OpenFile(ctx, name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	if flag&os.O_CREATE != 0 && errors.Is(statErr, fs.ErrNotExist) {
+		recordOwned(davDiskPath(d.root, name), d.client)
+	}
+	return davFile{f}, nil
+}
+
+func (d davFS) RemoveAll(ctx context.Context, name string) error {
+	if err := d.Dir.RemoveAll(ctx, name); err != nil {
+		return err
+	}
+	forgetOwned(davDiskPath(d.root, name))
+	return nil
+}
+
+func (d davFS) Rename(ctx context.Context, oldName, newName string) error {
+	if err := d.Dir.Rename(ctx, oldName, newName); err != nil {
+		return err
+	}
+	moveOwned(davDiskPath(d.root, oldName), davDiskPath(d.root, newName))
+	return nil
+}
+
+// davFile hides hidden entries from directory listings.
+type davFile struct {
+	webdav.File
+}
+
+func (f davFile) Readdir(count int) ([]fs.FileInfo, error) {
+	infos, err := f.File.Readdir(count)
+	return slices.DeleteFunc(infos, func(fi fs.FileInfo) bool {
+		return strings.HasPrefix(fi.Name(), ".")
+	}), err
+}
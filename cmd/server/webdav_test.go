@@ -0,0 +1,141 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Wirezat/fileshare/pkg/shared"
+)
+
+func TestDavCheckRequest(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"full.txt":  "content",
+		"empty.txt": "",
+		"dir/x.txt": "x",
+		"mine.txt":  "mine",
+	})
+	os.Mkdir(filepath.Join(root, "emptydir"), 0755)
+	outside := writeTree(t, map[string]string{"empty.txt": "", "full.txt": "secret"})
+	os.Symlink(filepath.Join(outside, "empty.txt"), filepath.Join(root, "out.txt"))
+	os.Symlink(filepath.Join(outside, "missing.txt"), filepath.Join(root, "dangling.txt"))
+	os.Symlink(outside, filepath.Join(root, "outdir"))
+	os.Symlink(filepath.Join(root, "empty.txt"), filepath.Join(root, "in.txt"))
+
+	const client = "dav\x00s\x00test"
+	recordOwned(filepath.Join(root, "mine.txt"), client)
+	t.Cleanup(func() { forgetOwned(filepath.Join(root, "mine.txt")) })
+
+	all := []string{shared.PermRead, shared.PermUpload, shared.PermMkdir, shared.PermRename, shared.PermDelete}
+	readOnly := []string{shared.PermRead}
+	upload := []string{shared.PermRead, shared.PermUpload}
+
+	tests := []struct {
+		name   string
+		perms  []string
+		own    bool // OwnFilesOnly
+		method string
+		target string
+		dest   string
+		want   int
+	}{
+		{"options", readOnly, false, http.MethodOptions, "/", "", 0},
+		{"get", readOnly, false, http.MethodGet, "/full.txt", "", 0},
+		{"propfind", readOnly, false, "PROPFIND", "/", "", 0},
+		{"get without read", []string{shared.PermUpload}, false, http.MethodGet, "/full.txt", "", http.StatusForbidden},
+		{"get hidden", all, false, http.MethodGet, "/.env", "", http.StatusNotFound},
+		{"put hidden", all, false, http.MethodPut, "/.env", "", http.StatusForbidden},
+		{"reads follow links out", readOnly, false, http.MethodGet, "/outdir/full.txt", "", 0},
+
+		{"put new", upload, false, http.MethodPut, "/new.txt", "", 0},
+		{"put read-only", readOnly, false, http.MethodPut, "/new.txt", "", http.StatusForbidden},
+		{"put over empty", upload, false, http.MethodPut, "/empty.txt", "", 0},
+		{"put over file", upload, false, http.MethodPut, "/full.txt", "", http.StatusForbidden},
+		{"put over file with delete", all, false, http.MethodPut, "/full.txt", "", 0},
+		{"put over others' file", all, true, http.MethodPut, "/full.txt", "", http.StatusForbidden},
+		{"put over own file", all, true, http.MethodPut, "/mine.txt", "", 0},
+		{"put through link out", all, false, http.MethodPut, "/out.txt", "", http.StatusForbidden},
+		{"put through dangling link", all, false, http.MethodPut, "/dangling.txt", "", http.StatusForbidden},
+		{"put into linked folder", all, false, http.MethodPut, "/outdir/new.txt", "", http.StatusForbidden},
+		{"put through link within", all, false, http.MethodPut, "/in.txt", "", 0},
+
+		{"mkcol", []string{shared.PermRead, shared.PermMkdir}, false, "MKCOL", "/new", "", 0},
+		{"mkcol without mkdir", upload, false, "MKCOL", "/new", "", http.StatusForbidden},
+
+		{"delete file", all, false, http.MethodDelete, "/full.txt", "", 0},
+		{"delete without delete", upload, false, http.MethodDelete, "/full.txt", "", http.StatusForbidden},
+		{"delete empty folder", all, false, http.MethodDelete, "/emptydir", "", 0},
+		{"delete full folder", all, false, http.MethodDelete, "/dir", "", http.StatusConflict},
+		{"delete share", all, false, http.MethodDelete, "/", "", http.StatusForbidden},
+		{"delete others' file", all, true, http.MethodDelete, "/full.txt", "", http.StatusForbidden},
+		{"delete own file", all, true, http.MethodDelete, "/mine.txt", "", 0},
+
+		{"copy", upload, false, "COPY", "/full.txt", "/dav/s/copy.txt", 0},
+		{"copy without upload", readOnly, false, "COPY", "/full.txt", "/dav/s/copy.txt", http.StatusForbidden},
+		{"copy over file", upload, false, "COPY", "/full.txt", "/dav/s/empty.txt", http.StatusForbidden},
+		{"copy to other share", all, false, "COPY", "/full.txt", "/dav/t/copy.txt", http.StatusForbidden},
+		{"copy to other host", all, false, "COPY", "/full.txt", "http://evil.example/dav/s/copy.txt", http.StatusForbidden},
+		{"copy without destination", all, false, "COPY", "/full.txt", "", http.StatusForbidden},
+		{"copy to hidden", all, false, "COPY", "/full.txt", "/dav/s/.env", http.StatusForbidden},
+		{"copy through link out", all, false, "COPY", "/full.txt", "/dav/s/out.txt", http.StatusForbidden},
+		{"copy into linked folder", all, false, "COPY", "/full.txt", "/dav/s/outdir/x.txt", http.StatusForbidden},
+		{"copy onto share", all, false, "COPY", "/full.txt", "/dav/s/", http.StatusForbidden},
+
+		{"move", []string{shared.PermRead, shared.PermRename}, false, "MOVE", "/full.txt", "/dav/s/moved.txt", 0},
+		{"move without rename", upload, false, "MOVE", "/full.txt", "/dav/s/moved.txt", http.StatusForbidden},
+		{"move share", all, false, "MOVE", "/", "/dav/s/moved", http.StatusForbidden},
+		{"move others' file", all, true, "MOVE", "/full.txt", "/dav/s/moved.txt", http.StatusForbidden},
+		{"move own file", all, true, "MOVE", "/mine.txt", "/dav/s/moved.txt", 0},
+
+		{"lock read-only", readOnly, false, "LOCK", "/full.txt", "", http.StatusForbidden},
+		{"lock writable", upload, false, "LOCK", "/full.txt", "", 0},
+	}
+	for _, tt := range tests {
+		fd := shared.FileData{Path: root, Permissions: tt.perms, OwnFilesOnly: tt.own}
+		r := httptest.NewRequest(tt.method, "http://example.com/dav/s"+tt.target, nil)
+		if tt.dest != "" {
+			r.Header.Set("Destination", tt.dest)
+		}
+		if got, msg := davCheckRequest(r, "s", fd, client); got != tt.want {
+			t.Errorf("%s: %s %s = %d %q, want %d", tt.name, tt.method, tt.target, got, msg, tt.want)
+		}
+	}
+
+	// A copy that may not overwrite fails by itself if the destination
+	// exists, so it needs no delete permission.
+	r := httptest.NewRequest("COPY", "http://example.com/dav/s/full.txt", nil)
+	r.Header.Set("Destination", "/dav/s/empty.txt")
+	r.Header.Set("Overwrite", "F")
+	fd := shared.FileData{Path: root, Permissions: upload}
+	if got, msg := davCheckRequest(r, "s", fd, client); got != 0 {
+		t.Errorf("COPY with Overwrite: F = %d %q, want 0", got, msg)
+	}
+}
+
+func TestDavDestination(t *testing.T) {
+	tests := []struct {
+		dest   string
+		want   string
+		wantOK bool
+	}{
+		{"/dav/s/a.txt", "/a.txt", true},
+		{"http://example.com/dav/s/dir/a.txt", "/dir/a.txt", true},
+		{"/dav/s/a%20b.txt", "/a b.txt", true},
+		{"", "", false},
+		{"http://other.example/dav/s/a.txt", "", false},
+		{"/dav/sx/a.txt", "", false},
+		{"/dav/s", "", false},
+		{"/s/a.txt", "", false},
+		{"/dav/t/a.txt", "", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("MOVE", "http://example.com/dav/s/x", nil)
+		r.Header.Set("Destination", tt.dest)
+		got, ok := davDestination(r, "s")
+		if ok != tt.wantOK || ok && got != tt.want {
+			t.Errorf("davDestination(%q) = %q, %v, want %q, %v", tt.dest, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
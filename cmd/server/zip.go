@@ -5,17 +5,22 @@ import (
 	"bufio"
 	"bytes"
 	"compress/flate"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"hash/crc32"
 	"io"
 	"net/http"
 	"os"
-	"path/filepath"
 	"runtime"
+	"slices"
+	"strings"
 	"sync"
-	"sync/atomic"
+	"time"
 
 	GoLog "github.com/Wirezat/GoLog"
+	"golang.org/x/sync/semaphore"
 )
 
 const (
@@ -48,16 +53,24 @@ type fileResult struct {
 	crc        uint32
 	rawSize    uint64
 	method     uint16
+	held       int64  // bytes reserved in the buffer semaphore
+	sha256     string // hex SHA-256 of the contents, if a manifest is embedded
 }
 
 // compressSmall reads a small file into RAM, compresses it, computes CRC32,
-// and picks the smaller representation (Store vs. Deflate).
-func compressSmall(job fileJob) (*fileResult, error) {
+// and picks the smaller representation (Store vs. Deflate). With withSum
+// set it also records the SHA-256 of the contents.
+func compressSmall(job fileJob, withSum bool) (*fileResult, error) {
 	raw, err := os.ReadFile(job.absPath)
 	if err != nil {
 		return nil, err
 	}
 	crc := crc32.ChecksumIEEE(raw)
+	var sum string
+	if withSum {
+		h := sha256.Sum256(raw)
+		sum = hex.EncodeToString(h[:])
+	}
 
 	var buf bytes.Buffer
 	buf.Grow(len(raw))
@@ -79,25 +92,39 @@ func compressSmall(job fileJob) (*fileResult, error) {
 		// Compressed is larger — use Store.
 		return &fileResult{
 			job: job, rawBytes: raw,
-			crc: crc, rawSize: uint64(len(raw)), method: zip.Store,
+			crc: crc, rawSize: uint64(len(raw)), method: zip.Store, sha256: sum,
 		}, nil
 	}
 	return &fileResult{
 		job: job, compressed: buf.Bytes(),
-		crc: crc, rawSize: uint64(len(raw)), method: zip.Deflate,
+		crc: crc, rawSize: uint64(len(raw)), method: zip.Deflate, sha256: sum,
 	}, nil
 }
 
-// zipAndServe streams a ZIP archive of dirPath directly to the HTTP client.
+// zipAndServe is the "zip" archiveWriter: zipPathsAndServe without a manifest.
+func zipAndServe(ctx context.Context, w http.ResponseWriter, archiveName, baseDir string, paths []string) {
+	zipPathsAndServe(ctx, w, archiveName, baseDir, paths, false)
+}
+
+// zipPathsAndServe streams a ZIP archive of the given files and directories.
+// Entry names are relative to baseDir, which must contain every path.
 //
 // Small files (≤ smallFileThreshold) are compressed in parallel by a worker pool
 // and written via CreateRaw — no double-compression. Large files are streamed
 // straight from disk by the ZIP writer. Backpressure keeps RAM usage bounded.
-func zipAndServe(w http.ResponseWriter, dirPath string) {
-	folderName := filepath.Base(filepath.Clean(dirPath))
+//
+// The walker, workers and writer all stop once ctx is done or a write to the
+// client fails, so a cancelled download releases its CPUs right away.
+//
+// With withManifest set, every file is also hashed as it is read and a
+// SHA256SUMS entry covering all files is appended at the end of the archive.
+func zipPathsAndServe(ctx context.Context, w http.ResponseWriter, archiveName, baseDir string, paths []string, withManifest bool) {
 	w.Header().Set("Content-Type", "application/zip")
 	w.Header().Set("Content-Disposition",
-		fmt.Sprintf(`attachment; filename="%s.zip"`, folderName))
+		fmt.Sprintf(`attachment; filename="%s.zip"`, archiveName))
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
 	numWorkers := runtime.NumCPU()
 	if numWorkers < 1 {
@@ -107,8 +134,9 @@ func zipAndServe(w http.ResponseWriter, dirPath string) {
 	jobs := make(chan fileJob, numWorkers*8)
 	results := make(chan *fileResult, numWorkers*8)
 
-	// Tracks bytes compressed in RAM but not yet consumed by the ZIP writer.
-	var bufferedBytes atomic.Int64
+	// Bounds bytes read into RAM but not yet consumed by the ZIP writer.
+	// Workers block in Acquire until the writer releases enough.
+	buffered := semaphore.NewWeighted(maxBufferedBytes)
 
 	var wg sync.WaitGroup
 	for range numWorkers {
@@ -116,30 +144,36 @@ func zipAndServe(w http.ResponseWriter, dirPath string) {
 		go func() {
 			defer wg.Done()
 			for job := range jobs {
+				if ctx.Err() != nil {
+					continue // drain so the walker can exit
+				}
 				if job.info.Size() > smallFileThreshold {
 					// Large file: send a marker and let the ZIP writer stream it.
-					results <- &fileResult{job: job}
+					select {
+					case results <- &fileResult{job: job}:
+					case <-ctx.Done():
+					}
 					continue
 				}
 
-				r, err := compressSmall(job)
+				// Reserve the raw size up front: it bounds both the file read
+				// into RAM and the compressed payload kept from it.
+				held := job.info.Size()
+				if err := buffered.Acquire(ctx, held); err != nil {
+					continue
+				}
+				r, err := compressSmall(job, withManifest)
 				if err != nil {
 					GoLog.Errorf("compress %s: %v", job.relPath, err)
+					buffered.Release(held)
 					continue
 				}
-
-				// Backpressure: block until the ZIP writer drains enough buffered data.
-				var payloadSize int64
-				if r.method == zip.Deflate {
-					payloadSize = int64(len(r.compressed))
-				} else {
-					payloadSize = int64(len(r.rawBytes))
-				}
-				for bufferedBytes.Load() > maxBufferedBytes {
-					runtime.Gosched()
+				r.held = held
+				select {
+				case results <- r:
+				case <-ctx.Done():
+					buffered.Release(held)
 				}
-				bufferedBytes.Add(payloadSize)
-				results <- r
 			}
 		}()
 	}
@@ -150,92 +184,139 @@ func zipAndServe(w http.ResponseWriter, dirPath string) {
 	}()
 
 	go func() {
-		_ = filepath.Walk(dirPath, func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				GoLog.Warnf("walk %s: %v", path, err)
-				return nil
-			}
-			if info.IsDir() {
-				return nil
-			}
-			rel, err := filepath.Rel(dirPath, path)
-			if err != nil {
-				GoLog.Warnf("rel path %s: %v", path, err)
+		defer close(jobs)
+		_ = walkRegularFiles(ctx, baseDir, paths, func(path, name string, info os.FileInfo) error {
+			select {
+			case jobs <- fileJob{absPath: path, relPath: name, info: info}:
 				return nil
+			case <-ctx.Done():
+				return ctx.Err()
 			}
-			jobs <- fileJob{absPath: path, relPath: rel, info: info}
-			return nil
 		})
-		close(jobs)
 	}()
 
 	// bufio.Writer batches small writes to reduce syscall overhead.
 	bw := bufio.NewWriterSize(w, httpWriteBufSize)
 	zw := zip.NewWriter(bw)
+	files := 0
+	var sums []manifestEntry
 
 	for r := range results {
-		hdr, err := zip.FileInfoHeader(r.job.info)
-		if err != nil {
-			GoLog.Errorf("file info header %s: %v", r.job.relPath, err)
+		if ctx.Err() != nil {
+			buffered.Release(r.held) // drain until the workers have exited
 			continue
 		}
-		hdr.Name = r.job.relPath
-
-		isLargeFile := r.compressed == nil && r.rawBytes == nil
-
-		if isLargeFile {
-			// Stream large file directly from disk — no RAM spike.
-			hdr.Method = zip.Deflate
-			fw, err := zw.CreateHeader(hdr)
-			if err != nil {
-				GoLog.Errorf("create header %s: %v", r.job.relPath, err)
-				continue
-			}
-			f, err := os.Open(r.job.absPath)
-			if err != nil {
-				GoLog.Errorf("open %s: %v", r.job.absPath, err)
-				continue
-			}
-			if _, err := io.Copy(fw, f); err != nil {
-				GoLog.Errorf("stream %s: %v", r.job.relPath, err)
+		if err := writeZipEntry(zw, bw, r, withManifest); err != nil {
+			GoLog.Warnf("zip %s aborted: %v", archiveName, err)
+			cancel()
+		} else if r.sha256 != "" || !withManifest {
+			files++
+			if withManifest {
+				sums = append(sums, manifestEntry{Path: r.job.relPath, Size: int64(r.rawSize), SHA256: r.sha256})
+				if int64(r.rawSize) == r.job.info.Size() {
+					sha256Cache.put(fileKeyOf(r.job.absPath, r.job.info), r.sha256)
+				}
 			}
-			f.Close()
-			_ = bw.Flush() // flush after each large file so the client sees progress
-			continue
 		}
+		buffered.Release(r.held)
+	}
 
-		// Write pre-compressed data via CreateRaw to avoid double-compression.
-		// CRC and sizes were already computed by compressSmall.
-		hdr.Method = r.method
-		hdr.CRC32 = r.crc
-		hdr.UncompressedSize64 = r.rawSize
-		hdr.Flags &^= 0x8 // clear data-descriptor flag; sizes are in the local header
-
-		var payload []byte
-		if r.method == zip.Deflate {
-			hdr.CompressedSize64 = uint64(len(r.compressed))
-			payload = r.compressed
-		} else {
-			hdr.CompressedSize64 = r.rawSize
-			payload = r.rawBytes
-		}
+	if ctx.Err() != nil {
+		GoLog.Infof("zip cancelled: %s after %d files", archiveName, files)
+		return
+	}
+	if withManifest {
+		writeZipManifest(zw, sums)
+	}
+	if err := zw.Close(); err != nil {
+		GoLog.Errorf("zip close: %v", err)
+	}
+	_ = bw.Flush()
+
+	GoLog.Infof("zip served: %s (%d files, %d workers)", archiveName, files, numWorkers)
+}
+
+// writeZipEntry adds one worker result to the archive. Problems with the
+// file itself are logged and skipped; errors writing to the client are
+// returned, since the download cannot continue after them.
+// With withSum set, large files are hashed while they stream and r.sha256
+// is filled in.
+func writeZipEntry(zw *zip.Writer, bw *bufio.Writer, r *fileResult, withSum bool) error {
+	hdr, err := zip.FileInfoHeader(r.job.info)
+	if err != nil {
+		GoLog.Errorf("file info header %s: %v", r.job.relPath, err)
+		return nil
+	}
+	hdr.Name = r.job.relPath
 
-		fw, err := zw.CreateRaw(hdr)
+	isLargeFile := r.compressed == nil && r.rawBytes == nil
+
+	if isLargeFile {
+		// Stream large file directly from disk — no RAM spike.
+		f, err := os.Open(r.job.absPath)
 		if err != nil {
-			GoLog.Errorf("create raw %s: %v", r.job.relPath, err)
-			bufferedBytes.Add(-int64(len(payload)))
-			continue
+			GoLog.Errorf("open %s: %v", r.job.absPath, err)
+			return nil
+		}
+		defer f.Close()
+		hdr.Method = zip.Deflate
+		fw, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return fmt.Errorf("create header %s: %w", r.job.relPath, err)
+		}
+		var dst io.Writer = fw
+		h := sha256.New()
+		if withSum {
+			dst = io.MultiWriter(fw, h)
+		}
+		n, err := io.Copy(dst, f)
+		if err != nil {
+			return fmt.Errorf("stream %s: %w", r.job.relPath, err)
 		}
-		if _, err := fw.Write(payload); err != nil {
-			GoLog.Errorf("write raw %s: %v", r.job.relPath, err)
+		if withSum {
+			r.sha256 = hex.EncodeToString(h.Sum(nil))
+			r.rawSize = uint64(n)
 		}
-		bufferedBytes.Add(-int64(len(payload)))
+		// Flush after each large file so the client sees progress.
+		return bw.Flush()
 	}
 
-	if err := zw.Close(); err != nil {
-		GoLog.Errorf("zip close: %v", err)
+	// Write pre-compressed data via CreateRaw to avoid double-compression.
+	// CRC and sizes were already computed by compressSmall.
+	hdr.Method = r.method
+	hdr.CRC32 = r.crc
+	hdr.UncompressedSize64 = r.rawSize
+	hdr.Flags &^= 0x8 // clear data-descriptor flag; sizes are in the local header
+
+	payload := r.rawBytes
+	if r.method == zip.Deflate {
+		payload = r.compressed
 	}
-	_ = bw.Flush()
+	hdr.CompressedSize64 = uint64(len(payload))
+
+	fw, err := zw.CreateRaw(hdr)
+	if err != nil {
+		return fmt.Errorf("create raw %s: %w", r.job.relPath, err)
+	}
+	if _, err := fw.Write(payload); err != nil {
+		return fmt.Errorf("write raw %s: %w", r.job.relPath, err)
+	}
+	return nil
+}
 
-	GoLog.Infof("zip served: %s (%d workers)", folderName, numWorkers)
+// writeZipManifest appends a SHA256SUMS entry listing sums. It is left out if
+// the archive already holds a file of that name at its root.
+func writeZipManifest(zw *zip.Writer, sums []manifestEntry) {
+	if slices.ContainsFunc(sums, func(e manifestEntry) bool { return e.Path == manifestName }) {
+		GoLog.Warnf("zip: not embedding %s, a file of that name is already in the archive", manifestName)
+		return
+	}
+	slices.SortFunc(sums, func(a, b manifestEntry) int { return strings.Compare(a.Path, b.Path) })
+
+	fw, err := zw.CreateHeader(&zip.FileHeader{Name: manifestName, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		GoLog.Errorf("zip manifest: %v", err)
+		return
+	}
+	writeSHA256SUMS(fw, sums)
 }
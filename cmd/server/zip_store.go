@@ -0,0 +1,423 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"time"
+
+	GoLog "github.com/Wirezat/GoLog"
+)
+
+// Store-mode ZIPs are laid out entirely from a directory snapshot: entries are
+// sorted by name and stored uncompressed, so every byte offset is known before
+// the first byte is sent. That gives the download a Content-Length and lets
+// http.ServeContent answer Range requests, so interrupted downloads resume.
+//
+// Local headers carry the CRC32 of each file, which is computed the first time
+// the header is needed and cached by path, size and mtime. Nothing about the
+// layout depends on the CRCs, only the bytes of the headers do.
+
+const (
+	zipLocalHeaderLen   = 30
+	zipCentralHeaderLen = 46
+	zipEndLen           = 22
+	zip64EndLen         = 56
+	zip64LocatorLen     = 20
+
+	zipVersion20  = 20
+	zipVersion45  = 45 // required for Zip64 entries
+	zipMadeByUnix = 3 << 8
+	zipFlagUTF8   = 0x800
+	zip64ExtraID  = 0x0001
+	uint16Max     = 0xFFFF
+	uint32Max     = 0xFFFFFFFF
+)
+
+// storeEntry is one file in a store-mode archive.
+type storeEntry struct {
+	absPath string
+	name    string // slash-separated, relative to the archive root
+	size    int64
+	modTime time.Time
+	mode    os.FileMode
+	offset  int64 // of the local header
+}
+
+func (e *storeEntry) zip64Size() bool   { return e.size >= uint32Max }
+func (e *storeEntry) zip64Offset() bool { return e.offset >= uint32Max }
+
+func (e *storeEntry) localExtraLen() int {
+	if e.zip64Size() {
+		return 4 + 16
+	}
+	return 0
+}
+
+func (e *storeEntry) centralExtraLen() int {
+	n := 0
+	if e.zip64Size() {
+		n += 16
+	}
+	if e.zip64Offset() {
+		n += 8
+	}
+	if n == 0 {
+		return 0
+	}
+	return 4 + n
+}
+
+func (e *storeEntry) dataOffset() int64 {
+	return e.offset + zipLocalHeaderLen + int64(len(e.name)) + int64(e.localExtraLen())
+}
+
+// storeZip is a read-only, seekable view of a store-mode archive.
+type storeZip struct {
+	// ctx is the request's context. storeZip is read through
+	// http.ServeContent, so it can't be passed in per call.
+	ctx context.Context
+
+	entries  []storeEntry
+	cdOffset int64
+	cdSize   int64
+	size     int64
+	modTime  time.Time
+
+	pos  int64
+	tail []byte // central directory and end records, built on first use
+
+	cur  int // index of the entry whose file is open, or -1
+	file *os.File
+}
+
+// newStoreZip walks paths and lays out the archive. Entry names are relative
+// to baseDir. Symlinks to files are followed; directories themselves are not
+// stored, matching the compressed ZIP. The walk, and later the CRCs, stop
+// with an error once ctx is done.
+func newStoreZip(ctx context.Context, baseDir string, paths []string) (*storeZip, error) {
+	z := &storeZip{ctx: ctx, cur: -1}
+	err := walkRegularFiles(ctx, baseDir, paths, func(path, name string, info os.FileInfo) error {
+		z.entries = append(z.entries, storeEntry{
+			absPath: path,
+			name:    name,
+			size:    info.Size(),
+			modTime: info.ModTime(),
+			mode:    info.Mode(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(z.entries, func(a, b storeEntry) int { return cmp.Compare(a.name, b.name) })
+
+	var off int64
+	for i := range z.entries {
+		e := &z.entries[i]
+		e.offset = off
+		off = e.dataOffset() + e.size
+		if e.modTime.After(z.modTime) {
+			z.modTime = e.modTime
+		}
+	}
+	z.cdOffset = off
+	for i := range z.entries {
+		e := &z.entries[i]
+		z.cdSize += zipCentralHeaderLen + int64(len(e.name)) + int64(e.centralExtraLen())
+	}
+	z.size = z.cdOffset + z.cdSize + zipEndLen
+	if z.needsZip64End() {
+		z.size += zip64EndLen + zip64LocatorLen
+	}
+	return z, nil
+}
+
+func (z *storeZip) needsZip64End() bool {
+	return len(z.entries) >= uint16Max || z.cdSize >= uint32Max || z.cdOffset >= uint32Max
+}
+
+// etag identifies the exact byte layout, so If-Range only resumes against
+// an unchanged snapshot.
+func (z *storeZip) etag() string {
+	h := sha256.New()
+	for _, e := range z.entries {
+		fmt.Fprintf(h, "%s\x00%d\x00%d\x00%o\n", e.name, e.size, e.modTime.UnixNano(), e.mode.Perm())
+	}
+	return `"zs-` + hex.EncodeToString(h.Sum(nil)[:12]) + `"`
+}
+
+func (z *storeZip) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += z.pos
+	case io.SeekEnd:
+		offset += z.size
+	default:
+		return 0, errors.New("storeZip: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("storeZip: negative position")
+	}
+	z.pos = offset
+	return offset, nil
+}
+
+func (z *storeZip) Read(p []byte) (int, error) {
+	if z.pos >= z.size {
+		return 0, io.EOF
+	}
+	var n int
+	var err error
+	if z.pos >= z.cdOffset {
+		if z.tail == nil {
+			if z.tail, err = z.buildTail(); err != nil {
+				return 0, err
+			}
+		}
+		n = copy(p, z.tail[z.pos-z.cdOffset:])
+	} else {
+		n, err = z.readEntry(p)
+	}
+	z.pos += int64(n)
+	return n, err
+}
+
+// readEntry reads from the local header or data of the entry at z.pos.
+func (z *storeZip) readEntry(p []byte) (int, error) {
+	i := sort.Search(len(z.entries), func(i int) bool { return z.entries[i].offset > z.pos }) - 1
+	e := &z.entries[i]
+
+	if z.pos < e.dataOffset() {
+		hdr, err := z.localHeader(e)
+		if err != nil {
+			return 0, err
+		}
+		return copy(p, hdr[z.pos-e.offset:]), nil
+	}
+
+	if z.cur != i {
+		z.closeFile()
+		f, err := os.Open(e.absPath)
+		if err != nil {
+			return 0, err
+		}
+		z.file, z.cur = f, i
+	}
+	rel := z.pos - e.dataOffset()
+	n, err := z.file.ReadAt(p[:int(min(int64(len(p)), e.size-rel))], rel)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	if err == io.EOF {
+		// The file shrank since the snapshot — the layout no longer holds.
+		err = fmt.Errorf("%s changed during download", e.name)
+	}
+	return n, err
+}
+
+func (z *storeZip) closeFile() {
+	if z.file != nil {
+		z.file.Close()
+		z.file, z.cur = nil, -1
+	}
+}
+
+// Close releases the currently open file, if any.
+func (z *storeZip) Close() error {
+	z.closeFile()
+	return nil
+}
+
+func (z *storeZip) localHeader(e *storeEntry) ([]byte, error) {
+	crc, err := cachedCRC(e)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	b.Grow(zipLocalHeaderLen + len(e.name) + e.localExtraLen())
+	le := func(v any) { binary.Write(&b, binary.LittleEndian, v) }
+
+	version, size32 := uint16(zipVersion20), uint32(e.size)
+	if e.zip64Size() {
+		version, size32 = zipVersion45, uint32Max
+	}
+	dosDate, dosTime := msDosTime(e.modTime)
+	le(uint32(0x04034b50))
+	le(version)
+	le(uint16(zipFlagUTF8))
+	le(uint16(0)) // method: store
+	le(dosTime)
+	le(dosDate)
+	le(crc)
+	le(size32) // compressed
+	le(size32) // uncompressed
+	le(uint16(len(e.name)))
+	le(uint16(e.localExtraLen()))
+	b.WriteString(e.name)
+	if e.zip64Size() {
+		le(uint16(zip64ExtraID))
+		le(uint16(16))
+		le(uint64(e.size))
+		le(uint64(e.size))
+	}
+	return b.Bytes(), nil
+}
+
+// buildTail renders the central directory and end records. All CRCs are
+// needed here, so any not computed while streaming are computed now, which
+// for a resumed download on a cold cache means reading the whole tree; it
+// stops once the client is gone.
+func (z *storeZip) buildTail() ([]byte, error) {
+	var b bytes.Buffer
+	b.Grow(int(z.size - z.cdOffset))
+	le := func(v any) { binary.Write(&b, binary.LittleEndian, v) }
+
+	for i := range z.entries {
+		if err := z.ctx.Err(); err != nil {
+			return nil, err
+		}
+		e := &z.entries[i]
+		crc, err := cachedCRC(e)
+		if err != nil {
+			return nil, err
+		}
+		version, size32, off32 := uint16(zipVersion20), uint32(e.size), uint32(e.offset)
+		if e.zip64Size() {
+			size32 = uint32Max
+		}
+		if e.zip64Offset() {
+			off32 = uint32Max
+		}
+		if e.centralExtraLen() > 0 {
+			version = zipVersion45
+		}
+		dosDate, dosTime := msDosTime(e.modTime)
+		le(uint32(0x02014b50))
+		le(uint16(zipMadeByUnix | zipVersion45))
+		le(version)
+		le(uint16(zipFlagUTF8))
+		le(uint16(0)) // method: store
+		le(dosTime)
+		le(dosDate)
+		le(crc)
+		le(size32)
+		le(size32)
+		le(uint16(len(e.name)))
+		le(uint16(e.centralExtraLen()))
+		le(uint16(0))                            // comment length
+		le(uint16(0))                            // disk number start
+		le(uint16(0))                            // internal attributes
+		le(uint32(0o100000|e.mode.Perm()) << 16) // external attributes: unix regular file
+		le(off32)
+		b.WriteString(e.name)
+		if n := e.centralExtraLen(); n > 0 {
+			le(uint16(zip64ExtraID))
+			le(uint16(n - 4))
+			if e.zip64Size() {
+				le(uint64(e.size))
+				le(uint64(e.size))
+			}
+			if e.zip64Offset() {
+				le(uint64(e.offset))
+			}
+		}
+	}
+
+	count16, cdSize32, cdOffset32 := uint16(len(z.entries)), uint32(z.cdSize), uint32(z.cdOffset)
+	if z.needsZip64End() {
+		end64 := z.cdOffset + z.cdSize
+		le(uint32(0x06064b50))
+		le(uint64(zip64EndLen - 12))
+		le(uint16(zipMadeByUnix | zipVersion45))
+		le(uint16(zipVersion45))
+		le(uint32(0)) // this disk
+		le(uint32(0)) // disk with central directory
+		le(uint64(len(z.entries)))
+		le(uint64(len(z.entries)))
+		le(uint64(z.cdSize))
+		le(uint64(z.cdOffset))
+
+		le(uint32(0x07064b50))
+		le(uint32(0))
+		le(uint64(end64))
+		le(uint32(1)) // total disks
+
+		count16, cdSize32, cdOffset32 = uint16Max, uint32Max, uint32Max
+	}
+	le(uint32(0x06054b50))
+	le(uint16(0))
+	le(uint16(0))
+	le(count16)
+	le(count16)
+	le(cdSize32)
+	le(cdOffset32)
+	le(uint16(0)) // comment length
+
+	if int64(b.Len()) != z.size-z.cdOffset {
+		return nil, fmt.Errorf("central directory is %d bytes, expected %d", b.Len(), z.size-z.cdOffset)
+	}
+	return b.Bytes(), nil
+}
+
+// msDosTime converts t to the MS-DOS date and time fields, clamped to the
+// format's 1980–2107 range.
+func msDosTime(t time.Time) (date, tm uint16) {
+	switch {
+	case t.Year() < 1980:
+		t = time.Date(1980, 1, 1, 0, 0, 0, 0, time.Local)
+	case t.Year() > 2107:
+		t = time.Date(2107, 12, 31, 23, 59, 58, 0, time.Local)
+	}
+	date = uint16(t.Day() + int(t.Month())<<5 + (t.Year()-1980)<<9)
+	tm = uint16(t.Second()/2 + t.Minute()<<5 + t.Hour()<<11)
+	return date, tm
+}
+
+// cachedCRC returns the CRC32 of e's file, reading it only on a cache miss.
+// A file whose size or mtime no longer matches the snapshot is an error,
+// since its header would not match the data that follows.
+func cachedCRC(e *storeEntry) (uint32, error) {
+	key := fileKey{e.absPath, e.size, e.modTime.UnixNano()}
+	if crc, ok := crcCache.get(key); ok {
+		return crc, nil
+	}
+	h := crc32.NewIEEE()
+	if err := hashFile(key, h); err != nil {
+		if errors.Is(err, errFileChanged) {
+			return 0, fmt.Errorf("%s changed during download", e.name)
+		}
+		return 0, err
+	}
+	crc := h.Sum32()
+	crcCache.put(key, crc)
+	return crc, nil
+}
+
+// serveStoreZip serves a store-mode ZIP, laid out by newStoreZip, with
+// Content-Length and Range support.
+func serveStoreZip(w http.ResponseWriter, r *http.Request, archiveName string, z *storeZip) {
+	defer z.Close()
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="%s.zip"`, archiveName))
+	w.Header().Set("ETag", z.etag())
+
+	start := time.Now()
+	http.ServeContent(w, r, "", z.modTime, z)
+	GoLog.Infof("store zip served: %s (%d files, %d bytes, range %q, %s)",
+		archiveName, len(z.entries), z.size, r.Header.Get("Range"), time.Since(start).Round(time.Millisecond))
+}
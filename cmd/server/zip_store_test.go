@@ -0,0 +1,203 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var storeZipFiles = map[string]string{
+	"b.txt":           "second file",
+	"a.txt":           "first file, a bit longer than the second",
+	"sub/c.bin":       "\x00\x01\x02\x03",
+	"sub/deeper/d.md": "# d",
+	"empty.txt":       "",
+	".hidden":         "left out",
+}
+
+func readStoreZip(t *testing.T, z *storeZip) []byte {
+	t.Helper()
+	if _, err := z.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(z)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestStoreZipLayout(t *testing.T) {
+	root := writeTree(t, storeZipFiles)
+	z, err := newStoreZip(context.Background(), root, []string{root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+
+	data := readStoreZip(t, z)
+	if int64(len(data)) != z.size {
+		t.Fatalf("read %d bytes, layout says %d", len(data), z.size)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a.txt", "b.txt", "empty.txt", "sub/c.bin", "sub/deeper/d.md"}
+	if len(zr.File) != len(want) {
+		t.Fatalf("archive has %d entries, want %d", len(zr.File), len(want))
+	}
+	for i, f := range zr.File {
+		if f.Name != want[i] {
+			t.Errorf("entry %d = %q, want %q", i, f.Name, want[i])
+		}
+		if f.Method != zip.Store {
+			t.Errorf("%s: method %d, want store", f.Name, f.Method)
+		}
+		// Open checks the CRC in the header against the data.
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("%s: %v", f.Name, err)
+		}
+		got, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Errorf("%s: %v", f.Name, err)
+		}
+		if string(got) != storeZipFiles[f.Name] {
+			t.Errorf("%s = %q, want %q", f.Name, got, storeZipFiles[f.Name])
+		}
+		// The local header sits where the layout put it.
+		off, err := f.DataOffset()
+		if err != nil || off != z.entries[i].dataOffset() {
+			t.Errorf("%s: data at %d (%v), layout says %d", f.Name, off, err, z.entries[i].dataOffset())
+		}
+	}
+}
+
+func TestStoreZipSeek(t *testing.T) {
+	root := writeTree(t, storeZipFiles)
+	z, err := newStoreZip(context.Background(), root, []string{root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+	full := readStoreZip(t, z)
+
+	// Reads from any position match the whole archive, whether they start
+	// in a header, in file data or in the central directory.
+	for _, start := range []int64{0, 1, z.entries[1].offset, z.entries[1].dataOffset() + 3, z.cdOffset - 1, z.cdOffset, z.size - 5} {
+		if _, err := z.Seek(start, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(z)
+		if err != nil {
+			t.Fatalf("read from %d: %v", start, err)
+		}
+		if !bytes.Equal(got, full[start:]) {
+			t.Errorf("read from %d doesn't match the archive", start)
+		}
+	}
+	if pos, err := z.Seek(-10, io.SeekEnd); err != nil || pos != z.size-10 {
+		t.Errorf("Seek(-10, end) = %d, %v, want %d", pos, err, z.size-10)
+	}
+	if _, err := z.Seek(-1, io.SeekStart); err == nil {
+		t.Error("Seek to a negative position: want an error")
+	}
+}
+
+func TestServeStoreZipRange(t *testing.T) {
+	root := writeTree(t, storeZipFiles)
+	serve := func(header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/s?download=zip&mode=store", nil)
+		r.Header = header
+		z, err := newStoreZip(r.Context(), root, []string{root})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		serveStoreZip(w, r, "s", z)
+		return w
+	}
+
+	whole := serve(http.Header{})
+	if whole.Code != http.StatusOK || whole.Header().Get("Content-Length") == "" || whole.Header().Get("ETag") == "" {
+		t.Fatalf("full download: %d, headers %v", whole.Code, whole.Header())
+	}
+	full := whole.Body.Bytes()
+	etag := whole.Header().Get("ETag")
+
+	tests := []struct {
+		name     string
+		header   http.Header
+		wantCode int
+		want     []byte
+	}{
+		{"middle", http.Header{"Range": {"bytes=10-99"}}, http.StatusPartialContent, full[10:100]},
+		{"resume", http.Header{"Range": {"bytes=50-"}}, http.StatusPartialContent, full[50:]},
+		{"last bytes", http.Header{"Range": {"bytes=-22"}}, http.StatusPartialContent, full[len(full)-22:]},
+		{"matching If-Range", http.Header{"Range": {"bytes=50-"}, "If-Range": {etag}}, http.StatusPartialContent, full[50:]},
+		{"stale If-Range", http.Header{"Range": {"bytes=50-"}, "If-Range": {`"zs-other"`}}, http.StatusOK, full},
+		{"past the end", http.Header{"Range": {"bytes=99999-"}}, http.StatusRequestedRangeNotSatisfiable, nil},
+	}
+	for _, tt := range tests {
+		w := serve(tt.header)
+		if w.Code != tt.wantCode {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.wantCode)
+			continue
+		}
+		if tt.want != nil && !bytes.Equal(w.Body.Bytes(), tt.want) {
+			t.Errorf("%s: body doesn't match the archive", tt.name)
+		}
+	}
+}
+
+func TestStoreZipETag(t *testing.T) {
+	root := writeTree(t, map[string]string{"a.txt": "a"})
+	etag := func() string {
+		z, err := newStoreZip(context.Background(), root, []string{root})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return z.etag()
+	}
+	before := etag()
+	if etag() != before {
+		t.Error("ETag changed without a change to the folder")
+	}
+	later := time.Now().Add(time.Hour)
+	os.Chtimes(filepath.Join(root, "a.txt"), later, later)
+	if etag() == before {
+		t.Error("ETag didn't change with a file's modification time")
+	}
+}
+
+func TestStoreZipCancelled(t *testing.T) {
+	root := writeTree(t, storeZipFiles)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := newStoreZip(ctx, root, []string{root}); err == nil {
+		t.Error("newStoreZip with a cancelled context: want an error")
+	}
+
+	// A client that leaves mid-download stops the CRCs for the central
+	// directory as well.
+	ctx, cancel = context.WithCancel(context.Background())
+	z, err := newStoreZip(ctx, root, []string{root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	z.Seek(z.cdOffset, io.SeekStart)
+	if _, err := io.ReadAll(z); err == nil {
+		t.Error("reading the central directory after the client left: want an error")
+	}
+}
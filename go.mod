@@ -4,4 +4,15 @@ go 1.25.0
 
 require github.com/Wirezat/GoLog v0.0.0-20260403110615-1539104ddbb7
 
-require golang.org/x/crypto v0.49.0
+require (
+	github.com/alecthomas/chroma/v2 v2.27.0
+	github.com/klauspost/compress v1.20.1
+	github.com/klauspost/pgzip v1.2.7
+	github.com/yuin/goldmark v1.8.6
+	golang.org/x/crypto v0.49.0
+	golang.org/x/image v0.45.0
+	golang.org/x/net v0.52.0
+	golang.org/x/sync v0.20.0
+)
+
+require github.com/dlclark/regexp2/v2 v2.2.1 // indirect
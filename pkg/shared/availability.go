@@ -0,0 +1,257 @@
+package shared
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // time zones of schedules work without a system zoneinfo
+)
+
+// Availability is a weekly schedule of when a share can be reached, parsed
+// from FileData.Availability with ParseAvailability. Its text form is a
+// list of windows separated by ";", each of days and a time range:
+//
+//	mon-fri 09:00-17:00; sat 10:00-14:00
+//
+// Days are mon…sun, listed with "," and ranged with "-", or "daily". Either
+// part may be left out: days alone are open all day, a time range alone is
+// open every day. A range ending before it starts runs past midnight and
+// belongs to the day it starts on, e.g. "fri 22:00-02:00".
+type Availability struct {
+	Windows  []AvailabilityWindow
+	Location *time.Location
+}
+
+// AvailabilityWindow is one opening time of an Availability.
+type AvailabilityWindow struct {
+	Days  [7]bool // indexed by time.Weekday
+	Start int     // minutes after midnight
+	End   int     // minutes after midnight; past 24h for windows that run over
+}
+
+var dayNames = [7]string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+const minutesPerDay = 24 * 60
+
+// ParseAvailability parses a share's availability schedule in the time zone
+// zone, an IANA name such as "Europe/Berlin". An empty zone is the server's.
+func ParseAvailability(spec, zone string) (*Availability, error) {
+	loc := time.Local
+	if zone != "" {
+		var err error
+		if loc, err = time.LoadLocation(zone); err != nil {
+			return nil, fmt.Errorf("unknown time zone %q", zone)
+		}
+	}
+	a := &Availability{Location: loc}
+	for part := range strings.SplitSeq(spec, ";") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		w, err := parseWindow(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid window %q: %w", part, err)
+		}
+		a.Windows = append(a.Windows, w)
+	}
+	if len(a.Windows) == 0 {
+		return nil, errors.New("availability needs at least one window, e.g. mon-fri 09:00-17:00")
+	}
+	return a, nil
+}
+
+func parseWindow(s string) (AvailabilityWindow, error) {
+	w := AvailabilityWindow{End: minutesPerDay}
+	fields := strings.Fields(s)
+	if len(fields) > 2 {
+		return w, errors.New("expected days and a time range, e.g. mon-fri 09:00-17:00")
+	}
+	days, times := "daily", ""
+	for _, f := range fields {
+		if f[0] >= '0' && f[0] <= '9' {
+			times = f
+		} else {
+			days = f
+		}
+	}
+	if err := parseDays(strings.ToLower(days), &w.Days); err != nil {
+		return w, err
+	}
+	if times == "" {
+		return w, nil
+	}
+	from, until, ok := strings.Cut(times, "-")
+	if !ok {
+		return w, fmt.Errorf("time range %q needs a start and an end, e.g. 09:00-17:00", times)
+	}
+	var err error
+	if w.Start, err = parseClock(from); err != nil {
+		return w, err
+	}
+	if w.End, err = parseClock(until); err != nil {
+		return w, err
+	}
+	if w.Start == minutesPerDay {
+		return w, errors.New("a window can't start at 24:00")
+	}
+	if w.End <= w.Start {
+		w.End += minutesPerDay
+	}
+	return w, nil
+}
+
+func parseDays(s string, days *[7]bool) error {
+	if s == "daily" {
+		for i := range days {
+			days[i] = true
+		}
+		return nil
+	}
+	for item := range strings.SplitSeq(s, ",") {
+		first, last, isRange := strings.Cut(item, "-")
+		from, err := parseDay(first)
+		if err != nil {
+			return err
+		}
+		to := from
+		if isRange {
+			if to, err = parseDay(last); err != nil {
+				return err
+			}
+		}
+		for d := from; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == to {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// parseDay parses a day's name, abbreviated to at least three letters.
+func parseDay(s string) (int, error) {
+	if len(s) >= 3 {
+		for i := range dayNames {
+			if strings.HasPrefix(strings.ToLower(time.Weekday(i).String()), s) {
+				return i, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("unknown day %q — use mon, tue, wed, thu, fri, sat, sun or daily", s)
+}
+
+// parseClock parses "HH:MM" into minutes after midnight; "24:00" is allowed
+// as the end of a day.
+func parseClock(s string) (int, error) {
+	h, m, ok := strings.Cut(s, ":")
+	hours, errH := strconv.Atoi(h)
+	minutes, errM := strconv.Atoi(m)
+	if !ok || errH != nil || errM != nil || hours < 0 || minutes < 0 || minutes > 59 ||
+		hours > 24 || (hours == 24 && minutes != 0) {
+		return 0, fmt.Errorf("invalid time %q — use HH:MM", s)
+	}
+	return hours*60 + minutes, nil
+}
+
+// String returns the schedule in the text form ParseAvailability reads,
+// with days written as compactly as possible.
+func (a *Availability) String() string {
+	parts := make([]string, len(a.Windows))
+	for i, w := range a.Windows {
+		parts[i] = w.String()
+	}
+	return strings.Join(parts, "; ")
+}
+
+func (w AvailabilityWindow) String() string {
+	s := formatDays(w.Days)
+	if w.Start == 0 && w.End == minutesPerDay {
+		return s
+	}
+	end := fmt.Sprintf("%02d:%02d", w.End/60%24, w.End%60)
+	if w.End == minutesPerDay {
+		end = "24:00"
+	}
+	return fmt.Sprintf("%s %02d:%02d-%s", s, w.Start/60, w.Start%60, end)
+}
+
+// formatDays writes days as runs from Monday on, e.g. "mon-fri,sun".
+func formatDays(days [7]bool) string {
+	if days == [7]bool{true, true, true, true, true, true, true} {
+		return "daily"
+	}
+	var runs []string
+	for i := 0; i < 7; i++ {
+		d := (i + 1) % 7 // Monday first
+		if !days[d] {
+			continue
+		}
+		j := i
+		for j+1 < 7 && days[(j+2)%7] {
+			j++
+		}
+		if j > i {
+			runs = append(runs, dayNames[d]+"-"+dayNames[(j+1)%7])
+		} else {
+			runs = append(runs, dayNames[d])
+		}
+		i = j
+	}
+	return strings.Join(runs, ",")
+}
+
+// IsOpen reports whether the schedule is open at t.
+func (a *Availability) IsOpen(t time.Time) bool {
+	t = t.In(a.Location)
+	now := t.Hour()*60 + t.Minute()
+	today, yesterday := t.Weekday(), (t.Weekday()+6)%7
+	for _, w := range a.Windows {
+		if w.Days[today] && now >= w.Start && now < w.End {
+			return true
+		}
+		// The part of yesterday's window that runs past midnight.
+		if w.Days[yesterday] && now+minutesPerDay < w.End {
+			return true
+		}
+	}
+	return false
+}
+
+// NextOpen returns when the schedule opens next after t. It returns t if the
+// schedule is open at t.
+func (a *Availability) NextOpen(t time.Time) time.Time {
+	if a.IsOpen(t) {
+		return t
+	}
+	local := t.In(a.Location)
+	var next time.Time
+	for day := range 8 {
+		date := local.AddDate(0, 0, day)
+		for _, w := range a.Windows {
+			if !w.Days[date.Weekday()] {
+				continue
+			}
+			start := time.Date(date.Year(), date.Month(), date.Day(), w.Start/60, w.Start%60, 0, 0, a.Location)
+			if start.After(t) && (next.IsZero() || start.Before(next)) {
+				next = start
+			}
+		}
+		if !next.IsZero() {
+			return next
+		}
+	}
+	return next
+}
+
+// IsAvailable reports whether a share's schedule, if it has one, is open
+// at t. Shares with a broken schedule are never available.
+func IsAvailable(fd FileData, t time.Time) bool {
+	if fd.Availability == "" {
+		return true
+	}
+	a, err := ParseAvailability(fd.Availability, fd.TimeZone)
+	return err == nil && a.IsOpen(t)
+}
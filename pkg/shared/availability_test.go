@@ -0,0 +1,228 @@
+package shared
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseAvailability(t *testing.T) {
+	tests := []struct {
+		spec string
+		want string // normalized; empty if the spec is invalid
+	}{
+		{"mon-fri 09:00-17:00; sat 10:00-14:00", "mon-fri 09:00-17:00; sat 10:00-14:00"},
+		{"Monday,wed 9:00-17:00", "mon,wed 09:00-17:00"},
+		{"daily", "daily"},
+		{"mon", "mon"},
+		{"22:00-02:00", "daily 22:00-02:00"},
+		{"fri-mon", "mon,fri-sun"},
+		{"sat,sun", "sat-sun"},
+		{"sun-sat 00:00-24:00", "daily"},
+		{"tue 09:00-24:00", "tue 09:00-24:00"},
+		{" ; mon 08:00-12:00 ;", "mon 08:00-12:00"},
+
+		{"", ""},
+		{";", ""},
+		{"funday", ""},
+		{"mo 09:00-17:00", ""},
+		{"mon 09:00", ""},
+		{"mon 9-17", ""},
+		{"mon 10:60-11:00", ""},
+		{"mon 25:00-26:00", ""},
+		{"mon 24:00-01:00", ""},
+		{"mon 24:30-01:00", ""},
+		{"mon tue 09:00-17:00", ""},
+	}
+	for _, tt := range tests {
+		a, err := ParseAvailability(tt.spec, "")
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("ParseAvailability(%q) = %q, want an error", tt.spec, a)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseAvailability(%q): %v", tt.spec, err)
+			continue
+		}
+		if got := a.String(); got != tt.want {
+			t.Errorf("ParseAvailability(%q).String() = %q, want %q", tt.spec, got, tt.want)
+		}
+		// The normalized form parses to itself.
+		if again, err := ParseAvailability(a.String(), ""); err != nil || again.String() != tt.want {
+			t.Errorf("ParseAvailability(%q) doesn't round-trip: %v, %v", a.String(), again, err)
+		}
+	}
+}
+
+func TestParseAvailabilityZone(t *testing.T) {
+	a, err := ParseAvailability("daily", "Europe/Berlin")
+	if err != nil || a.Location.String() != "Europe/Berlin" {
+		t.Errorf("ParseAvailability with a zone = %v, %v", a, err)
+	}
+	if a, err := ParseAvailability("daily", ""); err != nil || a.Location != time.Local {
+		t.Errorf("ParseAvailability without a zone = %v, %v; want the local zone", a, err)
+	}
+	if _, err := ParseAvailability("daily", "Mars/Olympus_Mons"); err == nil {
+		t.Error("ParseAvailability with an unknown zone: want an error")
+	}
+}
+
+func mustLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func TestAvailabilityIsOpen(t *testing.T) {
+	berlin := mustLocation(t, "Europe/Berlin")
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, time.October, day, hour, minute, 0, 0, berlin)
+	}
+	// October 2026: the 19th is a Monday, the 23rd a Friday, the 25th a Sunday.
+	tests := []struct {
+		spec string
+		t    time.Time
+		want bool
+	}{
+		{"mon-fri 09:00-17:00", at(21, 10, 0), true},
+		{"mon-fri 09:00-17:00", at(21, 9, 0), true},
+		{"mon-fri 09:00-17:00", at(21, 8, 59), false},
+		{"mon-fri 09:00-17:00", at(21, 17, 0), false},
+		{"mon-fri 09:00-17:00", at(24, 10, 0), false},
+
+		// Overnight windows belong to the day they start on.
+		{"fri 22:00-02:00", at(23, 21, 59), false},
+		{"fri 22:00-02:00", at(23, 23, 0), true},
+		{"fri 22:00-02:00", at(24, 1, 59), true},
+		{"fri 22:00-02:00", at(24, 2, 0), false},
+		{"fri 22:00-02:00", at(22, 23, 0), false},
+		{"fri 22:00-02:00", at(23, 1, 0), false},
+
+		// Day ranges wrap around the end of the week.
+		{"fri-mon", at(25, 12, 0), true},
+		{"fri-mon", at(19, 0, 0), true},
+		{"fri-mon", at(20, 12, 0), false},
+
+		{"daily", at(22, 3, 0), true},
+		{"tue 09:00-24:00", at(20, 23, 59), true},
+		{"tue 09:00-24:00", at(21, 0, 0), false},
+		{"mon 10:00-12:00; wed 14:00-16:00", at(21, 15, 0), true},
+		{"mon 10:00-12:00; wed 14:00-16:00", at(21, 11, 0), false},
+	}
+	for _, tt := range tests {
+		a, err := ParseAvailability(tt.spec, "Europe/Berlin")
+		if err != nil {
+			t.Fatalf("ParseAvailability(%q): %v", tt.spec, err)
+		}
+		if got := a.IsOpen(tt.t); got != tt.want {
+			t.Errorf("%q IsOpen(%s) = %v, want %v", tt.spec, tt.t.Format("Mon 15:04"), got, tt.want)
+		}
+	}
+}
+
+func TestAvailabilityIsOpenZone(t *testing.T) {
+	a, err := ParseAvailability("mon-fri 09:00-17:00", "America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 14:00 UTC on Wednesday is 10:00 in New York, 20:00 UTC is 16:00.
+	for _, tt := range []struct {
+		t    time.Time
+		want bool
+	}{
+		{time.Date(2026, time.October, 21, 14, 0, 0, 0, time.UTC), true},
+		{time.Date(2026, time.October, 21, 12, 0, 0, 0, time.UTC), false},
+		{time.Date(2026, time.October, 21, 20, 59, 0, 0, time.UTC), true},
+		{time.Date(2026, time.October, 21, 21, 0, 0, 0, time.UTC), false},
+	} {
+		if got := a.IsOpen(tt.t); got != tt.want {
+			t.Errorf("IsOpen(%s) = %v, want %v", tt.t, got, tt.want)
+		}
+	}
+}
+
+func TestAvailabilityIsOpenDST(t *testing.T) {
+	// Summer time in Berlin ends on 25 October 2026: 03:00 CEST becomes
+	// 02:00 CET, at 01:00 UTC. Wall-clock windows follow the local time.
+	a, err := ParseAvailability("sun 01:00-04:00", "Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		utc  string
+		want bool
+	}{
+		{"2026-10-24T22:59:00Z", false}, // 00:59 CEST
+		{"2026-10-24T23:00:00Z", true},  // 01:00 CEST
+		{"2026-10-25T00:30:00Z", true},  // 02:30 CEST
+		{"2026-10-25T01:30:00Z", true},  // 02:30 CET, the second time
+		{"2026-10-25T02:59:00Z", true},  // 03:59 CET
+		{"2026-10-25T03:00:00Z", false}, // 04:00 CET
+	} {
+		at, _ := time.Parse(time.RFC3339, tt.utc)
+		if got := a.IsOpen(at); got != tt.want {
+			t.Errorf("IsOpen(%s) = %v, want %v", tt.utc, got, tt.want)
+		}
+	}
+}
+
+func TestAvailabilityNextOpen(t *testing.T) {
+	berlin := mustLocation(t, "Europe/Berlin")
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, time.October, day, hour, minute, 0, 0, berlin)
+	}
+	tests := []struct {
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		// Open already: now.
+		{spec: "mon-fri 09:00-17:00", from: at(21, 10, 0), want: at(21, 10, 0)},
+		{spec: "fri 22:00-02:00", from: at(24, 1, 0), want: at(24, 1, 0)},
+		// Later the same day.
+		{spec: "mon-fri 09:00-17:00", from: at(21, 7, 30), want: at(21, 9, 0)},
+		{spec: "fri 22:00-02:00", from: at(23, 18, 0), want: at(23, 22, 0)},
+		// After closing: the next day.
+		{spec: "mon-fri 09:00-17:00", from: at(21, 17, 0), want: at(22, 9, 0)},
+		// Over the weekend, and across the end of summer time on the 25th:
+		// 09:00 CET on Monday is 08:00 UTC.
+		{spec: "mon-fri 09:00-17:00", from: at(24, 12, 0), want: time.Date(2026, time.October, 26, 8, 0, 0, 0, time.UTC)},
+		// The earliest of several windows.
+		{spec: "wed 14:00-16:00; tue 10:00-11:00", from: at(19, 12, 0), want: at(20, 10, 0)},
+		// A week ahead.
+		{spec: "wed 14:00-16:00", from: at(21, 16, 0), want: at(28, 14, 0)},
+	}
+	for _, tt := range tests {
+		a, err := ParseAvailability(tt.spec, "Europe/Berlin")
+		if err != nil {
+			t.Fatalf("ParseAvailability(%q): %v", tt.spec, err)
+		}
+		if got := a.NextOpen(tt.from); !got.Equal(tt.want) {
+			t.Errorf("%q NextOpen(%s) = %s, want %s", tt.spec, tt.from, got, tt.want)
+		}
+	}
+}
+
+func TestIsAvailable(t *testing.T) {
+	now := time.Date(2026, time.October, 21, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		fd   FileData
+		want bool
+	}{
+		{FileData{}, true},
+		{FileData{Availability: "wed", TimeZone: "UTC"}, true},
+		{FileData{Availability: "thu", TimeZone: "UTC"}, false},
+		// Broken schedules fail closed.
+		{FileData{Availability: "funday", TimeZone: "UTC"}, false},
+		{FileData{Availability: "wed", TimeZone: "Mars/Olympus_Mons"}, false},
+	}
+	for _, tt := range tests {
+		if got := IsAvailable(tt.fd, now); got != tt.want {
+			t.Errorf("IsAvailable(%q, %q) = %v, want %v", tt.fd.Availability, tt.fd.TimeZone, got, tt.want)
+		}
+	}
+}
@@ -12,19 +12,54 @@ import (
 const defaultConfigPath = "./data.json"
 
 var configDefaults = Config{
-	Port:                   27182,
-	MaxPostSize:            94371840,
-	ChunkInactivityTimeout: 1800,
-	AdminUsername:          "admin",
+	Port:                    27182,
+	MaxPostSize:             94371840,
+	ChunkInactivityTimeout:  1800,
+	ChunkAssemblyMode:       "concat",
+	ListingPageSize:         500,
+	SearchMaxResults:        200,
+	SearchTimeout:           5,
+	MaxConcurrentArchives:   4,
+	ThumbnailCacheDir:       "thumbnails", // see ThumbnailDir
+	PreviewMaxSize:          2 << 20,
+	ArchiveBrowseMaxEntries: 100000,
+	ArchiveBrowseMaxBytes:   8 << 30,
+	DefaultLanguage:         "en",
+	TrustedProxies:          []string{"127.0.0.1", "::1"},
+	AdminUsername:           "admin",
 	// AdminPassword intentionally has no default.
 	// A blank password means the user will be redirected to a setup page to set a password on first run.
 }
 
-// FileInfo holds the name, path, and type of a file or directory.
+// ArchiveFormats lists the supported ?download= archive formats, in the order
+// they are offered to visitors.
+var ArchiveFormats = []string{"zip", "tar", "tar.gz", "tar.zst"}
+
+// Visitor permissions of a share; see FileData.Permissions.
+const (
+	PermRead   = "read"   // list directories and download files
+	PermUpload = "upload" // add files
+	PermMkdir  = "mkdir"  // create folders
+	PermRename = "rename" // rename files and folders
+	PermDelete = "delete" // delete files and empty folders
+)
+
+// Permissions lists the visitor permissions in the order they are shown.
+var Permissions = []string{PermRead, PermUpload, PermMkdir, PermRename, PermDelete}
+
+// DefaultCacheControl lets browsers keep copies of a share's pages and files
+// but makes them revalidate on every use, so changes show up right away.
+const DefaultCacheControl = "private, no-cache"
+
+// FileInfo holds the metadata of a file or directory shown in a listing.
 type FileInfo struct {
-	Name  string
-	Path  string
-	IsDir bool
+	Name       string
+	Path       string
+	IsDir      bool
+	Size       int64  // bytes; 0 for directories
+	ModTime    int64  // unix timestamp
+	MimeType   string // empty for directories
+	ChildCount int    // visible entries; directories only
 }
 
 // FileData holds the sharing configuration for a single share.
@@ -36,16 +71,110 @@ type FileData struct {
 	Expired    bool   `json:"expired"`
 	AllowPost  bool   `json:"allow_post"`
 	Password   string `json:"password"`
+
+	// Activation is when the share goes live, as a unix timestamp. Before
+	// then visitors are told when to come back. 0 means right away.
+	Activation int64 `json:"activation,omitempty"`
+	// Availability limits the share to recurring windows such as
+	// "mon-fri 09:00-17:00" (see Availability), in TimeZone, an IANA name;
+	// empty is the server's. An empty Availability means always.
+	Availability string `json:"availability,omitempty"`
+	TimeZone     string `json:"time_zone,omitempty"`
+
+	// MaxVisitors and MaxDownloadsPerFile count uses per visitor instead of
+	// Uses: how many distinct visitors may open the share, and how often
+	// each of them may download each file. 0 means no limit; the mode is on
+	// when either is set (see CountsVisitors).
+	MaxVisitors         int `json:"max_visitors,omitempty"`
+	MaxDownloadsPerFile int `json:"max_downloads_per_file,omitempty"`
+	// Visitors holds the per-visitor counters, keyed by visitor ID.
+	Visitors map[string]VisitorUsage `json:"visitors,omitempty"`
+
+	// Permissions lists what visitors may do, from Permissions. Empty means
+	// read, plus upload where AllowPost is set; a non-empty list overrides
+	// AllowPost, which is kept in step with it (see SetPermission).
+	Permissions []string `json:"permissions,omitempty"`
+	// OwnFilesOnly limits rename and delete to entries the visitor uploaded
+	// or created in the same browser session.
+	OwnFilesOnly bool `json:"own_files_only,omitempty"`
+
+	// ArchiveFormats lists the download formats offered for directories,
+	// a subset of ArchiveFormats. Empty means all formats.
+	ArchiveFormats []string `json:"archive_formats,omitempty"`
+	// CacheControl is the Cache-Control header sent with the share's
+	// responses. Empty means DefaultCacheControl.
+	CacheControl string `json:"cache_control,omitempty"`
+
+	// Branding shown on the share's pages; all optional.
+	Title       string `json:"title,omitempty"`        // shown instead of the subpath
+	Message     string `json:"message,omitempty"`      // Markdown, shown above the root listing
+	AccentColor string `json:"accent_color,omitempty"` // #rgb or #rrggbb
+	Logo        string `json:"logo,omitempty"`         // image file on the server, or an http(s) URL
 }
 
 // Config is the top-level application configuration.
 type Config struct {
-	Port                   int                 `json:"port"`
-	MaxPostSize            int                 `json:"maxPostSize"`
-	ChunkInactivityTimeout int                 `json:"chunkInactivityTimeout"`
-	AdminUsername          string              `json:"admin_username"`
-	AdminPassword          string              `json:"admin_password"`
-	Files                  map[string]FileData `json:"files"`
+	Port                    int                 `json:"port"`
+	MaxPostSize             int                 `json:"maxPostSize"`
+	ChunkInactivityTimeout  int                 `json:"chunkInactivityTimeout"`
+	ChunkAssemblyMode       string              `json:"chunkAssemblyMode"`
+	ListingPageSize         int                 `json:"listingPageSize"`
+	SearchMaxResults        int                 `json:"searchMaxResults"`
+	SearchTimeout           int                 `json:"searchTimeout"` // seconds
+	SearchIndex             bool                `json:"searchIndex"`
+	MaxConcurrentArchives   int                 `json:"maxConcurrentArchives"`
+	ThumbnailCacheDir       string              `json:"thumbnailCacheDir"` // relative to CacheDir unless absolute
+	PreviewMaxSize          int                 `json:"previewMaxSize"`    // bytes
+	ArchiveBrowseMaxEntries int                 `json:"archiveBrowseMaxEntries"`
+	ArchiveBrowseMaxBytes   int                 `json:"archiveBrowseMaxBytes"`
+	AssetsDir               string              `json:"assetsDir"`       // overrides the embedded web assets
+	DefaultLanguage         string              `json:"defaultLanguage"` // of visitor pages without a preference
+	TrustedProxies          []string            `json:"trustedProxies"`  // addresses or CIDR ranges whose X-Forwarded-* headers are believed
+	AdminUsername           string              `json:"admin_username"`
+	AdminPassword           string              `json:"admin_password"`
+	Files                   map[string]FileData `json:"files"`
+
+	// LinkSecret signs direct links to files (see SignedLink) and visitor
+	// cookies. The server generates it at startup if missing; changing it
+	// revokes all links.
+	LinkSecret string `json:"linkSecret,omitempty"`
+	// LinkUses counts the downloads of signed links with a use limit, keyed
+	// by signature. Entries are dropped once their link has expired.
+	LinkUses map[string]LinkUsage `json:"linkUses,omitempty"`
+}
+
+// VisitorUsage is what a visitor of a share has used, for
+// FileData.MaxVisitors and FileData.MaxDownloadsPerFile.
+type VisitorUsage struct {
+	IP        string         `json:"ip"`                  // last seen from
+	FirstSeen int64          `json:"first_seen"`          // first visit
+	LastSeen  int64          `json:"last_seen"`           // latest visit, to the hour when browsing
+	Downloads map[string]int `json:"downloads,omitempty"` // by share-relative path
+}
+
+// LinkUsage is the download count of a signed link.
+type LinkUsage struct {
+	Uses    int   `json:"uses"`
+	Expires int64 `json:"expires"` // of the link, to know when to forget it
+}
+
+// CacheDir returns the directory caches are kept in: fileshare in the user's
+// cache directory, or in the temp directory if the user has none.
+func CacheDir() string {
+	if dir, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(dir, "fileshare")
+	}
+	return filepath.Join(os.TempDir(), "fileshare")
+}
+
+// ThumbnailDir returns the directory thumbnails are cached in. A relative
+// ThumbnailCacheDir is taken relative to CacheDir rather than the working
+// directory, so the cache doesn't move with wherever the server is started.
+func ThumbnailDir(cfg *Config) string {
+	if filepath.IsAbs(cfg.ThumbnailCacheDir) {
+		return cfg.ThumbnailCacheDir
+	}
+	return filepath.Join(CacheDir(), cfg.ThumbnailCacheDir)
 }
 
 var configCache atomic.Pointer[Config]
@@ -83,12 +212,14 @@ func LoadConfigFrom(path string) (*Config, error) {
 }
 
 // applyDefaults fills in zero-value fields that would break the server if left unset.
+// Negative numbers are replaced too: no setting is meaningful below zero, and
+// some, like a negative maxConcurrentArchives, would hang every request.
 func applyDefaults(cfg *Config) {
 	defaults := reflect.ValueOf(configDefaults)
 	target := reflect.ValueOf(cfg).Elem()
 	for i := range target.NumField() {
 		f := target.Field(i)
-		if f.IsZero() {
+		if f.IsZero() || (f.CanInt() && f.Int() < 0) {
 			f.Set(defaults.Field(i))
 		}
 	}
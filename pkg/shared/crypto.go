@@ -1,6 +1,18 @@
 package shared
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
 
 // HashPassword hashes a plaintext password using bcrypt.
 func HashPassword(password string) (string, error) {
@@ -15,3 +27,93 @@ func HashPassword(password string) (string, error) {
 func CheckPassword(password, hash string) bool {
 	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
 }
+
+// Query parameters of a signed link.
+const (
+	LinkExpiresParam = "expires"
+	LinkUsesParam    = "uses"
+	LinkIPParam      = "ip"
+	LinkSigParam     = "sig"
+)
+
+// SignedLink is a direct link to one file of a share. It works without the
+// share's password until it expires, but not once the share itself has.
+type SignedLink struct {
+	Subpath string
+	Path    string // slash-separated, relative to the share
+	Expires int64  // unix timestamp
+	MaxUses int    // 0 = unlimited
+	IP      string // client address the link is bound to; empty = any
+}
+
+// Signature returns the link's HMAC-SHA256 under secret, base64url-encoded.
+func (l SignedLink) Signature(secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s\n%s\n%d\n%d\n%s", l.Subpath, path.Clean("/"+l.Path), l.Expires, l.MaxUses, l.IP)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether sig is the link's signature under secret.
+func (l SignedLink) Verify(secret, sig string) bool {
+	return secret != "" && hmac.Equal([]byte(sig), []byte(l.Signature(secret)))
+}
+
+// URL returns the link's root-relative, escaped URL, signed with secret.
+func (l SignedLink) URL(secret string) string {
+	q := url.Values{}
+	q.Set(LinkExpiresParam, strconv.FormatInt(l.Expires, 10))
+	if l.MaxUses > 0 {
+		q.Set(LinkUsesParam, strconv.Itoa(l.MaxUses))
+	}
+	if l.IP != "" {
+		q.Set(LinkIPParam, l.IP)
+	}
+	q.Set(LinkSigParam, l.Signature(secret))
+	u := url.URL{Path: path.Join("/", l.Subpath, l.Path), RawQuery: q.Encode()}
+	return u.String()
+}
+
+// NewVisitorID returns a random ID for a new visitor of a share.
+func NewVisitorID() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// VisitorCookieValue returns the value of the cookie that identifies visitor
+// id of the share at subpath: the ID and its HMAC-SHA256 under secret.
+func VisitorCookieValue(secret, subpath, id string) string {
+	return id + "." + visitorSignature(secret, subpath, id)
+}
+
+// ParseVisitorCookie returns the visitor ID of a cookie value made by
+// VisitorCookieValue, if its signature holds.
+func ParseVisitorCookie(secret, subpath, value string) (string, bool) {
+	id, sig, ok := strings.Cut(value, ".")
+	if !ok || secret == "" || !hmac.Equal([]byte(sig), []byte(visitorSignature(secret, subpath, id))) {
+		return "", false
+	}
+	return id, true
+}
+
+func visitorSignature(secret, subpath, id string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "visitor\n%s\n%s", subpath, id)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// EnsureLinkSecret gives cfg a LinkSecret if it has none yet and reports
+// whether it did, in which case the config needs saving.
+func EnsureLinkSecret(cfg *Config) (bool, error) {
+	if cfg.LinkSecret != "" {
+		return false, nil
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return false, err
+	}
+	cfg.LinkSecret = base64.RawURLEncoding.EncodeToString(b)
+	return true, nil
+}
@@ -0,0 +1,106 @@
+package shared
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestSignedLinkVerify(t *testing.T) {
+	const secret = "s3cret"
+	link := SignedLink{Subpath: "docs", Path: "reports/q3.pdf", Expires: 1790000000, MaxUses: 3, IP: "203.0.113.7"}
+	sig := link.Signature(secret)
+
+	tests := []struct {
+		name   string
+		link   SignedLink
+		secret string
+		sig    string
+		want   bool
+	}{
+		{"valid", link, secret, sig, true},
+		{"equivalent path", SignedLink{"docs", "/reports/./q3.pdf", 1790000000, 3, "203.0.113.7"}, secret, sig, true},
+		{"other share", SignedLink{"media", "reports/q3.pdf", 1790000000, 3, "203.0.113.7"}, secret, sig, false},
+		{"other file", SignedLink{"docs", "reports/q4.pdf", 1790000000, 3, "203.0.113.7"}, secret, sig, false},
+		{"extended", SignedLink{"docs", "reports/q3.pdf", 1790003600, 3, "203.0.113.7"}, secret, sig, false},
+		{"more uses", SignedLink{"docs", "reports/q3.pdf", 1790000000, 0, "203.0.113.7"}, secret, sig, false},
+		{"other address", SignedLink{"docs", "reports/q3.pdf", 1790000000, 3, "203.0.113.8"}, secret, sig, false},
+		{"unbound", SignedLink{"docs", "reports/q3.pdf", 1790000000, 3, ""}, secret, sig, false},
+		{"other secret", link, "other", sig, false},
+		{"tampered signature", link, secret, sig[:len(sig)-1] + "A", false},
+		{"no signature", link, secret, "", false},
+		// Without a secret nothing verifies, not even a signature made
+		// with the empty secret.
+		{"no secret", link, "", link.Signature(""), false},
+	}
+	for _, tt := range tests {
+		if got := tt.link.Verify(tt.secret, tt.sig); got != tt.want {
+			t.Errorf("%s: Verify = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSignedLinkURL(t *testing.T) {
+	const secret = "s3cret"
+	tests := []struct {
+		link     SignedLink
+		wantPath string
+		wantKeys []string
+	}{
+		{SignedLink{Subpath: "docs", Path: "a b/c.txt", Expires: 1790000000}, "/docs/a b/c.txt", []string{LinkExpiresParam, LinkSigParam}},
+		{SignedLink{Subpath: "docs", Path: "c.txt", Expires: 1790000000, MaxUses: 2, IP: "::1"}, "/docs/c.txt", []string{LinkExpiresParam, LinkIPParam, LinkSigParam, LinkUsesParam}},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.link.URL(secret))
+		if err != nil {
+			t.Fatalf("URL() = %q: %v", tt.link.URL(secret), err)
+		}
+		if u.Path != tt.wantPath {
+			t.Errorf("URL() path = %q, want %q", u.Path, tt.wantPath)
+		}
+		q := u.Query()
+		var keys []string
+		for _, k := range []string{LinkExpiresParam, LinkIPParam, LinkSigParam, LinkUsesParam} {
+			if q.Has(k) {
+				keys = append(keys, k)
+			}
+		}
+		if strings.Join(keys, ",") != strings.Join(tt.wantKeys, ",") {
+			t.Errorf("URL() query = %q, want the keys %v", u.RawQuery, tt.wantKeys)
+		}
+		if !tt.link.Verify(secret, q.Get(LinkSigParam)) {
+			t.Errorf("URL() = %q: its signature doesn't verify", u)
+		}
+	}
+}
+
+func TestParseVisitorCookie(t *testing.T) {
+	const secret = "s3cret"
+	value := VisitorCookieValue(secret, "docs", "abc123")
+	id, sig, _ := strings.Cut(value, ".")
+
+	tests := []struct {
+		name    string
+		secret  string
+		subpath string
+		value   string
+		wantID  string
+		wantOK  bool
+	}{
+		{"valid", secret, "docs", value, "abc123", true},
+		{"other share", secret, "media", value, "", false},
+		{"other secret", "other", "docs", value, "", false},
+		{"tampered ID", secret, "docs", "abc124." + sig, "", false},
+		{"tampered signature", secret, "docs", id + "." + sig[:len(sig)-1] + "A", "", false},
+		{"no signature", secret, "docs", id, "", false},
+		{"empty signature", secret, "docs", id + ".", "", false},
+		{"empty", secret, "docs", "", "", false},
+		{"no secret", "", "docs", VisitorCookieValue("", "docs", "abc123"), "", false},
+	}
+	for _, tt := range tests {
+		gotID, gotOK := ParseVisitorCookie(tt.secret, tt.subpath, tt.value)
+		if gotID != tt.wantID || gotOK != tt.wantOK {
+			t.Errorf("%s: ParseVisitorCookie = %q, %v, want %q, %v", tt.name, gotID, gotOK, tt.wantID, tt.wantOK)
+		}
+	}
+}
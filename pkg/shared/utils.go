@@ -4,6 +4,8 @@ import (
 	"crypto/rand"
 	"fmt"
 	"math/big"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -19,24 +21,185 @@ func IsExpired(fd FileData) bool {
 		(fd.Expiration != 0 && fd.Expiration < time.Now().Unix())
 }
 
+// IsPending reports whether a share is scheduled to go live later.
+func IsPending(fd FileData) bool {
+	return fd.Activation != 0 && fd.Activation > time.Now().Unix()
+}
+
+// CountsVisitors reports whether a share counts uses per visitor, with
+// MaxVisitors or MaxDownloadsPerFile, instead of with Uses.
+func CountsVisitors(fd FileData) bool {
+	return fd.MaxVisitors > 0 || fd.MaxDownloadsPerFile > 0
+}
+
+// OfferedArchiveFormats returns the archive formats a share offers,
+// in the order of ArchiveFormats.
+func OfferedArchiveFormats(fd FileData) []string {
+	if len(fd.ArchiveFormats) == 0 {
+		return ArchiveFormats
+	}
+	var offered []string
+	for _, f := range ArchiveFormats {
+		if slices.Contains(fd.ArchiveFormats, f) {
+			offered = append(offered, f)
+		}
+	}
+	return offered
+}
+
+// HasPermission reports whether visitors of a share may do perm.
+func HasPermission(fd FileData, perm string) bool {
+	if len(fd.Permissions) == 0 {
+		return perm == PermRead || perm == PermUpload && fd.AllowPost
+	}
+	return slices.Contains(fd.Permissions, perm)
+}
+
+// EffectivePermissions returns what visitors of a share may do, in the order
+// of Permissions.
+func EffectivePermissions(fd FileData) []string {
+	var perms []string
+	for _, p := range Permissions {
+		if HasPermission(fd, p) {
+			perms = append(perms, p)
+		}
+	}
+	return perms
+}
+
+// SetPermission grants or revokes perm. Shares without an explicit list only
+// get one once something other than upload changes; AllowPost always follows
+// the upload permission.
+func SetPermission(fd *FileData, perm string, granted bool) {
+	if len(fd.Permissions) == 0 && perm == PermUpload {
+		fd.AllowPost = granted
+		return
+	}
+	perms := slices.DeleteFunc(EffectivePermissions(*fd), func(p string) bool { return p == perm })
+	if granted {
+		perms = append(perms, perm)
+	}
+	SetPermissions(fd, perms)
+}
+
+// SetPermissions replaces the permissions of a share, in the order of
+// Permissions, and sets AllowPost to match.
+func SetPermissions(fd *FileData, perms []string) {
+	fd.Permissions = []string{}
+	for _, p := range Permissions {
+		if slices.Contains(perms, p) {
+			fd.Permissions = append(fd.Permissions, p)
+		}
+	}
+	fd.AllowPost = slices.Contains(fd.Permissions, PermUpload)
+}
+
+// ValidatePermissions checks that perms only names known permissions.
+func ValidatePermissions(perms []string) error {
+	for _, p := range perms {
+		if !slices.Contains(Permissions, p) {
+			return fmt.Errorf("unknown permission %q (use %s)", p, strings.Join(Permissions, ", "))
+		}
+	}
+	return nil
+}
+
+// cacheDirectives are the Cache-Control directives a share may use, and
+// whether each takes a number of seconds.
+var cacheDirectives = map[string]bool{
+	"public": false, "private": false, "no-cache": false, "no-store": false,
+	"no-transform": false, "must-revalidate": false, "proxy-revalidate": false,
+	"immutable": false, "max-age": true, "s-maxage": true,
+	"stale-while-revalidate": true, "stale-if-error": true,
+}
+
+// ValidateCacheControl checks a share's Cache-Control value: a comma-separated
+// list of known response directives, e.g. "public, max-age=3600".
+func ValidateCacheControl(v string) error {
+	for d := range strings.SplitSeq(v, ",") {
+		name, value, hasValue := strings.Cut(strings.TrimSpace(d), "=")
+		name = strings.ToLower(name)
+		wantsSeconds, known := cacheDirectives[name]
+		if !known {
+			return fmt.Errorf("unknown Cache-Control directive %q", name)
+		}
+		if wantsSeconds != hasValue {
+			if wantsSeconds {
+				return fmt.Errorf("%s needs a number of seconds, e.g. %s=3600", name, name)
+			}
+			return fmt.Errorf("%s takes no value", name)
+		}
+		if n, err := strconv.Atoi(value); hasValue && (err != nil || n < 0) {
+			return fmt.Errorf("%s: %q is not a number of seconds", name, value)
+		}
+	}
+	return nil
+}
+
+// logoExts are the image types accepted as share logos.
+var logoExts = []string{".png", ".jpg", ".jpeg", ".gif", ".webp", ".svg"}
+
+// ValidateAccentColor checks a share's accent color: #rgb or #rrggbb.
+func ValidateAccentColor(c string) error {
+	if len(c) != 4 && len(c) != 7 || c[0] != '#' {
+		return fmt.Errorf("accent color %q must look like #1e88e5 or #18e", c)
+	}
+	if _, err := strconv.ParseUint(c[1:], 16, 32); err != nil {
+		return fmt.Errorf("accent color %q must look like #1e88e5 or #18e", c)
+	}
+	return nil
+}
+
+// ValidateLogo checks a share's logo: an http(s) URL or the absolute path of
+// an image file.
+func ValidateLogo(logo string) error {
+	if strings.HasPrefix(logo, "https://") || strings.HasPrefix(logo, "http://") {
+		return nil
+	}
+	if !filepath.IsAbs(logo) {
+		return fmt.Errorf("logo must be an absolute path or an http(s) URL")
+	}
+	if !slices.Contains(logoExts, strings.ToLower(filepath.Ext(logo))) {
+		return fmt.Errorf("logo must be one of %s", strings.Join(logoExts, ", "))
+	}
+	return nil
+}
+
 // ParseExpiration parses a human-readable expiration string into a Unix timestamp.
 // Accepts: "" / "0" / "never" → 0, a plain unix timestamp, or a duration
 // suffix: 24h, 7d, 2w, 3m, 1y.
 func ParseExpiration(s string) (int64, error) {
-	s = strings.TrimSpace(s)
-	if s == "" || s == "0" || s == "never" {
+	if s = strings.TrimSpace(s); s == "never" {
+		return 0, nil
+	}
+	return parseTimestamp(s, "expiration")
+}
+
+// ParseActivation parses when a share should go live, in the syntax of
+// ParseExpiration: "" / "0" / "now" → 0, i.e. right away.
+func ParseActivation(s string) (int64, error) {
+	if s = strings.TrimSpace(s); s == "now" {
+		return 0, nil
+	}
+	return parseTimestamp(s, "activation")
+}
+
+// parseTimestamp parses "" / "0", a unix timestamp or a duration from now;
+// what names the value in errors.
+func parseTimestamp(s, what string) (int64, error) {
+	if s == "" || s == "0" {
 		return 0, nil
 	}
 	if ts, err := strconv.ParseInt(s, 10, 64); err == nil {
 		return ts, nil
 	}
 	if len(s) < 2 {
-		return 0, fmt.Errorf("invalid expiration %q — use e.g. 24h, 7d, 2w, 3m, 1y or a unix timestamp", s)
+		return 0, fmt.Errorf("invalid %s %q — use e.g. 24h, 7d, 2w, 3m, 1y or a unix timestamp", what, s)
 	}
 	unit := s[len(s)-1]
 	num, err := strconv.Atoi(s[:len(s)-1])
 	if err != nil {
-		return 0, fmt.Errorf("invalid expiration %q: %w", s, err)
+		return 0, fmt.Errorf("invalid %s %q: %w", what, s, err)
 	}
 	now := time.Now()
 	switch unit {
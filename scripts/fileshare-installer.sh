@@ -115,9 +115,6 @@ do_install() {
     restorecon -v "$INSTALL_DIR/fileshare-backend"   2>/dev/null || true
     restorecon -v "$INSTALL_DIR/fileshare-interface" 2>/dev/null || true
 
-    log "Copying assets..."
-    cp -r "$REPO_ROOT/assets/web" "$INSTALL_DIR/web"
-
     if [ ! -f "$INSTALL_DIR/data.json" ]; then
         log "Creating initial data.json from example config..."
         cp "$REPO_ROOT/configs/data.example.json" "$INSTALL_DIR/data.json"
@@ -156,8 +153,10 @@ do_update() {
     cp "$BACKEND_BIN" "$INSTALL_DIR/fileshare-backend"
     cp "$CLI_BIN"     "$INSTALL_DIR/fileshare-interface"
     chmod +x "$INSTALL_DIR/fileshare-backend" "$INSTALL_DIR/fileshare-interface"
-    rm -rf "$INSTALL_DIR/web"
-    cp -r  "$REPO_ROOT/assets/web" "$INSTALL_DIR/web"
+    if [ -d "$INSTALL_DIR/web" ]; then
+        warn "$INSTALL_DIR/web is no longer used – web assets are built into the binary."
+        warn "To keep customized files, set \"assetsDir\": \"./web\" in data.json; otherwise delete it."
+    fi
     restorecon -v "$INSTALL_DIR/fileshare-backend"   2>/dev/null || true
     restorecon -v "$INSTALL_DIR/fileshare-interface" 2>/dev/null || true
 